@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/donniet/mirror2/aqi"
+)
+
+type airQualityElement struct {
+	visible     bool
+	index       int
+	category    aqi.Category
+	dominant    string
+	pollutants  map[string]pollutantState
+	pollen      []pollenState
+	hasPollen   bool
+	data        *AirQuality
+	location    Location
+	newProvider airQualityProviderFactory
+	provider    AirQualityProvider
+	client      *http.Client
+	changed     chan bool
+	fetcher     *fetcher
+	lastUpdated time.Time
+	stale       bool
+	err         error
+	lock        *sync.Mutex
+}
+
+type pollutantState struct {
+	Concentration float64 `json:"concentration"`
+	Unit          string  `json:"unit"`
+	Index         int     `json:"aqi"`
+	aqi.Category
+}
+
+type pollenState struct {
+	Kind   string  `json:"kind"`
+	Grains float64 `json:"grains"`
+	Level  string  `json:"level"`
+	Color  string  `json:"color"`
+}
+
+// averaging periods and units the EPA uses for each pollutant
+var pollutantAverages = map[string]struct {
+	Period time.Duration
+	Unit   string
+	Value  func(h AirQualityHour) float64
+}{
+	aqi.PM25:  {24 * time.Hour, "µg/m³", func(h AirQualityHour) float64 { return h.PM25 }},
+	aqi.PM10:  {24 * time.Hour, "µg/m³", func(h AirQualityHour) float64 { return h.PM10 }},
+	aqi.Ozone: {8 * time.Hour, "ppb", func(h AirQualityHour) float64 { return h.Ozone }},
+	aqi.NO2:   {time.Hour, "ppb", func(h AirQualityHour) float64 { return h.NO2 }},
+}
+
+func newAirQualityElement(newProvider airQualityProviderFactory, location Location, changed chan bool, frequency time.Duration) (e *airQualityElement) {
+	e = &airQualityElement{
+		visible:     false,
+		index:       -1,
+		pollutants:  make(map[string]pollutantState),
+		location:    location,
+		newProvider: newProvider,
+		provider:    newProvider(location),
+		changed:     changed,
+		stale:       true,
+		lock:        &sync.Mutex{},
+		client:      &http.Client{Transport: newFetchTransport()},
+	}
+	e.fetcher = newFetcher("air quality", frequency, e.fetchAirQuality)
+	go e.fetcher.run()
+	return e
+}
+
+func (e *airQualityElement) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			if err := json.Unmarshal(*msg, e); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(e)
+		return (*json.RawMessage)(&b), err
+	}
+
+	if path[0] == "visible" && len(path) == 1 {
+		if msg != nil {
+			var vis bool
+			if err := json.Unmarshal(*msg, &vis); err != nil {
+				return nil, err
+			}
+			e.setVisible(vis)
+		}
+		b, err := json.Marshal(e.Visible())
+		return (*json.RawMessage)(&b), err
+	}
+
+	return serveValuePath(e, path)
+}
+
+func (e *airQualityElement) UnmarshalJSON(b []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if v, ok := m["visible"]; ok {
+		vis := false
+		if vis, ok = v.(bool); !ok {
+			return fmt.Errorf("airQuality visible must be a boolean")
+		}
+		e.setVisible(vis)
+	}
+	return nil
+}
+
+func (e *airQualityElement) MarshalJSON() ([]byte, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	r := map[string]interface{}{
+		"visible":     e.visible,
+		"aqi":         e.index,
+		"category":    e.category.Name,
+		"color":       e.category.Color,
+		"dominant":    e.dominant,
+		"pollutants":  e.pollutants,
+		"pollen":      e.pollen,
+		"provider":    e.provider.Name(),
+		"lastUpdated": e.lastUpdated,
+		"stale":       e.stale,
+	}
+	if e.data != nil && !e.hasPollen {
+		// rather than an empty list, which would read as no pollen
+		r["pollen"] = "unavailable"
+	}
+	if e.err != nil {
+		r["error"] = e.err.Error()
+	}
+	return json.Marshal(r)
+}
+
+// Close stops fetching the air quality
+func (e *airQualityElement) Close() {
+	e.fetcher.Close()
+}
+
+func (e *airQualityElement) fetchAirQuality() error {
+	e.lock.Lock()
+	provider := e.provider
+	e.lock.Unlock()
+
+	data, err := provider.AirQuality(e.client)
+	if err == nil && len(data.Hours) == 0 {
+		err = fmt.Errorf("%s air quality does not contain any hours", provider.Name())
+	}
+
+	now := time.Now()
+
+	e.lock.Lock()
+	if provider != e.provider {
+		// the location changed while fetching
+		e.lock.Unlock()
+		return nil
+	}
+	e.err = err
+	modified := false
+	if err == nil {
+		e.data = data
+		e.lastUpdated = now
+		modified = e.update(now)
+	}
+	if stale := e.fetcher.Stale(e.lastUpdated, now); stale != e.stale {
+		e.stale = stale
+		modified = true
+	}
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+	return err
+}
+
+// update computes the index from the EPA averaging period of each pollutant
+// ending at now, and today's peak pollen counts.  Pollen is unavailable when
+// the provider has no counts at all for the location, open-meteo only
+// forecasts it for Europe.  It returns true if anything changed.  The lock
+// must be held.
+func (e *airQualityElement) update(now time.Time) bool {
+	if e.data == nil {
+		return false
+	}
+
+	index, dominant := -1, ""
+	pollutants := make(map[string]pollutantState)
+	for name, avg := range pollutantAverages {
+		c := averageAirQuality(e.data.Hours, now.Add(-avg.Period), now, avg.Value)
+		i, ok := aqi.Index(name, c)
+		if !ok {
+			continue
+		}
+		pollutants[name] = pollutantState{
+			Concentration: math.Round(c*10) / 10,
+			Unit:          avg.Unit,
+			Index:         i,
+			Category:      aqi.CategoryOf(i),
+		}
+		if i > index || (i == index && name < dominant) {
+			index, dominant = i, name
+		}
+	}
+
+	var pollen []pollenState
+	hasPollen := false
+	peaks := make(map[string]float64)
+	for _, h := range e.data.Hours {
+		for _, grains := range h.Pollen {
+			hasPollen = hasPollen || !math.IsNaN(grains)
+		}
+
+		y, m, d := h.Time.Date()
+		ny, nm, nd := now.In(h.Time.Location()).Date()
+		if y != ny || m != nm || d != nd {
+			continue
+		}
+		for kind, grains := range h.Pollen {
+			if p, ok := peaks[kind]; !math.IsNaN(grains) && (!ok || grains > p) {
+				peaks[kind] = grains
+			}
+		}
+	}
+	for kind, grains := range peaks {
+		if level, ok := aqi.PollenLevel(kind, grains); ok {
+			pollen = append(pollen, pollenState{kind, grains, level.Name, level.Color})
+		}
+	}
+	sort.Slice(pollen, func(i, j int) bool {
+		if pollen[i].Grains != pollen[j].Grains {
+			return pollen[i].Grains > pollen[j].Grains
+		}
+		return pollen[i].Kind < pollen[j].Kind
+	})
+
+	category := aqi.Category{}
+	if index >= 0 {
+		category = aqi.CategoryOf(index)
+	}
+
+	same := index == e.index && dominant == e.dominant && hasPollen == e.hasPollen &&
+		reflect.DeepEqual(pollutants, e.pollutants) && reflect.DeepEqual(pollen, e.pollen)
+	e.index = index
+	e.category = category
+	e.dominant = dominant
+	e.pollutants = pollutants
+	e.pollen = pollen
+	e.hasPollen = hasPollen
+	return !same
+}
+
+// averageAirQuality averages the hours in (from, to] ignoring missing values
+func averageAirQuality(hours []AirQualityHour, from time.Time, to time.Time, value func(AirQualityHour) float64) float64 {
+	sum, n := 0.0, 0
+	for _, h := range hours {
+		if !h.Time.After(from) || h.Time.After(to) {
+			continue
+		}
+		if v := value(h); !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum / float64(n)
+}
+
+// setLocation switches to the air quality at loc and refetches if it moved.
+// It doesn't notify so it can be called while handling another change.
+func (e *airQualityElement) setLocation(loc Location) {
+	e.lock.Lock()
+	if e.location == loc {
+		e.lock.Unlock()
+		return
+	}
+	e.location = loc
+	e.provider = e.newProvider(loc)
+	e.lock.Unlock()
+
+	e.fetcher.Refetch()
+}
+
+func (e *airQualityElement) Visible() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.visible
+}
+
+func (e *airQualityElement) Name() string {
+	return "Air Quality"
+}
+
+func (e *airQualityElement) Show() {
+	e.setVisible(true)
+}
+
+func (e *airQualityElement) Hide() {
+	e.setVisible(false)
+}
+
+func (e *airQualityElement) setVisible(vis bool) {
+	e.lock.Lock()
+	modified := e.visible != vis
+	e.visible = vis
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+}
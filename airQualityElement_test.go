@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donniet/mirror2/aqi"
+)
+
+func TestOpenMeteoAirQuality(t *testing.T) {
+	server, requests := fixtureServer(t, map[string]string{
+		"/air-quality": "openmeteo/airquality.json",
+	})
+
+	p := newOpenMeteoAirQualityProvider(44.9778, -93.265)
+	p.baseURL = server.URL
+
+	data, err := p.AirQuality(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := (*requests)[0].URL.Query(); q.Get("past_days") != "1" || q.Get("timezone") != "auto" {
+		t.Errorf("unexpected query %v", q)
+	}
+
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	if len(data.Hours) != 3 {
+		t.Fatalf("got %d hours, want 3", len(data.Hours))
+	}
+	h := data.Hours[2]
+	if !h.Time.Equal(time.Date(2018, 6, 21, 12, 0, 0, 0, chicago)) {
+		t.Errorf("hour = %v, want noon June 21 in Chicago", h.Time)
+	}
+	// gases are converted from µg/m³ to ppb
+	if h.PM25 != 14 || !math.IsNaN(h.PM10) || !approx(h.Ozone, 61.125) || !approx(h.NO2, 40*24.45/46.01) {
+		t.Errorf("unexpected hour %+v", h)
+	}
+
+	e := &airQualityElement{
+		index:      -1,
+		pollutants: make(map[string]pollutantState),
+		provider:   p,
+		data:       data,
+		lock:       &sync.Mutex{},
+	}
+	if !e.update(h.Time) {
+		t.Errorf("update reported no change")
+	}
+
+	want := map[string]int{aqi.PM25: 56, aqi.PM10: 19, aqi.Ozone: 54, aqi.NO2: 20}
+	for name, index := range want {
+		if got := e.pollutants[name].Index; got != index {
+			t.Errorf("%s aqi = %d, want %d", name, got, index)
+		}
+	}
+	if e.index != 56 || e.dominant != aqi.PM25 || e.category.Name != "Moderate" {
+		t.Errorf("aqi = %d %s from %s, want 56 Moderate from pm25", e.index, e.category.Name, e.dominant)
+	}
+
+	// outside Europe the pollen is all null
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	if m["pollen"] != "unavailable" {
+		t.Errorf("pollen = %v, want unavailable", m["pollen"])
+	}
+}
+
+func TestAirQualityPollen(t *testing.T) {
+	now := time.Date(2018, 6, 21, 12, 0, 0, 0, time.UTC)
+	hour := func(h int, grass, birch float64) AirQualityHour {
+		return AirQualityHour{
+			Time: now.Add(time.Duration(h) * time.Hour),
+			PM25: math.NaN(), PM10: math.NaN(), Ozone: math.NaN(), NO2: math.NaN(),
+			Pollen: map[string]float64{"grass": grass, "birch": birch, "ragweed": math.NaN()},
+		}
+	}
+
+	e := &airQualityElement{
+		index:      -1,
+		pollutants: make(map[string]pollutantState),
+		provider:   newOpenMeteoAirQualityProvider(52.52, 13.41),
+		data: &AirQuality{Hours: []AirQualityHour{
+			hour(-13, 900, 900), // yesterday
+			hour(-2, 3, 10),
+			hour(0, 25, 95),
+			hour(4, 12, math.NaN()),
+		}},
+		lock: &sync.Mutex{},
+	}
+	e.update(now)
+
+	want := []pollenState{
+		{"birch", 95, "High", "#FF7E00"},
+		{"grass", 25, "High", "#FF7E00"},
+	}
+	if len(e.pollen) != len(want) {
+		t.Fatalf("pollen = %+v, want %+v", e.pollen, want)
+	}
+	for i := range want {
+		if e.pollen[i] != want[i] {
+			t.Errorf("pollen[%d] = %+v, want %+v", i, e.pollen[i], want[i])
+		}
+	}
+	if e.index != -1 || !e.hasPollen {
+		t.Errorf("aqi = %d, pollen available %v", e.index, e.hasPollen)
+	}
+}
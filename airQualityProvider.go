@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/donniet/mirror2/openmeteo"
+)
+
+// AirQualityProvider fetches hourly pollutant concentrations and pollen
+// counts around now
+type AirQualityProvider interface {
+	Name() string
+	AirQuality(client *http.Client) (*AirQuality, error)
+}
+
+type AirQuality struct {
+	Hours []AirQualityHour
+}
+
+// AirQualityHour holds particulates in µg/m³, gases in ppb and pollen in
+// grains/m³.  Missing values are NaN.
+type AirQualityHour struct {
+	Time   time.Time
+	PM25   float64
+	PM10   float64
+	Ozone  float64
+	NO2    float64
+	Pollen map[string]float64
+}
+
+type airQualityProviderFactory func(loc Location) AirQualityProvider
+
+// newAirQualityProviderFactory returns nil if name is empty, which disables
+// the air quality element
+func newAirQualityProviderFactory(name string) (airQualityProviderFactory, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "openmeteo":
+		return func(loc Location) AirQualityProvider {
+			return newOpenMeteoAirQualityProvider(loc.Latitude, loc.Longitude)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown air quality provider '%s'", name)
+}
+
+type openMeteoAirQualityProvider struct {
+	baseURL   string
+	latitude  float64
+	longitude float64
+}
+
+func newOpenMeteoAirQualityProvider(latitude float64, longitude float64) *openMeteoAirQualityProvider {
+	return &openMeteoAirQualityProvider{
+		baseURL:   "https://air-quality-api.open-meteo.com/v1",
+		latitude:  latitude,
+		longitude: longitude,
+	}
+}
+
+func (p *openMeteoAirQualityProvider) Name() string {
+	return "openmeteo"
+}
+
+// URL requests yesterday as well so the 24 hour particulate averages can be
+// computed
+func (p *openMeteoAirQualityProvider) URL() string {
+	q := url.Values{}
+	q.Set("latitude", fmt.Sprintf("%.4f", p.latitude))
+	q.Set("longitude", fmt.Sprintf("%.4f", p.longitude))
+	q.Set("hourly", "pm2_5,pm10,ozone,nitrogen_dioxide,alder_pollen,birch_pollen,"+
+		"grass_pollen,mugwort_pollen,olive_pollen,ragweed_pollen")
+	q.Set("past_days", "1")
+	q.Set("forecast_days", "2")
+	q.Set("timezone", "auto")
+	return p.baseURL + "/air-quality?" + q.Encode()
+}
+
+// molecular weights used to convert gases from µg/m³ to ppb at 25°C
+const (
+	ozoneMolecularWeight = 48.00
+	no2MolecularWeight   = 46.01
+)
+
+func microgramsToPPB(v float64, molecularWeight float64) float64 {
+	return v * 24.45 / molecularWeight
+}
+
+func (p *openMeteoAirQualityProvider) AirQuality(client *http.Client) (*AirQuality, error) {
+	var r openmeteo.AirQualityResponse
+
+	if err := getJSON(client, p.URL(), &r); err != nil {
+		return nil, err
+	} else if r.Error {
+		return nil, fmt.Errorf("open-meteo error: %s", r.Reason)
+	} else if r.Hourly == nil || len(r.Hourly.Time) == 0 {
+		return nil, fmt.Errorf("open-meteo response does not contain hourly air quality")
+	}
+
+	loc := r.Location()
+	h := r.Hourly
+	ret := &AirQuality{}
+	for i := range h.Time {
+		ret.Hours = append(ret.Hours, AirQualityHour{
+			Time:  h.Date(i, loc),
+			PM25:  nullableAt(h.PM25, i),
+			PM10:  nullableAt(h.PM10, i),
+			Ozone: microgramsToPPB(nullableAt(h.Ozone, i), ozoneMolecularWeight),
+			NO2:   microgramsToPPB(nullableAt(h.NitrogenDioxide, i), no2MolecularWeight),
+			Pollen: map[string]float64{
+				"alder":   nullableAt(h.AlderPollen, i),
+				"birch":   nullableAt(h.BirchPollen, i),
+				"grass":   nullableAt(h.GrassPollen, i),
+				"mugwort": nullableAt(h.MugwortPollen, i),
+				"olive":   nullableAt(h.OlivePollen, i),
+				"ragweed": nullableAt(h.RagweedPollen, i),
+			},
+		})
+	}
+	return ret, nil
+}
+
+func nullableAt(a []*float64, i int) float64 {
+	if i < len(a) && a[i] != nil {
+		return *a[i]
+	}
+	return math.NaN()
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertProvider fetches the active watches and warnings for a location
+type AlertProvider interface {
+	Name() string
+	Alerts(client *http.Client) ([]Alert, error)
+}
+
+type Alert struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Headline  string    `json:"headline"`
+	Summary   string    `json:"summary"`
+	Area      string    `json:"area"`
+	Severity  string    `json:"severity"`
+	Urgency   string    `json:"urgency"`
+	Certainty string    `json:"certainty"`
+	Effective time.Time `json:"effective"`
+	Expires   time.Time `json:"expires"`
+}
+
+// alertSeverities orders the CAP severity levels
+var alertSeverities = map[string]int{
+	"Unknown":  0,
+	"Minor":    1,
+	"Moderate": 2,
+	"Severe":   3,
+	"Extreme":  4,
+}
+
+// alertWakeTime is how long a new alert keeps the display awake, whatever
+// the schedule or motion
+const alertWakeTime = 15 * time.Minute
+
+type alertsElement struct {
+	visible     bool
+	severity    string
+	alerts      map[string]Alert
+	seen        map[string]time.Time
+	dismissed   map[string]bool
+	newProvider alertProviderFactory
+	provider    AlertProvider
+	display     Display
+	client      *http.Client
+	changed     chan bool
+	fetcher     *fetcher
+	err         error
+	lock        *sync.Mutex
+}
+
+func newAlertsElement(newProvider alertProviderFactory, location Location, display Display, severity string, changed chan bool, frequency time.Duration) (e *alertsElement) {
+	e = &alertsElement{
+		visible:     true,
+		severity:    severity,
+		alerts:      make(map[string]Alert),
+		seen:        make(map[string]time.Time),
+		dismissed:   make(map[string]bool),
+		newProvider: newProvider,
+		provider:    newProvider(location),
+		display:     display,
+		changed:     changed,
+		lock:        &sync.Mutex{},
+		client:      &http.Client{Transport: newFetchTransport()},
+	}
+	e.fetcher = newFetcher("alerts", frequency, e.fetchAlerts)
+	return e
+}
+
+// Start fetches the alerts from now on.  It is called once the persisted
+// alerts that have already been seen are restored so they don't wake the
+// display again after a restart.
+func (e *alertsElement) Start() {
+	go e.fetcher.run()
+}
+
+func (e *alertsElement) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			if err := json.Unmarshal(*msg, e); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(e)
+		return (*json.RawMessage)(&b), err
+	}
+
+	switch path[0] {
+	case "alerts":
+		return serveValuePath(e.Alerts(), path[1:])
+	case "visible":
+		if msg != nil {
+			var vis bool
+			if err := json.Unmarshal(*msg, &vis); err != nil {
+				return nil, err
+			}
+			e.setVisible(vis)
+		}
+		return serveValuePath(e.Visible(), path[1:])
+	case "severity":
+		if msg != nil {
+			var severity string
+			if err := json.Unmarshal(*msg, &severity); err != nil {
+				return nil, err
+			} else if err := e.SetSeverity(severity); err != nil {
+				return nil, err
+			}
+		}
+		return serveValuePath(e.Severity(), path[1:])
+	}
+
+	// anything else is treated as an index into the alerts
+	return serveValuePath(e.Alerts(), path)
+}
+
+func (e *alertsElement) UnmarshalJSON(b []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if v, ok := m["visible"]; ok {
+		vis := false
+		if vis, ok = v.(bool); !ok {
+			return fmt.Errorf("alerts visible must be a boolean")
+		}
+		e.setVisible(vis)
+	}
+	if s, ok := m["severity"]; ok {
+		severity := ""
+		if severity, ok = s.(string); !ok {
+			return fmt.Errorf("alerts severity must be a string")
+		} else if err := e.SetSeverity(severity); err != nil {
+			return err
+		}
+	}
+	if s, ok := m["seen"]; ok {
+		seen, ok := s.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("alerts seen must be an object of alert ids and expiry times")
+		}
+		expires := make(map[string]time.Time)
+		for id, v := range seen {
+			vs, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("alerts seen expiry must be a string")
+			}
+			t, err := time.Parse(time.RFC3339, vs)
+			if err != nil {
+				return err
+			}
+			expires[id] = t
+		}
+		e.restoreSeen(expires)
+	}
+	return nil
+}
+
+// restoreSeen adds alerts that were seen before a restart, they are
+// forgotten as usual once they expire and leave the feed
+func (e *alertsElement) restoreSeen(seen map[string]time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for id, expires := range seen {
+		if _, ok := e.seen[id]; !ok {
+			e.seen[id] = expires
+		}
+	}
+}
+
+func (e *alertsElement) MarshalJSON() ([]byte, error) {
+	alerts := e.Alerts()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	seen := make(map[string]string)
+	for id, expires := range e.seen {
+		seen[id] = expires.Format(time.RFC3339)
+	}
+	r := map[string]interface{}{
+		"visible":  e.visible,
+		"severity": e.severity,
+		"alerts":   alerts,
+		"seen":     seen,
+		"provider": e.provider.Name(),
+	}
+	if e.err != nil {
+		r["error"] = e.err.Error()
+	}
+	return json.Marshal(r)
+}
+
+func (e *alertsElement) fetchAlerts() error {
+	e.lock.Lock()
+	provider := e.provider
+	e.lock.Unlock()
+
+	alerts, err := provider.Alerts(e.client)
+
+	e.lock.Lock()
+	if provider != e.provider {
+		// the location changed while fetching
+		e.lock.Unlock()
+		return nil
+	}
+	e.err = err
+	e.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if now := time.Now(); e.update(alerts, now) {
+		log.Printf("new alert at or above %s severity, powering on display", e.Severity())
+		e.wakeDisplay(now)
+	}
+	return nil
+}
+
+// wakeDisplay forces the display awake for alertWakeTime so the scheduler
+// doesn't put it back on standby at its next check.  A longer wake is left
+// alone.
+func (e *alertsElement) wakeDisplay(now time.Time) {
+	policy := e.display.Policy()
+	until := now.Add(alertWakeTime)
+	if policy.WakingUntil().After(until) {
+		return
+	}
+	if err := policy.force(policyAwake, until, reasonAlert); err != nil {
+		log.Printf("error waking the display for an alert: %v", err)
+	}
+}
+
+// update replaces the active alerts, dropping expired ones, and returns true
+// when a new alert at or above the configured severity has arrived.
+func (e *alertsElement) update(alerts []Alert, now time.Time) (wake bool) {
+	e.lock.Lock()
+
+	active := make(map[string]Alert)
+	modified := false
+	for _, a := range alerts {
+		if !a.Expires.IsZero() && a.Expires.Before(now) {
+			continue
+		}
+		active[a.ID] = a
+
+		if _, ok := e.alerts[a.ID]; !ok {
+			// new to the mirror, or restored as seen after a restart
+			modified = true
+		}
+		if _, ok := e.seen[a.ID]; !ok {
+			log.Printf("new alert: %s (%s)", a.Event, a.Severity)
+			modified = true
+			if alertSeverities[a.Severity] >= alertSeverities[e.severity] {
+				wake = true
+			}
+		}
+		e.seen[a.ID] = a.Expires
+	}
+	for id := range e.alerts {
+		if _, ok := active[id]; !ok {
+			modified = true
+		}
+	}
+	// remember expired alerts until they have left the feed so they don't
+	// wake the display again
+	for id, expires := range e.seen {
+		if _, ok := active[id]; !ok && expires.Before(now) {
+			delete(e.seen, id)
+		}
+	}
+	for id := range e.dismissed {
+		if _, ok := active[id]; !ok {
+			delete(e.dismissed, id)
+		}
+	}
+	e.alerts = active
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+	return
+}
+
+// SetLocation switches to the alerts for loc, the alerts for the old location
+// are dropped when the new ones are fetched
+func (e *alertsElement) SetLocation(loc Location) {
+	e.lock.Lock()
+	e.provider = e.newProvider(loc)
+	e.lock.Unlock()
+
+	e.fetcher.Refetch()
+}
+
+// Dismiss hides the current alerts until they are replaced by new ones, it
+// returns false if there was nothing to dismiss
+func (e *alertsElement) Dismiss() bool {
+	e.lock.Lock()
+	modified := false
+	for id := range e.alerts {
+		if !e.dismissed[id] {
+			e.dismissed[id] = true
+			modified = true
+		}
+	}
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+	return modified
+}
+
+// Alerts returns the active alerts that haven't been dismissed, most severe
+// first
+func (e *alertsElement) Alerts() []Alert {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ret := make([]Alert, 0, len(e.alerts))
+	for id, a := range e.alerts {
+		if !e.dismissed[id] {
+			ret = append(ret, a)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if si, sj := alertSeverities[ret[i].Severity], alertSeverities[ret[j].Severity]; si != sj {
+			return si > sj
+		}
+		return ret[i].Effective.Before(ret[j].Effective)
+	})
+	return ret
+}
+
+func (e *alertsElement) Severity() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.severity
+}
+
+func (e *alertsElement) SetSeverity(severity string) error {
+	if _, ok := alertSeverities[severity]; !ok {
+		return fmt.Errorf("alert severity must be one of Unknown, Minor, Moderate, Severe or Extreme")
+	}
+
+	e.lock.Lock()
+	modified := e.severity != severity
+	e.severity = severity
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+	return nil
+}
+
+func (e *alertsElement) Visible() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.visible
+}
+
+func (e *alertsElement) Name() string {
+	return "Weather Alerts"
+}
+
+func (e *alertsElement) Show() {
+	e.setVisible(true)
+}
+
+func (e *alertsElement) Hide() {
+	e.setVisible(false)
+}
+
+func (e *alertsElement) setVisible(vis bool) {
+	e.lock.Lock()
+	modified := e.visible != vis
+	e.visible = vis
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeAlertProvider struct {
+	alerts []Alert
+	err    error
+}
+
+func (p *fakeAlertProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeAlertProvider) Alerts(client *http.Client) ([]Alert, error) {
+	return p.alerts, p.err
+}
+
+func newTestAlertsElement(severity string) *alertsElement {
+	provider := &fakeAlertProvider{}
+	return newAlertsElement(func(loc Location) AlertProvider { return provider }, Location{}, nil, severity, make(chan bool, 10), time.Hour)
+}
+
+func TestAlertsWakeOnce(t *testing.T) {
+	e := newTestAlertsElement("Severe")
+	now := time.Now()
+	warning := Alert{ID: "1", Event: "Tornado Warning", Severity: "Extreme", Expires: now.Add(time.Hour)}
+	advisory := Alert{ID: "2", Event: "Wind Advisory", Severity: "Moderate", Expires: now.Add(time.Hour)}
+
+	if !e.update([]Alert{warning}, now) {
+		t.Error("a new extreme alert should wake the display")
+	}
+	if e.update([]Alert{warning}, now) {
+		t.Error("an alert that was already seen should not wake the display")
+	}
+	if e.update([]Alert{warning, advisory}, now) {
+		t.Error("an alert below the severity should not wake the display")
+	}
+	if len(e.Alerts()) != 2 || e.Alerts()[0].ID != "1" {
+		t.Errorf("alerts should be most severe first: %+v", e.Alerts())
+	}
+	if e.update([]Alert{warning}, now.Add(2*time.Hour)); len(e.Alerts()) != 0 {
+		t.Errorf("expired alerts should be dropped: %+v", e.Alerts())
+	}
+}
+
+func TestAlertsSeenPersisted(t *testing.T) {
+	now := time.Now()
+	warning := Alert{ID: "1", Event: "Tornado Warning", Severity: "Extreme", Expires: now.Add(time.Hour)}
+
+	before := newTestAlertsElement("Severe")
+	before.update([]Alert{warning}, now)
+	b, err := json.Marshal(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted mirror restores what it has seen before fetching
+	after := newTestAlertsElement("Severe")
+	if err := json.Unmarshal(b, after); err != nil {
+		t.Fatal(err)
+	}
+	for len(after.changed) > 0 {
+		<-after.changed
+	}
+	if after.update([]Alert{warning}, now) {
+		t.Error("an alert seen before a restart should not wake the display again")
+	}
+	if len(after.changed) == 0 {
+		t.Error("the restored alert should still be sent to the client")
+	}
+	if len(after.Alerts()) != 1 {
+		t.Errorf("the restored alert should be active: %+v", after.Alerts())
+	}
+}
+
+func TestAlertsSetSeverity(t *testing.T) {
+	e := newTestAlertsElement("Severe")
+
+	if err := e.SetSeverity("Minor"); err != nil {
+		t.Fatal(err)
+	} else if len(e.changed) != 1 {
+		t.Error("changing the severity should be persisted")
+	}
+	if err := e.SetSeverity("Minor"); err != nil {
+		t.Fatal(err)
+	} else if len(e.changed) != 1 {
+		t.Error("setting the same severity should not change anything")
+	}
+	if err := e.SetSeverity("Awful"); err == nil {
+		t.Error("expected an unknown severity to fail")
+	}
+}
+
+func TestAlertsFetchError(t *testing.T) {
+	provider := &fakeAlertProvider{err: errors.New("503 Service Unavailable")}
+	e := newAlertsElement(func(loc Location) AlertProvider { return provider }, Location{}, nil, "Severe", make(chan bool, 10), time.Hour)
+
+	// the fetcher retries sooner when a fetch fails
+	if err := e.fetchAlerts(); err != provider.err {
+		t.Errorf("fetching returned %v, want the provider's error", err)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	} else if m["error"] != provider.err.Error() {
+		t.Errorf("the error isn't reported: %s", b)
+	}
+
+	provider.err = nil
+	if err := e.fetchAlerts(); err != nil {
+		t.Fatal(err)
+	} else if e.Alerts() == nil || e.err != nil {
+		t.Errorf("a successful fetch kept the error %v", e.err)
+	}
+}
@@ -0,0 +1,150 @@
+// Package aqi computes the US EPA Air Quality Index from pollutant
+// concentrations and classifies pollen counts.
+package aqi
+
+import (
+	"math"
+)
+
+const (
+	PM25  = "pm25"
+	PM10  = "pm10"
+	Ozone = "ozone"
+	NO2   = "no2"
+)
+
+type breakpoint struct {
+	low, high float64
+	indexLow  int
+	indexHigh int
+}
+
+// breakpoints are from the EPA's Technical Assistance Document for the
+// Reporting of Daily Air Quality (2024).  PM is in µg/m³ averaged over 24
+// hours, ozone in ppb over 8 hours and NO2 in ppb over 1 hour.
+var breakpoints = map[string][]breakpoint{
+	PM25: {
+		{0.0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	},
+	PM10: {
+		{0, 54, 0, 50},
+		{55, 154, 51, 100},
+		{155, 254, 101, 150},
+		{255, 354, 151, 200},
+		{355, 424, 201, 300},
+		{425, 604, 301, 500},
+	},
+	Ozone: {
+		{0, 54, 0, 50},
+		{55, 70, 51, 100},
+		{71, 85, 101, 150},
+		{86, 105, 151, 200},
+		{106, 200, 201, 300},
+	},
+	NO2: {
+		{0, 53, 0, 50},
+		{54, 100, 51, 100},
+		{101, 360, 101, 150},
+		{361, 649, 151, 200},
+		{650, 1249, 201, 300},
+		{1250, 2049, 301, 500},
+	},
+}
+
+// precision is the number of decimals each concentration is truncated to
+// before it is looked up
+var precision = map[string]float64{
+	PM25:  10,
+	PM10:  1,
+	Ozone: 1,
+	NO2:   1,
+}
+
+// Index returns the AQI for a pollutant concentration and false if the
+// pollutant is unknown or the concentration is missing.  Concentrations
+// above the table are reported as 500.
+func Index(pollutant string, c float64) (int, bool) {
+	bps, ok := breakpoints[pollutant]
+	if !ok || math.IsNaN(c) || c < 0 {
+		return 0, false
+	}
+
+	c = math.Floor(c*precision[pollutant]) / precision[pollutant]
+	for _, bp := range bps {
+		if c <= bp.high {
+			if c < bp.low {
+				// between breakpoints after truncation, use the lower bound
+				c = bp.low
+			}
+			i := float64(bp.indexHigh-bp.indexLow)/(bp.high-bp.low)*(c-bp.low) + float64(bp.indexLow)
+			return int(math.Round(i)), true
+		}
+	}
+	return 500, true
+}
+
+type Category struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+var categories = []struct {
+	max int
+	Category
+}{
+	{50, Category{"Good", "#00E400"}},
+	{100, Category{"Moderate", "#FFFF00"}},
+	{150, Category{"Unhealthy for Sensitive Groups", "#FF7E00"}},
+	{200, Category{"Unhealthy", "#FF0000"}},
+	{300, Category{"Very Unhealthy", "#8F3F97"}},
+	{math.MaxInt32, Category{"Hazardous", "#7E0023"}},
+}
+
+// CategoryOf returns the EPA category name and color for an index
+func CategoryOf(index int) Category {
+	for _, c := range categories {
+		if index <= c.max {
+			return c.Category
+		}
+	}
+	return categories[len(categories)-1].Category
+}
+
+// pollenThresholds are the grains/m³ at which each kind of pollen becomes
+// moderate, high and very high, following the National Allergy Bureau's
+// scale for trees, grasses and weeds
+var pollenThresholds = map[string][3]float64{
+	"alder":   {15, 90, 1500},
+	"birch":   {15, 90, 1500},
+	"olive":   {15, 90, 1500},
+	"grass":   {5, 20, 200},
+	"mugwort": {10, 50, 500},
+	"ragweed": {10, 50, 500},
+}
+
+var pollenLevels = []Category{
+	{"Low", "#00E400"},
+	{"Moderate", "#FFFF00"},
+	{"High", "#FF7E00"},
+	{"Very High", "#FF0000"},
+}
+
+// PollenLevel classifies a pollen count, it returns false for unknown kinds
+// and missing counts
+func PollenLevel(kind string, grains float64) (Category, bool) {
+	t, ok := pollenThresholds[kind]
+	if !ok || math.IsNaN(grains) {
+		return Category{}, false
+	}
+
+	level := 0
+	for level < len(t) && grains >= t[level] {
+		level++
+	}
+	return pollenLevels[level], true
+}
@@ -0,0 +1,87 @@
+package aqi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	for _, c := range []struct {
+		pollutant     string
+		concentration float64
+		index         int
+		ok            bool
+	}{
+		{PM25, 0, 0, true},
+		{PM25, 9.0, 50, true},
+		// truncated to 9.0 before the lookup
+		{PM25, 9.05, 50, true},
+		{PM25, 9.1, 51, true},
+		{PM25, 12.0, 56, true},
+		{PM25, 35.9, 102, true},
+		{PM25, 325.4, 500, true},
+		{PM25, 500.4, 500, true},
+		{PM10, 54, 50, true},
+		{PM10, 54.9, 50, true},
+		{PM10, 155, 101, true},
+		{Ozone, 70, 100, true},
+		{Ozone, 71, 101, true},
+		{Ozone, 250, 500, true},
+		{NO2, 100, 100, true},
+		{NO2, 1250, 301, true},
+		{PM25, -1, 0, false},
+		{PM25, math.NaN(), 0, false},
+		{"co", 1, 0, false},
+	} {
+		i, ok := Index(c.pollutant, c.concentration)
+		if i != c.index || ok != c.ok {
+			t.Errorf("Index(%s, %g) = %d, %v, want %d, %v", c.pollutant, c.concentration, i, ok, c.index, c.ok)
+		}
+	}
+}
+
+func TestCategoryOf(t *testing.T) {
+	for _, c := range []struct {
+		index int
+		name  string
+		color string
+	}{
+		{0, "Good", "#00E400"},
+		{50, "Good", "#00E400"},
+		{51, "Moderate", "#FFFF00"},
+		{100, "Moderate", "#FFFF00"},
+		{101, "Unhealthy for Sensitive Groups", "#FF7E00"},
+		{200, "Unhealthy", "#FF0000"},
+		{201, "Very Unhealthy", "#8F3F97"},
+		{301, "Hazardous", "#7E0023"},
+		{500, "Hazardous", "#7E0023"},
+	} {
+		if got := CategoryOf(c.index); got.Name != c.name || got.Color != c.color {
+			t.Errorf("CategoryOf(%d) = %+v, want %s %s", c.index, got, c.name, c.color)
+		}
+	}
+}
+
+func TestPollenLevel(t *testing.T) {
+	for _, c := range []struct {
+		kind   string
+		grains float64
+		level  string
+		ok     bool
+	}{
+		{"grass", 0, "Low", true},
+		{"grass", 4.9, "Low", true},
+		{"grass", 5, "Moderate", true},
+		{"grass", 20, "High", true},
+		{"grass", 200, "Very High", true},
+		{"birch", 89, "Moderate", true},
+		{"ragweed", 50, "High", true},
+		{"ragweed", math.NaN(), "", false},
+		{"cedar", 10, "", false},
+	} {
+		level, ok := PollenLevel(c.kind, c.grains)
+		if level.Name != c.level || ok != c.ok {
+			t.Errorf("PollenLevel(%s, %g) = %s, %v, want %s, %v", c.kind, c.grains, level.Name, ok, c.level, c.ok)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package astro
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// SynodicMonth is the mean time between new moons in days
+	SynodicMonth = 29.530588853
+
+	// knownNewMoon is the julian date of the new moon of January 6th, 2000
+	knownNewMoon = 2451550.09766
+)
+
+var phaseNames = []string{
+	"New",
+	"Waxing Crescent",
+	"First Quarter",
+	"Waxing Gibbous",
+	"Full",
+	"Waning Gibbous",
+	"Last Quarter",
+	"Waning Crescent",
+}
+
+type Moon struct {
+	// Age is the number of days since the last new moon
+	Age float64
+	// Phase is the fraction of the synodic month elapsed, 0 and 1 are new
+	// and 0.5 is full
+	Phase float64
+	// Illumination is the fraction of the disc that is lit
+	Illumination float64
+}
+
+func MoonAt(t time.Time) Moon {
+	age := math.Mod(julian(t)-knownNewMoon, SynodicMonth)
+	if age < 0 {
+		age += SynodicMonth
+	}
+	phase := age / SynodicMonth
+	return Moon{
+		Age:          age,
+		Phase:        phase,
+		Illumination: (1 - math.Cos(2*math.Pi*phase)) / 2,
+	}
+}
+
+// Index returns which of the eight named phases the moon is in
+func (m Moon) Index() int {
+	return int(math.Floor(m.Phase*8+0.5)) % 8
+}
+
+func (m Moon) Name() string {
+	return phaseNames[m.Index()]
+}
+
+// NextPhase returns the time after t when the moon enters its next named
+// phase
+func NextPhase(t time.Time) time.Time {
+	m := MoonAt(t)
+	boundary := (float64(m.Index()) + 0.5) / 8
+	if m.Index() == 0 && m.Phase > 0.5 {
+		boundary += 1
+	}
+	days := (boundary - m.Phase) * SynodicMonth
+	return t.Add(time.Duration(days * float64(24*time.Hour)))
+}
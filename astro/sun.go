@@ -0,0 +1,113 @@
+// Package astro computes sun and moon positions locally using the
+// approximations from the NOAA solar calculator and a mean synodic month.
+package astro
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	j2000       = 2451545.0
+	unixEpochJD = 2440587.5
+	obliquity   = 23.4397
+
+	// altitudes of the sun's center at each event, sunrise and sunset
+	// include refraction and the radius of the sun
+	SunriseAltitude  = -0.833
+	CivilAltitude    = -6.0
+	NauticalAltitude = -12.0
+)
+
+// SunTimes holds the events of a single day.  Events that don't occur, such
+// as sunset during the polar summer, are the zero time.
+type SunTimes struct {
+	Dawn    time.Time
+	Sunrise time.Time
+	Noon    time.Time
+	Sunset  time.Time
+	Dusk    time.Time
+}
+
+func julian(t time.Time) float64 {
+	return float64(t.UnixNano())/float64(24*time.Hour) + unixEpochJD
+}
+
+func fromJulian(jd float64, loc *time.Location) time.Time {
+	ns := (jd - unixEpochJD) * float64(24*time.Hour)
+	return time.Unix(0, int64(ns)).In(loc)
+}
+
+func rad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func deg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// Sun computes dawn, sunrise, solar noon, sunset and dusk for the calendar
+// day containing date in date's location.  Longitude is positive east.
+func Sun(date time.Time, latitude float64, longitude float64) SunTimes {
+	loc := date.Location()
+	y, m, d := date.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, loc)
+
+	// mean solar noon, anomaly, equation of center and ecliptic longitude
+	n := math.Floor(julian(noon) - j2000 + 0.5)
+	jstar := n - longitude/360
+	M := math.Mod(357.5291+0.98560028*jstar, 360)
+	C := 1.9148*math.Sin(rad(M)) + 0.0200*math.Sin(rad(2*M)) + 0.0003*math.Sin(rad(3*M))
+	lambda := math.Mod(M+C+180+102.9372, 360)
+	transit := j2000 + jstar + 0.0053*math.Sin(rad(M)) - 0.0069*math.Sin(rad(2*lambda))
+	declination := math.Asin(math.Sin(rad(lambda)) * math.Sin(rad(obliquity)))
+
+	event := func(altitude float64, rising bool) time.Time {
+		cosOmega := (math.Sin(rad(altitude)) - math.Sin(rad(latitude))*math.Sin(declination)) /
+			(math.Cos(rad(latitude)) * math.Cos(declination))
+		if cosOmega < -1 || cosOmega > 1 {
+			return time.Time{}
+		}
+		omega := deg(math.Acos(cosOmega))
+		if rising {
+			return fromJulian(transit-omega/360, loc)
+		}
+		return fromJulian(transit+omega/360, loc)
+	}
+
+	return SunTimes{
+		Dawn:    event(CivilAltitude, true),
+		Sunrise: event(SunriseAltitude, true),
+		Noon:    fromJulian(transit, loc),
+		Sunset:  event(SunriseAltitude, false),
+		Dusk:    event(CivilAltitude, false),
+	}
+}
+
+// Daylight reports whether the sun is up at t.  The day is the calendar day
+// in t's location so t should be in the observer's time zone.
+func Daylight(t time.Time, latitude float64, longitude float64) bool {
+	s := Sun(t, latitude, longitude)
+	if s.Sunrise.IsZero() || s.Sunset.IsZero() {
+		// polar day or night, decide by the sun's altitude at noon
+		return Altitude(s.Noon, latitude, longitude) > SunriseAltitude
+	}
+	return !t.Before(s.Sunrise) && t.Before(s.Sunset)
+}
+
+// Altitude returns the approximate altitude of the sun in degrees at t
+func Altitude(t time.Time, latitude float64, longitude float64) float64 {
+	d := julian(t) - j2000
+	M := math.Mod(357.5291+0.98560028*d, 360)
+	C := 1.9148*math.Sin(rad(M)) + 0.0200*math.Sin(rad(2*M)) + 0.0003*math.Sin(rad(3*M))
+	lambda := rad(math.Mod(M+C+180+102.9372, 360))
+	declination := math.Asin(math.Sin(lambda) * math.Sin(rad(obliquity)))
+	ra := math.Atan2(math.Sin(lambda)*math.Cos(rad(obliquity)), math.Cos(lambda))
+
+	// sidereal time and hour angle
+	theta := rad(math.Mod(280.1470+360.9856235*d+longitude, 360))
+	H := theta - ra
+
+	return deg(math.Asin(math.Sin(rad(latitude))*math.Sin(declination) +
+		math.Cos(rad(latitude))*math.Cos(declination)*math.Cos(H)))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/donniet/mirror2/astro"
+)
+
+type astronomyElement struct {
+	visible   bool
+	latitude  float64
+	longitude float64
+	zone      *time.Location
+	changed   chan bool
+	lock      *sync.Mutex
+}
+
+func newAstronomyElement(loc Location, changed chan bool) (e *astronomyElement) {
+	e = &astronomyElement{
+		visible:   false,
+		latitude:  loc.Latitude,
+		longitude: loc.Longitude,
+		zone:      loc.zone(),
+		changed:   changed,
+		lock:      &sync.Mutex{},
+	}
+	go e.transitionThread()
+	return e
+}
+
+type astronomyState struct {
+	Date     time.Time `json:"date"`
+	Period   string    `json:"period"`
+	Icon     string    `json:"icon"`
+	Daylight bool      `json:"daylight"`
+	Dawn     time.Time `json:"dawn"`
+	Sunrise  time.Time `json:"sunrise"`
+	Noon     time.Time `json:"noon"`
+	Sunset   time.Time `json:"sunset"`
+	Dusk     time.Time `json:"dusk"`
+	Moon     moonState `json:"moon"`
+}
+
+type moonState struct {
+	Phase        string    `json:"phase"`
+	Icon         string    `json:"icon"`
+	Age          float64   `json:"age"`
+	Illumination float64   `json:"illumination"`
+	Next         time.Time `json:"next"`
+}
+
+var moonIcons = []string{
+	"Moon-New",
+	"Moon-Waxing-Crescent",
+	"Moon-First-Quarter",
+	"Moon-Waxing-Gibbous",
+	"Moon-Full",
+	"Moon-Waning-Gibbous",
+	"Moon-Last-Quarter",
+	"Moon-Waning-Crescent",
+}
+
+// stateAt computes the sun and moon at t for the calendar day at the
+// element's location.  The period is one of night, dawn, day or dusk where
+// dawn and dusk are civil twilight.
+func (e *astronomyElement) stateAt(t time.Time) astronomyState {
+	e.lock.Lock()
+	lat, lon := e.latitude, e.longitude
+	t = t.In(e.zone)
+	e.lock.Unlock()
+
+	sun := astro.Sun(t, lat, lon)
+	moon := astro.MoonAt(t)
+
+	s := astronomyState{
+		Date:     t,
+		Daylight: astro.Daylight(t, lat, lon),
+		Dawn:     sun.Dawn,
+		Sunrise:  sun.Sunrise,
+		Noon:     sun.Noon,
+		Sunset:   sun.Sunset,
+		Dusk:     sun.Dusk,
+		Moon: moonState{
+			Phase:        moon.Name(),
+			Icon:         icons.Named(moonIcons[moon.Index()]),
+			Age:          moon.Age,
+			Illumination: moon.Illumination,
+			Next:         astro.NextPhase(t),
+		},
+	}
+
+	switch {
+	case s.Daylight:
+		s.Period = "day"
+		s.Icon = icons.Named("Sun")
+	case !sun.Dawn.IsZero() && !t.Before(sun.Dawn) && t.Before(sun.Noon):
+		s.Period = "dawn"
+		s.Icon = icons.Named("Sunrise")
+	case !sun.Dusk.IsZero() && t.After(sun.Noon) && t.Before(sun.Dusk):
+		s.Period = "dusk"
+		s.Icon = icons.Named("Sunset")
+	default:
+		s.Period = "night"
+		s.Icon = s.Moon.Icon
+	}
+	return s
+}
+
+// nextTransition returns the next sun event or moon phase change after t
+func (e *astronomyElement) nextTransition(t time.Time) time.Time {
+	e.lock.Lock()
+	lat, lon := e.latitude, e.longitude
+	t = t.In(e.zone)
+	e.lock.Unlock()
+
+	next := astro.NextPhase(t)
+	for _, day := range []time.Time{t, t.AddDate(0, 0, 1)} {
+		sun := astro.Sun(day, lat, lon)
+		for _, event := range []time.Time{sun.Dawn, sun.Sunrise, sun.Sunset, sun.Dusk} {
+			if event.After(t) && event.Before(next) {
+				next = event
+			}
+		}
+	}
+	return next
+}
+
+func (e *astronomyElement) transitionThread() {
+	for {
+		now := time.Now()
+		next := e.nextTransition(now)
+
+		// recheck at least hourly in case the clock or location changes
+		wait := next.Sub(now)
+		if wait > time.Hour {
+			wait = time.Hour
+		}
+		time.Sleep(wait)
+
+		if !time.Now().Before(next) {
+			log.Printf("astronomy transition at %v", next)
+			e.changed <- true
+		}
+	}
+}
+
+func (e *astronomyElement) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			if err := json.Unmarshal(*msg, e); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(e)
+		return (*json.RawMessage)(&b), err
+	}
+
+	if path[0] == "visible" && len(path) == 1 {
+		if msg != nil {
+			var vis bool
+			if err := json.Unmarshal(*msg, &vis); err != nil {
+				return nil, err
+			}
+			e.setVisible(vis)
+		}
+		b, err := json.Marshal(e.Visible())
+		return (*json.RawMessage)(&b), err
+	}
+
+	return serveValuePath(e, path)
+}
+
+func (e *astronomyElement) UnmarshalJSON(b []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if v, ok := m["visible"]; ok {
+		vis := false
+		if vis, ok = v.(bool); !ok {
+			return fmt.Errorf("astronomy visible must be a boolean")
+		}
+		e.setVisible(vis)
+	}
+	return nil
+}
+
+func (e *astronomyElement) MarshalJSON() ([]byte, error) {
+	s := e.stateAt(time.Now())
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return json.Marshal(struct {
+		Visible   bool    `json:"visible"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		astronomyState
+	}{e.visible, e.latitude, e.longitude, s})
+}
+
+func (e *astronomyElement) Visible() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.visible
+}
+
+func (e *astronomyElement) Name() string {
+	return "Astronomy"
+}
+
+func (e *astronomyElement) Show() {
+	e.setVisible(true)
+}
+
+func (e *astronomyElement) Hide() {
+	e.setVisible(false)
+}
+
+func (e *astronomyElement) setVisible(vis bool) {
+	e.lock.Lock()
+	modified := e.visible != vis
+	e.visible = vis
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+}
+
+// setLocation moves the element and returns true if it changed.  It doesn't
+// notify so it can be called while handling another change.
+func (e *astronomyElement) setLocation(loc Location) bool {
+	zone := loc.zone()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.latitude == loc.Latitude && e.longitude == loc.Longitude && e.zone.String() == zone.String() {
+		return false
+	}
+	e.latitude = loc.Latitude
+	e.longitude = loc.Longitude
+	e.zone = zone
+	return true
+}
+
+// Daylight reports whether the sun is up at t at the element's location
+func (e *astronomyElement) Daylight(t time.Time) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return astro.Daylight(t.In(e.zone), e.latitude, e.longitude)
+}
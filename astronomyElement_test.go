@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var minneapolis = Location{
+	Name:      "Minneapolis",
+	Latitude:  44.98,
+	Longitude: -93.27,
+	Timezone:  "America/Chicago",
+}
+
+func TestAstronomyUsesLocationZone(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 01:00 UTC on the 22nd, the host's day would be a day late
+	evening := time.Date(2019, time.June, 21, 20, 0, 0, 0, chicago).UTC()
+
+	e := &astronomyElement{changed: make(chan bool), lock: &sync.Mutex{}}
+	e.setLocation(minneapolis)
+
+	if !e.Daylight(evening) {
+		t.Errorf("Daylight at 20:00 CDT on June 21 = false, want true")
+	}
+
+	s := e.stateAt(evening)
+	if s.Period != "day" {
+		t.Errorf("period = %q, want day", s.Period)
+	}
+	if y, m, d := s.Sunset.In(chicago).Date(); y != 2019 || m != time.June || d != 21 {
+		t.Errorf("sunset on %d-%02d-%02d, want 2019-06-21", y, m, d)
+	}
+	if next := e.nextTransition(evening); next != s.Sunset {
+		t.Errorf("next transition %v, want sunset %v", next, s.Sunset)
+	}
+}
+
+func TestLocationZoneFallback(t *testing.T) {
+	loc := minneapolis
+	loc.Timezone = ""
+	if _, offset := time.Date(2019, time.June, 21, 12, 0, 0, 0, time.UTC).In(loc.zone()).Zone(); offset != -6*3600 {
+		t.Errorf("offset = %d, want %d", offset, -6*3600)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// BrightnessPoint sets the brightness at a time of day, the schedule's
+// dimming curve interpolates linearly between points and wraps at midnight
+type BrightnessPoint struct {
+	Time       clockTime `json:"time"`
+	Brightness int       `json:"brightness"`
+}
+
+func validBrightness(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("brightness must be between 0 and 100")
+	}
+	return nil
+}
+
+// dimOverlay is the opacity of the black overlay the client draws to emulate
+// brightness on displays that can't dim themselves
+func dimOverlay(percent int) float64 {
+	return float64(100-percent) / 100
+}
+
+// brightnessAt interpolates the curve, which must be sorted by time, at t.  It
+// returns false if the curve is empty.
+func brightnessAt(curve []BrightnessPoint, t time.Time) (int, bool) {
+	if len(curve) == 0 {
+		return 0, false
+	}
+
+	const day = 24 * 60
+	minute := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+
+	// find the points either side of t, wrapping around midnight
+	prev, next := curve[len(curve)-1], curve[0]
+	prevTime, nextTime := float64(prev.Time)-day, float64(next.Time)
+	for i, p := range curve {
+		if float64(p.Time) > minute {
+			next, nextTime = p, float64(p.Time)
+			if i > 0 {
+				prev, prevTime = curve[i-1], float64(curve[i-1].Time)
+			}
+			break
+		} else if i == len(curve)-1 {
+			prev, prevTime = p, float64(p.Time)
+			next, nextTime = curve[0], float64(curve[0].Time)+day
+		}
+	}
+
+	if nextTime == prevTime {
+		return prev.Brightness, true
+	}
+	f := (minute - prevTime) / (nextTime - prevTime)
+	b := float64(prev.Brightness) + f*float64(next.Brightness-prev.Brightness)
+	return int(math.Round(b)), true
+}
@@ -9,8 +9,11 @@ document.addEventListener('DOMContentLoaded', function() {
       videos: [],
       news: {},
       weather: {},
+      alerts: {},
       youtube: {},
       dateTime: {},
+      display: {},
+      navigation: {},
       bg: '',
       socket: null,
       clientWidth: 1920,
@@ -110,10 +113,20 @@ document.addEventListener('DOMContentLoaded', function() {
         switch(key) {
         case "weather":
           this.weather = obj;
+          this.alerts = obj.alerts || {};
+          break;
+        case "weather/alerts":
+          this.alerts = obj;
           break;
         case "dateTime":
           this.dateTime = obj;
           break;
+        case "display":
+          this.display = obj;
+          break;
+        case "navigation":
+          this.navigation = obj;
+          break;
         case "streams":
           this.videos = obj;
           console.log("streams", obj);
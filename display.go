@@ -5,33 +5,152 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/donniet/cec"
 )
 
+// openDisplay creates the display backend named by spec, one of cec or
+// cec:<adapter>, dummy, backlight or backlight:<device>, vcgencmd or xset.
+// A cec display announces itself as cecName and controls the device at
+// logical address cecTarget.
+func openDisplay(spec string, cecName string, cecTarget int) (Display, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	arg := ""
+	if len(parts) > 1 {
+		arg = parts[1]
+	}
+
+	switch parts[0] {
+	case "cec":
+		return NewCECDisplay(arg, cecName, cecTarget)
+	case "dummy":
+		return NewDummyDisplay(), nil
+	case "backlight":
+		c, err := newBacklightController(sysfsBacklight, arg)
+		if err != nil {
+			return nil, err
+		}
+		return newPowerDisplay(c), nil
+	case "vcgencmd":
+		return newPowerDisplay(&vcgencmdController{runner: execRunner{}}), nil
+	case "xset":
+		return newPowerDisplay(&xsetController{runner: execRunner{}}), nil
+	}
+	return nil, fmt.Errorf("unknown display '%s', must be cec, dummy, backlight, vcgencmd or xset", spec)
+}
+
+// unmarshalBrightness sets the brightness from a display's persisted state
+func unmarshalBrightness(d Display, m map[string]interface{}) error {
+	b, ok := m["brightness"]
+	if !ok {
+		return nil
+	}
+	f, ok := b.(float64)
+	if !ok {
+		return fmt.Errorf("display brightness must be a number")
+	}
+	return d.SetBrightness(int(f))
+}
+
+// unmarshalKey unmarshals the key of a display's json into v, for parts of
+// a display like its policy that unmarshal themselves
+func unmarshalKey(m map[string]interface{}, key string, v interface{}) error {
+	f, ok := m[key]
+	if !ok {
+		return nil
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// serveInput serves a display's input.  Posting "mirror" claims the input,
+// "none" releases it and a physical address like "2.0.0.0" switches the
+// display to that device.
+func serveInput(d Display, path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		if len(path) > 0 {
+			return nil, &NotFoundError{Path: path}
+		}
+
+		var input string
+		if err := json.Unmarshal(*msg, &input); err != nil {
+			return nil, fmt.Errorf("display input must be a string")
+		}
+
+		var err error
+		switch input {
+		case "mirror":
+			err = d.SetActiveSource()
+		case "none":
+			err = d.InactiveSource()
+		default:
+			err = d.SelectInput(input)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return serveValuePath(map[string]interface{}{
+		"active":     d.IsActiveSource(),
+		"lastActive": d.LastActiveSource(),
+	}, path)
+}
+
+// changeNotifier tells a display's listener that its state changed without
+// blocking.  Notifications coalesce: the listener marshals the latest state
+// when it wakes, so a burst of changes while it is busy is a single update
+// and a stalled websocket never stalls display control.
+type changeNotifier struct {
+	changed chan bool
+}
+
+func newChangeNotifier() changeNotifier {
+	return changeNotifier{changed: make(chan bool, 1)}
+}
+
+func (n changeNotifier) notify() {
+	select {
+	case n.changed <- true:
+	default:
+		// an update is already pending and will carry this change
+	}
+}
+
+func (n changeNotifier) Changed() <-chan bool {
+	return n.changed
+}
+
 type DummyDisplay struct {
 	powerStatus string
+	brightness  int
+	input       string
 	err         error
-	sleeping    bool
-	waking      bool
-	afterFunc   *time.Timer
-	afterTime   time.Time
+	history     *powerHistory
+	energy      *energyMeter
 	lock        *sync.Mutex
-	changed     chan bool
+	changeNotifier
+	*powerPolicy
 }
 
 func NewDummyDisplay() *DummyDisplay {
-	return &DummyDisplay{
-		powerStatus: "standby",
-		lock:        &sync.Mutex{},
-		changed:     make(chan bool),
+	d := &DummyDisplay{
+		powerStatus:    "standby",
+		brightness:     100,
+		input:          "mirror",
+		history:        newPowerHistory("standby"),
+		lock:           &sync.Mutex{},
+		changeNotifier: newChangeNotifier(),
 	}
-}
-
-func (d *DummyDisplay) Changed() <-chan bool {
-	return d.changed
+	d.powerPolicy = newPowerPolicy(d.SetPower, d.notify)
+	d.energy = newEnergyMeter(d.history, d.notify)
+	return d
 }
 
 func (d *DummyDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
@@ -46,10 +165,30 @@ func (d *DummyDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.Raw
 		return (*json.RawMessage)(&b), err
 	}
 
+	switch path[0] {
+	case "history":
+		return serveValuePath(d.History(), path[1:])
+	case "policy":
+		return d.powerPolicy.ServeJSON(path[1:], msg)
+	case "input":
+		return serveInput(d, path[1:], msg)
+	case "energy":
+		return d.energy.ServeJSON(path[1:], msg)
+	}
+
 	if len(path) > 1 {
 		return nil, &NotFoundError{Path: path}
 	}
 
+	if path[0] == "brightness" && msg != nil {
+		var b int
+		if err := json.Unmarshal(*msg, &b); err != nil {
+			return nil, err
+		} else if err := d.SetBrightness(b); err != nil {
+			return nil, err
+		}
+	}
+
 	var v interface{}
 
 	d.lock.Lock()
@@ -62,12 +201,18 @@ func (d *DummyDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.Raw
 		v = 0
 	case "physicalAddress":
 		v = "0:0"
+	case "brightness":
+		v = d.brightness
+	case "dim":
+		v = dimOverlay(d.brightness)
 	case "sleeping":
-		v = d.sleeping
+		v = d.Sleeping()
 	case "waking":
-		v = d.waking
+		v = d.Waking()
 	case "error":
-		v = d.err.Error()
+		if d.err != nil {
+			v = d.err.Error()
+		}
 	default:
 		return nil, &NotFoundError{Path: path}
 	}
@@ -83,6 +228,10 @@ func (d *DummyDisplay) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	if err := unmarshalKey(m, "energy", d.energy); err != nil {
+		return err
+	}
+
 	if p, ok := m["powerStatus"]; ok {
 		var ps string
 		if ps, ok = p.(string); !ok {
@@ -101,6 +250,14 @@ func (d *DummyDisplay) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	if err := unmarshalBrightness(d, m); err != nil {
+		return err
+	}
+
+	if err := unmarshalKey(m, "policy", d.Policy()); err != nil {
+		return err
+	}
+
 	if s, ok := m["sleep"]; ok {
 		var ss string
 		if ss, ok = s.(string); !ok {
@@ -133,143 +290,108 @@ func (d *DummyDisplay) MarshalJSON() ([]byte, error) {
 	r["powerStatus"] = d.powerStatus
 	r["vendorID"] = strconv.FormatUint(0, 16)
 	r["physicalAddress"] = "0:0"
-	r["sleeping"] = d.sleeping
-	r["waking"] = d.waking
+	r["brightness"] = d.brightness
+	r["dim"] = dimOverlay(d.brightness)
+	r["sleeping"] = d.Sleeping()
+	r["waking"] = d.Waking()
+	r["policy"] = d.powerPolicy
+	r["energy"] = d.energy
 	if d.err != nil {
 		r["error"] = d.err.Error()
 	}
 	return json.Marshal(r)
 }
 
-func (d *DummyDisplay) Sleep(duration string) (err error) {
-	d.lock.Lock()
-
-	var dur time.Duration
-	if dur, err = time.ParseDuration(duration); err != nil {
-		return
-	}
-
-	if d.afterFunc != nil {
-		d.afterFunc.Stop()
-		d.afterFunc = nil
-	}
-	d.afterFunc = time.AfterFunc(dur, func() {
-		d.waking = false
-		d.sleeping = false
-		d.afterFunc = nil
-	})
-	d.afterTime = time.Now().Add(dur)
-	d.waking = false
-	d.sleeping = true
-	d.lock.Unlock()
-	d.Standby()
+func (d *DummyDisplay) PowerOn() {
+	d.SetPower(true, reasonAPI)
+}
 
-	d.changed <- true
-	return
+func (d *DummyDisplay) Standby() {
+	d.SetPower(false, reasonAPI)
 }
-func (d *DummyDisplay) Wake(duration string) (err error) {
-	d.lock.Lock()
 
-	var dur time.Duration
-	if dur, err = time.ParseDuration(duration); err != nil {
-		return
+func (d *DummyDisplay) SetPower(on bool, reason string) {
+	status := "standby"
+	if on {
+		status = "on"
 	}
 
-	if d.afterFunc != nil {
-		d.afterFunc.Stop()
-		d.afterFunc = nil
-	}
-	d.afterFunc = time.AfterFunc(dur, func() {
-		d.waking = false
-		d.sleeping = false
-		d.afterFunc = nil
-	})
-	d.afterTime = time.Now().Add(dur)
-	d.waking = true
-	d.sleeping = false
+	d.lock.Lock()
+	d.powerStatus = status
 	d.lock.Unlock()
-	d.PowerOn()
-
-	d.changed <- true
-	return
+	d.history.Record(status, reason, "")
+	d.notify()
 }
 
-func (d *DummyDisplay) MotionActivated() bool {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
-	return !d.sleeping && !d.waking
+func (d *DummyDisplay) VolumeUp() {
+	d.notify()
 }
 
-func (d *DummyDisplay) Sleeping() bool {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
-	return d.sleeping
+func (d *DummyDisplay) VolumeDown() {
+	d.notify()
 }
-func (d *DummyDisplay) Waking() bool {
-	d.lock.Lock()
-	defer d.lock.Unlock()
 
-	return d.waking
+func (d *DummyDisplay) Mute() {
+	d.notify()
 }
-func (d *DummyDisplay) SleepingUntil() time.Time {
-	d.lock.Lock()
-	defer d.lock.Unlock()
 
-	return d.afterTime
+func (d *DummyDisplay) KeyPress(key int) {
+	d.notify()
 }
-func (d *DummyDisplay) WakingUntil() time.Time {
-	d.lock.Lock()
-	defer d.lock.Unlock()
 
-	return d.afterTime
+func (d *DummyDisplay) KeyRelease() {
+	d.notify()
 }
 
-func (d *DummyDisplay) PowerOn() {
-	d.lock.Lock()
-	d.powerStatus = "on"
-	d.lock.Unlock()
-	d.changed <- true
+func (d *DummyDisplay) Key(key int) {
+	d.notify()
 }
 
-func (d *DummyDisplay) Standby() {
-	d.lock.Lock()
-	d.powerStatus = "standby"
-	d.lock.Unlock()
-	d.changed <- true
+func (d *DummyDisplay) Keys() <-chan string {
+	return nil
 }
 
-func (d *DummyDisplay) VolumeUp() {
-	d.changed <- true
+func (d *DummyDisplay) OSDName() string {
+	return "Dummy Display"
 }
 
-func (d *DummyDisplay) VolumeDown() {
-	d.changed <- true
+func (d *DummyDisplay) IsActiveSource() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.input == "mirror"
 }
 
-func (d *DummyDisplay) Mute() {
-	d.changed <- true
+func (d *DummyDisplay) LastActiveSource() bool {
+	return d.IsActiveSource()
 }
 
-func (d *DummyDisplay) KeyPress(key int) {
-	d.changed <- true
-}
+func (d *DummyDisplay) setInput(input string) {
+	d.lock.Lock()
+	modified := d.input != input
+	d.input = input
+	d.lock.Unlock()
 
-func (d *DummyDisplay) KeyRelease() {
-	d.changed <- true
+	if modified {
+		d.notify()
+	}
 }
 
-func (d *DummyDisplay) Key(key int) {
-	d.changed <- true
+func (d *DummyDisplay) SetActiveSource() error {
+	d.setInput("mirror")
+	return nil
 }
 
-func (d *DummyDisplay) OSDName() string {
-	return "Dummy Display"
+func (d *DummyDisplay) InactiveSource() error {
+	d.setInput("none")
+	return nil
 }
 
-func (d *DummyDisplay) IsActiveSource() bool {
-	return true
+func (d *DummyDisplay) SelectInput(physicalAddress string) error {
+	if _, err := cecPhysicalAddress(physicalAddress); err != nil {
+		return err
+	}
+	d.setInput(physicalAddress)
+	return nil
 }
 
 func (d *DummyDisplay) VendorID() uint64 {
@@ -280,42 +402,219 @@ func (d *DummyDisplay) PhysicalAddress() string {
 	return "0:0"
 }
 
+// SetBrightness is emulated by the client's dim overlay
+func (d *DummyDisplay) SetBrightness(percent int) error {
+	if err := validBrightness(percent); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	modified := d.brightness != percent
+	d.brightness = percent
+	d.lock.Unlock()
+
+	if modified {
+		d.notify()
+	}
+	return nil
+}
+
+func (d *DummyDisplay) Brightness() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.brightness
+}
+
 func (d *DummyDisplay) PowerStatus() string {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	return d.powerStatus
 }
 
+func (d *DummyDisplay) Energy() *energyMeter {
+	return d.energy
+}
+
+func (d *DummyDisplay) History() *powerHistory {
+	return d.history
+}
+
+// cecConnection is the part of a cec.Connection the display uses, so it can
+// be tested with a fake
+type cecConnection interface {
+	Transmit(command string)
+	Destroy()
+	PowerOn(address int) error
+	Standby(address int) error
+	VolumeUp() error
+	VolumeDown() error
+	Mute() error
+	KeyPress(address int, key int) error
+	KeyRelease(address int) error
+	Key(address int, key interface{})
+	GetDeviceOSDName(address int) string
+	IsActiveSource(address int) bool
+	GetDeviceVendorID(address int) uint64
+	GetDevicePhysicalAddress(address int) string
+	GetDevicePowerStatus(address int) string
+	GetLogicalAddress() int
+	List() map[string]cec.Device
+}
+
 type CECDisplay struct {
-	connection      *cec.Connection
+	connection      cecConnection
 	address         int
+	own             int
+	deviceName      string
 	powerStatus     string
 	vendorID        uint64
 	physicalAddress string
-	afterFunc       *time.Timer
-	afterTime       time.Time
-	sleeping        bool
-	waking          bool
+	brightness      int
 	err             error
-	changed         chan bool
 	lock            *sync.Mutex
+	powerLock       *sync.Mutex
 	commands        chan *cec.Command
+	keys            chan string
+	history         *powerHistory
+	energy          *energyMeter
+	lastActive      bool
+	changeNotifier
+	*powerPolicy
+}
+
+// cecCommandBuffer is how many received commands libcec's callback can hand
+// over before it waits for handleCommands
+const cecCommandBuffer = 16
+
+// cecDefaultOwnAddress is where libcec puts the recording device the cec
+// package registers as, unless another recorder already has it
+const cecDefaultOwnAddress = 1
+
+// cecOwnAddress is the logical address libcec allocated to the mirror
+func cecOwnAddress(conn cecConnection) int {
+	own := conn.GetLogicalAddress()
+	if own < 0 || own >= 15 {
+		log.Printf("the adapter has no logical address, assuming %d", cecDefaultOwnAddress)
+		return cecDefaultOwnAddress
+	}
+	return own
+}
+
+// cecPhysicalAddress converts a physical address like "1.0.0.0" into the
+// "10:00" of a cec frame
+func cecPhysicalAddress(pa string) (string, error) {
+	parts := strings.Split(pa, ".")
+	if len(parts) != 4 {
+		return "", fmt.Errorf("physical address '%s' must look like 1.0.0.0", pa)
+	}
+	for _, p := range parts {
+		if _, err := strconv.ParseUint(p, 16, 4); err != nil || len(p) != 1 {
+			return "", fmt.Errorf("physical address '%s' must look like 1.0.0.0", pa)
+		}
+	}
+	return parts[0] + parts[1] + ":" + parts[2] + parts[3], nil
+}
+
+// cecLogicalAddress parses a logical address given as a number from 0 to 15
+// or a name such as TV, Audio or Playback2
+func cecLogicalAddress(s string) (int, error) {
+	if a, err := strconv.Atoi(s); err == nil {
+		if a < 0 || a > 15 {
+			return 0, fmt.Errorf("cec logical address %d must be from 0 to 15", a)
+		}
+		return a, nil
+	}
+	if s != "" {
+		if a := cec.GetLogicalAddressByName(s); a >= 0 {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cec device '%s'", s)
+}
+
+// cecKeyByName returns the user control code of a key name like VolumeUp or
+// a code like 0x41
+func cecKeyByName(key string) (int, error) {
+	if strings.HasPrefix(key, "0x") {
+		code, err := strconv.ParseUint(key[2:], 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("key code '%s' must be a byte", key)
+		}
+		return int(code), nil
+	}
+	if code := cec.GetKeyCodeByName(key); code >= 0 {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unknown key '%s'", key)
+}
+
+// cecNavigationKeys translates CEC user control codes into navigator keys,
+// the digits 0x20 to 0x29 are handled separately
+var cecNavigationKeys = map[int]string{
+	0x00: keySelect,
+	0x01: keyUp,
+	0x02: keyDown,
+	0x03: keyLeft,
+	0x04: keyRight,
+	0x0D: keyBack,   // Exit
+	0x2B: keySelect, // Enter
+	0x91: keyBack,   // AnReturn
+}
+
+// cecPowerStatuses are the statuses in a REPORT_POWER_STATUS command, named
+// as GetDevicePowerStatus names them
+var cecPowerStatuses = map[int]string{
+	0x00: "on",
+	0x01: "standby",
+	0x02: "starting",
+	0x03: "shutting down",
+}
+
+// cecParameter returns the first parameter of a command, or false if it has
+// none
+func cecParameter(c *cec.Command) (int, bool) {
+	if len(c.Parameters) == 0 {
+		return 0, false
+	}
+	return int(c.Parameters[0]), true
+}
+
+func cecNavigationKey(code int) (string, bool) {
+	if code >= 0x20 && code <= 0x29 {
+		return strconv.Itoa(code - 0x20), true
+	}
+	key, ok := cecNavigationKeys[code]
+	return key, ok
 }
 
-func NewCECDisplay(name string, deviceName string) (ret *CECDisplay, err error) {
+// NewCECDisplay opens the adapter at name, or the first one found if name is
+// empty, and controls the device at logical address target
+func NewCECDisplay(name string, deviceName string, target int) (ret *CECDisplay, err error) {
 	ret = new(CECDisplay)
-	ret.address = 0
-	ret.commands = make(chan *cec.Command)
+	ret.address = target
+	ret.deviceName = deviceName
+	ret.brightness = 100
+	ret.commands = make(chan *cec.Command, cecCommandBuffer)
+	ret.keys = make(chan string, 8)
+	// the mirror may reclaim the input until another device takes it
+	ret.lastActive = true
 	ret.lock = &sync.Mutex{}
-	ret.changed = make(chan bool)
-	ret.connection, ret.err = cec.Open(name, deviceName)
+	ret.powerLock = &sync.Mutex{}
+	ret.changeNotifier = newChangeNotifier()
+	ret.powerPolicy = newPowerPolicy(ret.SetPower, ret.notify)
+	var conn *cec.Connection
+	conn, ret.err = cec.Open(name, deviceName)
 	log.Printf("connection openned, setting internal variables")
 	if ret.err == nil {
-		ret.connection.Commands = ret.commands
-		ret.powerStatus = ret.PowerStatus()
+		conn.Commands = ret.commands
+		ret.connection = conn
+		ret.own = cecOwnAddress(conn)
+		ret.powerStatus = ret.connection.GetDevicePowerStatus(ret.address)
 		ret.vendorID = ret.VendorID()
 		ret.physicalAddress = ret.PhysicalAddress()
 	}
+	ret.history = newPowerHistory(ret.powerStatus)
+	ret.energy = newEnergyMeter(ret.history, ret.notify)
 	go ret.handleCommands()
 	return ret, ret.err
 }
@@ -328,33 +627,96 @@ func (d *CECDisplay) handleCommands() {
 	for c := range d.commands {
 		switch c.Operation {
 		case "STANDBY":
-			d.lock.Lock()
-			d.powerStatus = "standby"
-			d.lock.Unlock()
+			d.setPowerStatus("standby", c.Operation)
 		case "ROUTING_CHANGE":
-			d.lock.Lock()
-			d.powerStatus = "on"
-			d.lock.Unlock()
+			d.setPowerStatus("on", c.Operation)
+		case "ACTIVE_SOURCE":
+			if from := int(c.Initiator); from != d.own {
+				log.Printf("%s took the display's input", cec.GetLogicalNameByAddress(from))
+				d.setLastActiveSource(false)
+			}
 		case "REPORT_POWER_STATUS":
-			log.Printf("power status change: %#v", c)
+			// the display's own report wins over whatever we last set.
+			// Asking libcec instead would block the loop that delivers its
+			// answer.
+			if int(c.Initiator) != d.target() {
+				continue
+			}
+			p, ok := cecParameter(c)
+			if status, known := cecPowerStatuses[p]; ok && known {
+				d.setPowerStatus(status, c.Operation)
+			} else {
+				log.Printf("ignoring power status report %v", c.Parameters)
+			}
+		case "USER_CONTROL_PRESSED":
+			code, ok := cecParameter(c)
+			if !ok {
+				log.Printf("ignoring remote key press without a key code")
+				continue
+			}
+			key, ok := cecNavigationKey(code)
+			if !ok {
+				log.Printf("ignoring remote key 0x%02x", code)
+				continue
+			}
+			select {
+			case d.keys <- key:
+			default:
+				log.Printf("dropping remote key %s, navigation is busy", key)
+			}
 		}
 	}
 }
 
-func (d *CECDisplay) Changed() <-chan bool {
-	return d.changed
-}
-
 func (d *CECDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
 	if len(path) == 0 {
+		if msg != nil {
+			if err := json.Unmarshal(*msg, d); err != nil {
+				return nil, err
+			}
+		}
+
 		b, err := json.Marshal(d)
 		return (*json.RawMessage)(&b), err
 	}
 
+	switch path[0] {
+	case "history":
+		return serveValuePath(d.History(), path[1:])
+	case "policy":
+		return d.powerPolicy.ServeJSON(path[1:], msg)
+	case "input":
+		return serveInput(d, path[1:], msg)
+	case "energy":
+		return d.energy.ServeJSON(path[1:], msg)
+	case "devices":
+		return d.serveDevices(path[1:], msg)
+	}
+
 	if len(path) > 1 {
 		return nil, &NotFoundError{Path: path}
 	}
 
+	if path[0] == "brightness" && msg != nil {
+		var b int
+		if err := json.Unmarshal(*msg, &b); err != nil {
+			return nil, err
+		} else if err := d.SetBrightness(b); err != nil {
+			return nil, err
+		}
+	}
+	if path[0] == "target" && msg != nil {
+		var t interface{}
+		if err := json.Unmarshal(*msg, &t); err != nil {
+			return nil, err
+		} else if err := d.setTargetValue(t); err != nil {
+			return nil, err
+		}
+	}
+	if path[0] == "deviceName" && msg != nil {
+		return nil, fmt.Errorf("display deviceName is set with -deviceName")
+	}
+
 	var v interface{}
 
 	d.lock.Lock()
@@ -363,16 +725,26 @@ func (d *CECDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMe
 	switch path[0] {
 	case "powerStatus":
 		v = d.powerStatus
+	case "deviceName":
+		v = d.deviceName
+	case "target":
+		v = cec.GetLogicalNameByAddress(d.address)
 	case "vendorID":
 		v = d.vendorID
 	case "physicalAddress":
 		v = d.physicalAddress
+	case "brightness":
+		v = d.brightness
+	case "dim":
+		v = dimOverlay(d.brightness)
 	case "sleeping":
-		v = d.sleeping
+		v = d.Sleeping()
 	case "waking":
-		v = d.waking
+		v = d.Waking()
 	case "error":
-		v = d.err.Error()
+		if d.err != nil {
+			v = d.err.Error()
+		}
 	default:
 		return nil, &NotFoundError{Path: path}
 	}
@@ -381,186 +753,367 @@ func (d *CECDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMe
 	return (*json.RawMessage)(&b), err
 }
 
+// UnmarshalJSON sets the display's target, brightness and policy.  The
+// deviceName is only reported, libcec announces it when it opens.
 func (d *CECDisplay) UnmarshalJSON(b []byte) error {
-	return nil
-}
-func (d *CECDisplay) MarshalJSON() ([]byte, error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+	m := make(map[string]interface{})
 
-	r := make(map[string]interface{})
-	r["powerStatus"] = d.powerStatus
-	r["vendorID"] = strconv.FormatUint(d.vendorID, 16)
-	r["physicalAddress"] = d.physicalAddress
-	r["sleeping"] = d.sleeping
-	r["waking"] = d.waking
-	if d.err != nil {
-		r["error"] = d.err.Error()
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
 	}
-	return json.Marshal(r)
-}
 
-func (d *CECDisplay) Sleep(duration string) (err error) {
-	d.lock.Lock()
-
-	var dur time.Duration
-	if dur, err = time.ParseDuration(duration); err != nil {
-		return
+	if err := unmarshalKey(m, "energy", d.energy); err != nil {
+		return err
 	}
-
-	if d.afterFunc != nil {
-		d.afterFunc.Stop()
-		d.afterFunc = nil
+	if t, ok := m["target"]; ok {
+		if err := d.setTargetValue(t); err != nil {
+			return err
+		}
 	}
-	d.afterFunc = time.AfterFunc(dur, func() {
-		d.waking = false
-		d.sleeping = false
-		d.afterFunc = nil
-	})
-	d.afterTime = time.Now().Add(dur)
-	d.waking = false
-	d.sleeping = true
-	d.lock.Unlock()
-	d.Standby()
-
-	d.changed <- true
-	return
+	if err := unmarshalBrightness(d, m); err != nil {
+		return err
+	}
+	return unmarshalKey(m, "policy", d.Policy())
 }
-func (d *CECDisplay) Wake(duration string) (err error) {
-	d.lock.Lock()
 
-	var dur time.Duration
-	if dur, err = time.ParseDuration(duration); err != nil {
-		return
+// setTargetValue sets the target from json, a logical address or its name
+func (d *CECDisplay) setTargetValue(v interface{}) error {
+	switch t := v.(type) {
+	case float64:
+		return d.SetTarget(strconv.Itoa(int(t)))
+	case string:
+		return d.SetTarget(t)
 	}
+	return fmt.Errorf("display target must be a logical address or a name like TV")
+}
 
-	if d.afterFunc != nil {
-		d.afterFunc.Stop()
-		d.afterFunc = nil
+// SetTarget controls the device at a logical address, given as a number or
+// a name like TV or Audio, from now on
+func (d *CECDisplay) SetTarget(target string) error {
+	address, err := cecLogicalAddress(target)
+	if err != nil {
+		return err
+	} else if address == d.own {
+		return fmt.Errorf("display target %s is the mirror itself", target)
 	}
-	d.afterFunc = time.AfterFunc(dur, func() {
-		d.waking = false
-		d.sleeping = false
-		d.afterFunc = nil
-	})
-	d.afterTime = time.Now().Add(dur)
-	d.waking = true
-	d.sleeping = false
-	d.lock.Unlock()
-	d.PowerOn()
 
-	d.changed <- true
-	return
-}
-
-func (d *CECDisplay) MotionActivated() bool {
+	// power changes wait for the new target
+	d.powerLock.Lock()
+	defer d.powerLock.Unlock()
 	d.lock.Lock()
-	defer d.lock.Unlock()
+	modified := d.address != address
+	d.address = address
+	d.lock.Unlock()
+	if !modified {
+		return nil
+	}
 
-	return !d.sleeping && !d.waking
-}
+	powerStatus := d.connection.GetDevicePowerStatus(address)
+	vendorID := d.connection.GetDeviceVendorID(address)
+	physicalAddress := d.connection.GetDevicePhysicalAddress(address)
 
-func (d *CECDisplay) Sleeping() bool {
 	d.lock.Lock()
-	defer d.lock.Unlock()
-
-	return d.sleeping
+	d.vendorID = vendorID
+	d.physicalAddress = physicalAddress
+	if powerStatus != "" {
+		d.powerStatus = powerStatus
+	}
+	d.lock.Unlock()
+	if powerStatus != "" {
+		d.history.Record(powerStatus, reasonAPI, "target "+cec.GetLogicalNameByAddress(address))
+	}
+	d.notify()
+	return nil
 }
-func (d *CECDisplay) Waking() bool {
-	d.lock.Lock()
-	defer d.lock.Unlock()
 
-	return d.waking
-}
-func (d *CECDisplay) SleepingUntil() time.Time {
+// target is the logical address of the controlled device
+func (d *CECDisplay) target() int {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-
-	return d.afterTime
+	return d.address
 }
-func (d *CECDisplay) WakingUntil() time.Time {
+func (d *CECDisplay) MarshalJSON() ([]byte, error) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	return d.afterTime
+	r := make(map[string]interface{})
+	r["powerStatus"] = d.powerStatus
+	r["deviceName"] = d.deviceName
+	r["target"] = cec.GetLogicalNameByAddress(d.address)
+	r["vendorID"] = strconv.FormatUint(d.vendorID, 16)
+	r["physicalAddress"] = d.physicalAddress
+	r["brightness"] = d.brightness
+	r["dim"] = dimOverlay(d.brightness)
+	r["sleeping"] = d.Sleeping()
+	r["waking"] = d.Waking()
+	r["policy"] = d.powerPolicy
+	r["energy"] = d.energy
+	if d.err != nil {
+		r["error"] = d.err.Error()
+	}
+	return json.Marshal(r)
 }
 
 func (d *CECDisplay) PowerOn() {
-	d.lock.Lock()
-	d.connection.PowerOn(d.address)
-	d.powerStatus = "on"
-	d.lock.Unlock()
-	d.changed <- true
+	d.SetPower(true, reasonAPI)
 }
 
 func (d *CECDisplay) Standby() {
+	d.SetPower(false, reasonAPI)
+}
+
+func (d *CECDisplay) SetPower(on bool, reason string) {
+	// libcec may wait for its callback to deliver commands while powering,
+	// and handleCommands needs the display's lock for those, so only power
+	// changes wait for each other
+	d.powerLock.Lock()
+	defer d.powerLock.Unlock()
+	status := "standby"
+	if on {
+		d.connection.PowerOn(d.target())
+		status = "on"
+	} else {
+		d.connection.Standby(d.target())
+	}
+
 	d.lock.Lock()
-	d.connection.Standby(d.address)
-	d.powerStatus = "standby"
+	d.powerStatus = status
 	d.lock.Unlock()
-	d.changed <- true
+	d.history.Record(status, reason, "")
+	d.notify()
 }
 
 func (d *CECDisplay) VolumeUp() {
 	d.connection.VolumeUp()
-	d.changed <- true
+	d.notify()
 }
 
 func (d *CECDisplay) VolumeDown() {
 	d.connection.VolumeDown()
-	d.changed <- true
+	d.notify()
 }
 
 func (d *CECDisplay) Mute() {
 	d.connection.Mute()
-	d.changed <- true
+	d.notify()
 }
 
 func (d *CECDisplay) KeyPress(key int) {
-	d.connection.KeyPress(d.address, key)
-	d.changed <- true
+	d.connection.KeyPress(d.target(), key)
+	d.notify()
 }
 
 func (d *CECDisplay) KeyRelease() {
-	d.connection.KeyRelease(d.address)
-	d.changed <- true
+	d.connection.KeyRelease(d.target())
+	d.notify()
 }
 
 func (d *CECDisplay) Key(key int) {
-	d.connection.Key(d.address, key)
-	d.changed <- true
+	d.connection.Key(d.target(), key)
+	d.notify()
+}
+
+func (d *CECDisplay) Keys() <-chan string {
+	return d.keys
 }
 
 func (d *CECDisplay) OSDName() string {
-	return d.connection.GetDeviceOSDName(d.address)
+	return d.connection.GetDeviceOSDName(d.target())
 }
 
+// IsActiveSource returns true if the display is showing the mirror
 func (d *CECDisplay) IsActiveSource() bool {
-	return d.connection.IsActiveSource(d.address)
+	return d.connection.IsActiveSource(d.own)
+}
+
+func (d *CECDisplay) LastActiveSource() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.lastActive
+}
+
+func (d *CECDisplay) setLastActiveSource(active bool) {
+	d.lock.Lock()
+	modified := d.lastActive != active
+	d.lastActive = active
+	d.lock.Unlock()
+
+	if modified {
+		d.notify()
+	}
+}
+
+// SetActiveSource broadcasts that the mirror is the active source, which
+// switches the display to its input
+func (d *CECDisplay) SetActiveSource() error {
+	pa, err := cecPhysicalAddress(d.connection.GetDevicePhysicalAddress(d.own))
+	if err != nil {
+		return err
+	}
+	d.connection.Transmit(fmt.Sprintf("%XF:82:%s", d.own, pa))
+	d.setLastActiveSource(true)
+	return nil
+}
+
+// InactiveSource tells the display the mirror has nothing to show so it can
+// switch to another input
+func (d *CECDisplay) InactiveSource() error {
+	pa, err := cecPhysicalAddress(d.connection.GetDevicePhysicalAddress(d.own))
+	if err != nil {
+		return err
+	}
+	d.connection.Transmit(fmt.Sprintf("%X%X:9D:%s", d.own, d.target(), pa))
+	d.setLastActiveSource(false)
+	return nil
+}
+
+// SelectInput asks the device at physicalAddress to become the active source
+func (d *CECDisplay) SelectInput(physicalAddress string) error {
+	pa, err := cecPhysicalAddress(physicalAddress)
+	if err != nil {
+		return err
+	}
+	d.connection.Transmit(fmt.Sprintf("%XF:86:%s", d.own, pa))
+	d.setLastActiveSource(physicalAddress == d.connection.GetDevicePhysicalAddress(d.own))
+	return nil
 }
 
 func (d *CECDisplay) VendorID() uint64 {
-	return d.connection.GetDeviceVendorID(d.address)
+	return d.connection.GetDeviceVendorID(d.target())
 }
 
 func (d *CECDisplay) PhysicalAddress() string {
-	return d.connection.GetDevicePhysicalAddress(d.address)
+	return d.connection.GetDevicePhysicalAddress(d.target())
 }
 
+// SetBrightness is emulated by the client's dim overlay, CEC has no
+// brightness control
+func (d *CECDisplay) SetBrightness(percent int) error {
+	if err := validBrightness(percent); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	modified := d.brightness != percent
+	d.brightness = percent
+	d.lock.Unlock()
+
+	if modified {
+		d.notify()
+	}
+	return nil
+}
+
+func (d *CECDisplay) Brightness() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.brightness
+}
+
+// PowerStatus asks the display, a change since we last set it was made with
+// the TV's remote or another device on the bus
 func (d *CECDisplay) PowerStatus() string {
+	p := d.connection.GetDevicePowerStatus(d.target())
+	if p == "" {
+		// the display didn't answer, keep what we know
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return d.powerStatus
+	}
+	d.setPowerStatus(p, "poll")
+	return p
+}
+
+// setPowerStatus records a power status reported by the display
+func (d *CECDisplay) setPowerStatus(p string, detail string) {
+	if p == "" {
+		return
+	}
+
 	d.lock.Lock()
+	modified := p != d.powerStatus
+	d.powerStatus = p
+	d.lock.Unlock()
 
-	p := d.connection.GetDevicePowerStatus(d.address)
-	if p != d.powerStatus {
-		old := d.powerStatus
-		d.powerStatus = p
-		d.lock.Unlock()
+	if modified {
+		log.Printf("display reported %s (%s)", p, detail)
+		d.history.Record(p, reasonRemote, detail)
+		d.notify()
+	}
+}
 
-		if old != "" {
-			d.changed <- true
+// Devices lists the devices on the bus by logical name
+func (d *CECDisplay) Devices() map[string]interface{} {
+	ret := make(map[string]interface{})
+	for name, dev := range d.connection.List() {
+		ret[name] = map[string]interface{}{
+			"osdName":         dev.OSDName,
+			"vendor":          dev.Vendor,
+			"logicalAddress":  dev.LogicalAddress,
+			"physicalAddress": dev.PhysicalAddress,
+			"powerStatus":     dev.PowerStatus,
+			"activeSource":    dev.ActiveSource,
 		}
-	} else {
-		d.lock.Unlock()
 	}
-	return p
+	return ret
+}
+
+// SendTo sends a command to the device at a logical address, "on",
+// "standby" or a key such as VolumeUp.  Power commands for the controlled
+// device go through SetPower so they are recorded.
+func (d *CECDisplay) SendTo(address int, command string) error {
+	switch command {
+	case "on", "standby":
+		if address == d.target() {
+			d.SetPower(command == "on", reasonAPI)
+			return nil
+		} else if command == "on" {
+			return d.connection.PowerOn(address)
+		}
+		return d.connection.Standby(address)
+	}
+
+	code, err := cecKeyByName(command)
+	if err != nil {
+		return err
+	}
+	d.connection.Key(address, code)
+	return nil
+}
+
+// serveDevices serves the devices on the bus, a command string posted to
+// devices/<name> is sent to that device, for example "VolumeUp" to Audio
+func (d *CECDisplay) serveDevices(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) > 0 {
+		address, err := cecLogicalAddress(path[0])
+		if err != nil {
+			return nil, &NotFoundError{Path: path}
+		}
+		// devices are listed by name so numeric addresses are translated
+		path = append([]string{cec.GetLogicalNameByAddress(address)}, path[1:]...)
+
+		if msg != nil {
+			if len(path) > 1 {
+				return nil, &NotFoundError{Path: path}
+			}
+
+			var command string
+			if err := json.Unmarshal(*msg, &command); err != nil {
+				return nil, fmt.Errorf("device command must be a string")
+			} else if err := d.SendTo(address, command); err != nil {
+				return nil, err
+			}
+		}
+	} else if msg != nil {
+		return nil, &NotFoundError{Path: path}
+	}
+	return serveValuePath(d.Devices(), path)
+}
+
+func (d *CECDisplay) Energy() *energyMeter {
+	return d.energy
+}
+
+func (d *CECDisplay) History() *powerHistory {
+	return d.history
 }
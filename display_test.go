@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donniet/cec"
+)
+
+// fakeCEC is a bus with only the display on it, it records the calls made
+// to it
+type fakeCEC struct {
+	own         int
+	powerStatus string
+	calls       []string
+	lock        *sync.Mutex
+	// onPower runs while a power command waits on the bus
+	onPower func()
+}
+
+func (c *fakeCEC) call(format string, args ...interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls = append(c.calls, fmt.Sprintf(format, args...))
+}
+
+func (c *fakeCEC) Calls() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func (c *fakeCEC) setPowerStatus(p string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.powerStatus = p
+}
+
+func (c *fakeCEC) Transmit(command string)          { c.call("Transmit %s", command) }
+func (c *fakeCEC) Destroy()                         { c.call("Destroy") }
+func (c *fakeCEC) VolumeUp() error                  { c.call("VolumeUp"); return nil }
+func (c *fakeCEC) VolumeDown() error                { c.call("VolumeDown"); return nil }
+func (c *fakeCEC) Mute() error                      { c.call("Mute"); return nil }
+func (c *fakeCEC) KeyRelease(address int) error     { c.call("KeyRelease %d", address); return nil }
+func (c *fakeCEC) Key(address int, key interface{}) { c.call("Key %d %v", address, key) }
+func (c *fakeCEC) List() map[string]cec.Device      { return nil }
+
+func (c *fakeCEC) PowerOn(address int) error {
+	c.call("PowerOn %d", address)
+	if c.onPower != nil {
+		c.onPower()
+	}
+	c.setPowerStatus("on")
+	return nil
+}
+
+func (c *fakeCEC) Standby(address int) error {
+	c.call("Standby %d", address)
+	if c.onPower != nil {
+		c.onPower()
+	}
+	c.setPowerStatus("standby")
+	return nil
+}
+
+func (c *fakeCEC) KeyPress(address int, key int) error {
+	c.call("KeyPress %d %d", address, key)
+	return nil
+}
+
+func (c *fakeCEC) GetDeviceOSDName(address int) string  { return "TV" }
+func (c *fakeCEC) IsActiveSource(address int) bool      { return address == c.own }
+func (c *fakeCEC) GetDeviceVendorID(address int) uint64 { return 0x00E091 }
+func (c *fakeCEC) GetLogicalAddress() int               { return c.own }
+func (c *fakeCEC) GetDevicePhysicalAddress(address int) string {
+	if address == c.own {
+		return "1.0.0.0"
+	}
+	return "0.0.0.0"
+}
+
+func (c *fakeCEC) GetDevicePowerStatus(address int) string {
+	c.call("GetDevicePowerStatus %d", address)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.powerStatus
+}
+
+// newTestCECDisplay handles commands like a connected display, the bus is
+// faked
+func newTestCECDisplay() *CECDisplay {
+	return newTestCECDisplayAt(cecDefaultOwnAddress)
+}
+
+// newTestCECDisplayAt is a display where libcec gave the mirror the logical
+// address own
+func newTestCECDisplayAt(own int) *CECDisplay {
+	conn := &fakeCEC{own: own, powerStatus: "standby", lock: &sync.Mutex{}}
+	d := &CECDisplay{
+		connection:  conn,
+		address:     0,
+		own:         cecOwnAddress(conn),
+		powerStatus: "standby",
+		brightness:  100,
+		// unbuffered, unlike a connected display's, so handle knows when
+		// a command is done
+		commands:       make(chan *cec.Command),
+		keys:           make(chan string, 8),
+		lastActive:     true,
+		lock:           &sync.Mutex{},
+		powerLock:      &sync.Mutex{},
+		changeNotifier: newChangeNotifier(),
+		history:        newPowerHistory("standby"),
+	}
+	d.powerPolicy = newPowerPolicy(d.SetPower, d.notify)
+	go d.handleCommands()
+	return d
+}
+
+// handle hands commands to the display and waits until the last has been
+// handled
+func handle(d *CECDisplay, commands ...*cec.Command) {
+	for _, c := range commands {
+		d.commands <- c
+	}
+	// the channel is unbuffered, so this is taken once the last is done
+	d.commands <- &cec.Command{Opcode: 0x46, Operation: "GIVE_OSD_NAME"}
+}
+
+func keyPressed(from uint32, code ...uint8) *cec.Command {
+	return &cec.Command{Initiator: from, Destination: cecDefaultOwnAddress, Opcode: 0x44, Operation: "USER_CONTROL_PRESSED", Parameters: code}
+}
+
+func TestCECKeyPress(t *testing.T) {
+	d := newTestCECDisplay()
+	defer close(d.commands)
+
+	handle(d,
+		keyPressed(0, 0x01), // Up
+		keyPressed(0, 0x25), // 5
+		keyPressed(0, 0x30), // ChannelUp isn't used to navigate
+		keyPressed(0),       // no key code
+		keyPressed(0, 0x0D), // Exit
+		keyPressed(0, 0x2B), // Enter
+	)
+
+	for _, want := range []string{keyUp, "5", keyBack, keySelect} {
+		select {
+		case key := <-d.Keys():
+			if key != want {
+				t.Errorf("key = %s, want %s", key, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no key, want %s", want)
+		}
+	}
+	select {
+	case key := <-d.Keys():
+		t.Errorf("unexpected key %s", key)
+	default:
+	}
+}
+
+func TestCECActiveSource(t *testing.T) {
+	d := newTestCECDisplay()
+	defer close(d.commands)
+
+	// the mirror's own broadcast keeps its claim on the input
+	handle(d, &cec.Command{Initiator: cecDefaultOwnAddress, Destination: 15, Opcode: 0x82, Operation: "ACTIVE_SOURCE", Parameters: []uint8{0x10, 0x00}})
+	if !d.LastActiveSource() {
+		t.Errorf("the mirror lost the input to itself")
+	}
+
+	handle(d, &cec.Command{Initiator: 4, Destination: 15, Opcode: 0x82, Operation: "ACTIVE_SOURCE", Parameters: []uint8{0x20, 0x00}})
+	if d.LastActiveSource() {
+		t.Errorf("a playback device took the input but the mirror still has it")
+	}
+}
+
+func TestCECOwnAddress(t *testing.T) {
+	// another recorder took address 1, libcec gave the mirror 2
+	d := newTestCECDisplayAt(2)
+	defer close(d.commands)
+	fake := d.connection.(*fakeCEC)
+
+	if !d.IsActiveSource() {
+		t.Errorf("the mirror isn't the active source at its own address")
+	}
+	if err := d.SetActiveSource(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InactiveSource(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SelectInput("1.0.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Transmit 2F:82:10:00", "Transmit 20:9D:10:00", "Transmit 2F:86:10:00"}
+	if calls := fake.Calls(); fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("calls %v, want %v", calls, want)
+	}
+	if !d.LastActiveSource() {
+		t.Errorf("selecting the mirror's input didn't claim it")
+	}
+
+	handle(d, &cec.Command{Initiator: 2, Destination: 15, Opcode: 0x82, Operation: "ACTIVE_SOURCE", Parameters: []uint8{0x10, 0x00}})
+	if !d.LastActiveSource() {
+		t.Errorf("the mirror lost the input to itself")
+	}
+	handle(d, &cec.Command{Initiator: 1, Destination: 15, Opcode: 0x82, Operation: "ACTIVE_SOURCE", Parameters: []uint8{0x20, 0x00}})
+	if d.LastActiveSource() {
+		t.Errorf("the other recorder took the input but the mirror still has it")
+	}
+
+	if own := cecOwnAddress(&fakeCEC{own: 15, lock: &sync.Mutex{}}); own != cecDefaultOwnAddress {
+		t.Errorf("an unregistered adapter is at %d, want %d", own, cecDefaultOwnAddress)
+	}
+}
+
+func reportPowerStatus(from uint32, status ...uint8) *cec.Command {
+	return &cec.Command{Initiator: from, Destination: cecDefaultOwnAddress, Opcode: 0x90, Operation: "REPORT_POWER_STATUS", Parameters: status}
+}
+
+func TestCECReportPowerStatus(t *testing.T) {
+	d := newTestCECDisplay()
+	defer close(d.commands)
+	fake := d.connection.(*fakeCEC)
+
+	expect := func(status string, detail string) {
+		t.Helper()
+		events := d.History().Events()
+		last := events[len(events)-1]
+		if d.powerStatus != status || last.PowerStatus != status || last.Detail != detail {
+			t.Errorf("status %s, last event %+v, want %s from %s", d.powerStatus, last, status, detail)
+		}
+	}
+
+	// the tv turned on with its own remote
+	handle(d, reportPowerStatus(0, 0x00))
+	expect("on", "REPORT_POWER_STATUS")
+	if last := d.History().Events(); last[len(last)-1].Reason != reasonRemote {
+		t.Errorf("power change recorded as %s, want remote", last[len(last)-1].Reason)
+	}
+
+	// reports from other devices, without a status or with an unknown one
+	// are ignored
+	handle(d,
+		reportPowerStatus(5, 0x01),
+		reportPowerStatus(0),
+		reportPowerStatus(0, 0x07),
+	)
+	expect("on", "REPORT_POWER_STATUS")
+
+	handle(d, reportPowerStatus(0, 0x03))
+	expect("shutting down", "REPORT_POWER_STATUS")
+	handle(d, reportPowerStatus(0, 0x01))
+	expect("standby", "REPORT_POWER_STATUS")
+
+	// the reports are taken as they are, libcec is never asked from the
+	// command loop where its answer would arrive
+	for _, call := range fake.Calls() {
+		t.Errorf("command loop called %s", call)
+	}
+}
+
+func TestCECPowerWhileReporting(t *testing.T) {
+	d := newTestCECDisplay()
+	defer close(d.commands)
+	fake := d.connection.(*fakeCEC)
+
+	// the tv reports while libcec is waiting for it to power, the second
+	// report is only taken once the first has been handled
+	fake.onPower = func() {
+		d.commands <- reportPowerStatus(0, 0x02)
+		d.commands <- reportPowerStatus(0, 0x00)
+	}
+
+	done := make(chan bool)
+	go func() {
+		d.SetPower(true, reasonAPI)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("powering waited on the command loop, which waited on the display")
+	}
+	handle(d)
+	if d.powerStatus != "on" {
+		t.Errorf("status %s after powering on", d.powerStatus)
+	}
+}
+
+func TestCECTarget(t *testing.T) {
+	d := newTestCECDisplay()
+	defer close(d.commands)
+	fake := d.connection.(*fakeCEC)
+
+	post := func(path []string, v string) (*json.RawMessage, error) {
+		msg := json.RawMessage(v)
+		return d.ServeJSON(path, &msg)
+	}
+
+	// the soundbar
+	if res, err := post([]string{"target"}, `"Audio"`); err != nil {
+		t.Fatal(err)
+	} else if string(*res) != `"Audio"` {
+		t.Errorf("target %s after setting Audio", *res)
+	}
+	d.SetPower(true, reasonAPI)
+	if calls := fake.Calls(); calls[len(calls)-1] != "PowerOn 5" {
+		t.Errorf("powered on with %v", calls)
+	}
+
+	// a persisted target is restored by number or name
+	if err := json.Unmarshal([]byte(`{"target":0,"deviceName":"Kitchen"}`), d); err != nil {
+		t.Fatal(err)
+	} else if a := d.target(); a != 0 {
+		t.Errorf("target %d after restoring 0", a)
+	}
+	if _, err := post(nil, `{"target":"Playback2"}`); err != nil {
+		t.Fatal(err)
+	} else if a := d.target(); a != 8 {
+		t.Errorf("target %d after posting Playback2", a)
+	}
+
+	for _, test := range []struct {
+		path []string
+		v    string
+	}{
+		{[]string{"target"}, `"Nowhere"`},
+		{[]string{"target"}, `16`},
+		{[]string{"target"}, `true`},
+		// the mirror can't control itself
+		{[]string{"target"}, `"Recording"`},
+		{nil, `{"target":"Recording"}`},
+		// libcec announces the name when it opens
+		{[]string{"deviceName"}, `"Kitchen"`},
+	} {
+		if _, err := post(test.path, test.v); err == nil {
+			t.Errorf("set %v to %s", test.path, test.v)
+		}
+	}
+	if a := d.target(); a != 8 {
+		t.Errorf("target %d after errors", a)
+	}
+	if d.deviceName != "" {
+		t.Errorf("deviceName set to %s", d.deviceName)
+	}
+}
+
+func TestCECPowerStatusPoll(t *testing.T) {
+	d := newTestCECDisplay()
+	defer close(d.commands)
+	fake := d.connection.(*fakeCEC)
+
+	d.SetPower(true, reasonMotion)
+	if p := d.PowerStatus(); p != "on" {
+		t.Errorf("status = %s after turning on", p)
+	}
+
+	// turned off behind the mirror's back
+	fake.setPowerStatus("standby")
+	if p := d.PowerStatus(); p != "standby" {
+		t.Errorf("status = %s, want standby from the poll", p)
+	}
+	events := d.History().Events()
+	if last := events[len(events)-1]; last.PowerStatus != "standby" || last.Reason != reasonRemote || last.Detail != "poll" {
+		t.Errorf("last event %+v", last)
+	}
+
+	// a display that doesn't answer keeps its last known status
+	fake.setPowerStatus("")
+	if p := d.PowerStatus(); p != "standby" {
+		t.Errorf("status = %s, want the last known standby", p)
+	}
+	if n := len(d.History().Events()); n != len(events) {
+		t.Errorf("an unanswered poll was recorded")
+	}
+}
+
+func TestChangeNotifierCoalesces(t *testing.T) {
+	n := newChangeNotifier()
+	for i := 0; i < 10; i++ {
+		n.notify()
+	}
+	select {
+	case <-n.Changed():
+	default:
+		t.Fatalf("no change pending")
+	}
+	select {
+	case <-n.Changed():
+		t.Errorf("the changes weren't coalesced")
+	default:
+	}
+}
+
+// TestDisplaysConcurrent drives every display from many goroutines with
+// nobody listening for changes, run it with -race
+func TestDisplaysConcurrent(t *testing.T) {
+	runner := newFakeRunner(map[string]string{
+		"vcgencmd display_power":   "display_power=1",
+		"vcgencmd display_power 0": "",
+		"vcgencmd display_power 1": "",
+	})
+	cecDisplay := newTestCECDisplay()
+	defer close(cecDisplay.commands)
+
+	for name, d := range map[string]Display{
+		"dummy":    NewDummyDisplay(),
+		"vcgencmd": newPowerDisplay(&vcgencmdController{runner: runner}),
+		"cec":      cecDisplay,
+	} {
+		brightness := json.RawMessage(`50`)
+		calls := []func(){
+			d.PowerOn,
+			d.Standby,
+			func() { d.Sleep("1h") },
+			func() { d.Wake("1h") },
+			func() { d.Policy().Release() },
+			func() { d.Policy().SetScheduled(true) },
+			func() { d.SetBrightness(80) },
+			func() { d.PowerStatus() },
+			func() { d.ServeJSON(nil, nil) },
+			func() { d.ServeJSON([]string{"powerStatus"}, nil) },
+			func() { d.ServeJSON([]string{"brightness"}, &brightness) },
+			func() { d.ServeJSON([]string{"policy"}, nil) },
+			func() { d.ServeJSON([]string{"history"}, nil) },
+			func() { d.ServeJSON([]string{"energy"}, nil) },
+			func() { d.ServeJSON([]string{"input"}, nil) },
+		}
+
+		wg := &sync.WaitGroup{}
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 40; i++ {
+					calls[(g+i)%len(calls)]()
+				}
+			}(g)
+		}
+
+		done := make(chan bool)
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s display blocked without a listener", name)
+		}
+
+		select {
+		case <-d.Changed():
+		default:
+			t.Errorf("%s display has no change pending", name)
+		}
+		d.Policy().Release()
+	}
+}
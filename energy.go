@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// energyMeter estimates the energy a display uses from the time its history
+// has it on or in standby and the configured wattage.  Savings are measured
+// against leaving the display on all the time.
+type energyMeter struct {
+	history      *powerHistory
+	onWatts      float64
+	standbyWatts float64
+	costPerKWh   float64
+	onChange     func()
+	lock         *sync.Mutex
+}
+
+type energyUsage struct {
+	Date      string  `json:"date,omitempty"`
+	On        string  `json:"on"`
+	Standby   string  `json:"standby"`
+	KWh       float64 `json:"kWh"`
+	Cost      float64 `json:"cost"`
+	SavedKWh  float64 `json:"savedKWh"`
+	SavedCost float64 `json:"savedCost"`
+}
+
+func newEnergyMeter(history *powerHistory, onChange func()) *energyMeter {
+	return &energyMeter{
+		history:      history,
+		onWatts:      defaultOnWatts,
+		standbyWatts: defaultStandbyWatts,
+		costPerKWh:   defaultCostPerKWh,
+		onChange:     onChange,
+		lock:         &sync.Mutex{},
+	}
+}
+
+// defaults for a typical 40" LED TV, set with SetRates or the api
+var (
+	defaultOnWatts      = 60.0
+	defaultStandbyWatts = 0.5
+	defaultCostPerKWh   = 0.15
+)
+
+// SetRates sets the display's wattage on and in standby and the price of a
+// kilowatt hour
+func (e *energyMeter) SetRates(onWatts, standbyWatts, costPerKWh float64) error {
+	if onWatts < 0 || standbyWatts < 0 || costPerKWh < 0 {
+		return fmt.Errorf("display energy rates must not be negative")
+	}
+
+	e.lock.Lock()
+	modified := e.onWatts != onWatts || e.standbyWatts != standbyWatts || e.costPerKWh != costPerKWh
+	e.onWatts = onWatts
+	e.standbyWatts = standbyWatts
+	e.costPerKWh = costPerKWh
+	e.lock.Unlock()
+
+	if modified {
+		e.onChange()
+	}
+	return nil
+}
+
+// usage estimates the energy for time on and in standby, the lock must be
+// held
+func (e *energyMeter) usage(on time.Duration, standby time.Duration) energyUsage {
+	kwh := (on.Hours()*e.onWatts + standby.Hours()*e.standbyWatts) / 1000
+	alwaysOn := (on + standby).Hours() * e.onWatts / 1000
+	return energyUsage{
+		On:        on.Round(time.Second).String(),
+		Standby:   standby.Round(time.Second).String(),
+		KWh:       kwh,
+		Cost:      kwh * e.costPerKWh,
+		SavedKWh:  alwaysOn - kwh,
+		SavedCost: (alwaysOn - kwh) * e.costPerKWh,
+	}
+}
+
+// Days returns the estimate for each day the history covers, oldest first.
+// Every status but on counts as standby.
+func (e *energyMeter) Days(now time.Time) []energyUsage {
+	daily := e.history.Daily(now)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ret := make([]energyUsage, 0, len(daily))
+	for day := now.AddDate(0, 0, -powerHistoryDays); !day.After(now); day = day.AddDate(0, 0, 1) {
+		statuses, ok := daily[day.Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		var on, standby time.Duration
+		for status, d := range statuses {
+			if status == "on" {
+				on += d
+			} else {
+				standby += d
+			}
+		}
+		u := e.usage(on, standby)
+		u.Date = day.Format("2006-01-02")
+		ret = append(ret, u)
+	}
+	return ret
+}
+
+// Week totals the last seven days including today
+func (e *energyMeter) Week(now time.Time) energyUsage {
+	var on, standby time.Duration
+	weekStart := now.AddDate(0, 0, -6).Format("2006-01-02")
+	for _, u := range e.Days(now) {
+		if u.Date < weekStart {
+			continue
+		}
+		o, _ := time.ParseDuration(u.On)
+		s, _ := time.ParseDuration(u.Standby)
+		on += o
+		standby += s
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.usage(on, standby)
+}
+
+func (e *energyMeter) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		// rates can be set individually by wrapping the message in its key
+		if len(path) == 1 {
+			switch path[0] {
+			case "onWatts", "standbyWatts", "costPerKWh":
+			default:
+				return nil, &NotFoundError{Path: path}
+			}
+			b, err := json.Marshal(map[string]*json.RawMessage{path[0]: msg})
+			if err != nil {
+				return nil, err
+			}
+			msg = (*json.RawMessage)(&b)
+		} else if len(path) > 1 {
+			return nil, &NotFoundError{Path: path}
+		}
+		if err := json.Unmarshal(*msg, e); err != nil {
+			return nil, err
+		}
+	}
+	return serveValuePath(e, path)
+}
+
+func (e *energyMeter) MarshalJSON() ([]byte, error) {
+	now := time.Now()
+	days := e.Days(now)
+	week := e.Week(now)
+
+	today := energyUsage{Date: now.Format("2006-01-02"), On: "0s", Standby: "0s"}
+	if len(days) > 0 && days[len(days)-1].Date == today.Date {
+		today = days[len(days)-1]
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return json.Marshal(map[string]interface{}{
+		"onWatts":      e.onWatts,
+		"standbyWatts": e.standbyWatts,
+		"costPerKWh":   e.costPerKWh,
+		"today":        today,
+		"week":         week,
+		"days":         days,
+	})
+}
+
+// UnmarshalJSON sets the rates present in b.  The days are a report, posting
+// them changes nothing, only Restore reads them back.
+func (e *energyMeter) UnmarshalJSON(b []byte) error {
+	m := make(map[string]*json.RawMessage)
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	rates := map[string]float64{
+		"onWatts":      e.onWatts,
+		"standbyWatts": e.standbyWatts,
+		"costPerKWh":   e.costPerKWh,
+	}
+	e.lock.Unlock()
+
+	for key := range rates {
+		if v := m[key]; v != nil {
+			var f float64
+			if err := json.Unmarshal(*v, &f); err != nil {
+				return fmt.Errorf("display energy %s must be a number", key)
+			}
+			rates[key] = f
+		}
+	}
+
+	return e.SetRates(rates["onWatts"], rates["standbyWatts"], rates["costPerKWh"])
+}
+
+// Restore adds the days of persisted energy b to the history, it is only
+// called with the persistence file at startup
+func (e *energyMeter) Restore(b []byte) error {
+	var persisted struct {
+		Days []energyUsage `json:"days"`
+	}
+	if err := json.Unmarshal(b, &persisted); err != nil {
+		return err
+	}
+
+	for _, u := range persisted.Days {
+		if _, err := time.Parse("2006-01-02", u.Date); err != nil {
+			return fmt.Errorf("display energy day '%s' must be YYYY-MM-DD", u.Date)
+		}
+		on, err := time.ParseDuration(u.On)
+		if err != nil {
+			return err
+		}
+		standby, err := time.ParseDuration(u.Standby)
+		if err != nil {
+			return err
+		}
+		e.history.restore(u.Date, map[string]time.Duration{"on": on, "standby": standby})
+	}
+	return nil
+}
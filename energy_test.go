@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEnergyRestoresOnlyFromPersistence(t *testing.T) {
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	days := `{"days":[{"date":"` + yesterday + `","on":"2h0m0s","standby":"22h0m0s"}]}`
+
+	d := NewDummyDisplay()
+	onHours := func() float64 {
+		for _, u := range d.Energy().Days(time.Now()) {
+			if u.Date == yesterday {
+				on, _ := time.ParseDuration(u.On)
+				return on.Hours()
+			}
+		}
+		return 0
+	}
+
+	// days posted to the api are a report, not history
+	msg := json.RawMessage(days)
+	if _, err := d.ServeJSON([]string{"energy"}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	msg = json.RawMessage(`{"energy":` + days + `}`)
+	if _, err := d.ServeJSON(nil, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if on := onHours(); on != 0 {
+		t.Errorf("posting days added %vh on", on)
+	}
+
+	ui := &mirrorInterface{display: d}
+	if err := ui.restoreEnergy([]byte(`{"display":{"energy":` + days + `}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if on := onHours(); on != 2 {
+		t.Errorf("restored %vh on, expected 2h", on)
+	}
+
+	if err := ui.restoreEnergy([]byte(`{"display":{"powerStatus":"on"}}`)); err != nil {
+		t.Errorf("a file without energy totals: %v", err)
+	}
+	if err := d.Energy().Restore([]byte(`{"days":[{"date":"yesterday","on":"1h","standby":"1h"}]}`)); err == nil {
+		t.Errorf("restored a day that isn't a date")
+	}
+}
+
+func TestEnergyRates(t *testing.T) {
+	d := NewDummyDisplay()
+	msg := json.RawMessage(`100`)
+	if _, err := d.ServeJSON([]string{"energy", "onWatts"}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	msg = json.RawMessage(`-1`)
+	if _, err := d.ServeJSON([]string{"energy", "costPerKWh"}, &msg); err == nil {
+		t.Errorf("set a negative cost")
+	}
+
+	e := d.Energy()
+	u := e.usage(time.Hour, 10*time.Hour)
+	if !approx(u.KWh, 0.1+10*defaultStandbyWatts/1000) || !approx(u.SavedKWh, 1.1-u.KWh) {
+		t.Errorf("1h on and 10h standby at 100W used %v kWh and saved %v", u.KWh, u.SavedKWh)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	minFetchBackoff = 30 * time.Second
+)
+
+// fetcher calls an element's fetch every frequency.  Failures are retried
+// sooner, backing off exponentially from minFetchBackoff up to the frequency
+// so an outage doesn't hammer the provider.
+type fetcher struct {
+	name      string
+	frequency time.Duration
+	fetch     func() error
+	refetch   chan bool
+	quit      chan bool
+}
+
+func newFetcher(name string, frequency time.Duration, fetch func() error) *fetcher {
+	return &fetcher{
+		name:      name,
+		frequency: frequency,
+		fetch:     fetch,
+		refetch:   make(chan bool, 1),
+		quit:      make(chan bool),
+	}
+}
+
+// newFetchTransport has the timeouts the elements fetch with
+func newFetchTransport() *http.Transport {
+	return &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 5 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// run fetches until Close is called
+func (f *fetcher) run() {
+	backoff := time.Duration(0)
+	for {
+		wait := f.frequency
+		if err := f.fetch(); err != nil {
+			log.Printf("error fetching %s: %v", f.name, err)
+			backoff = nextBackoff(backoff, f.frequency)
+			wait = backoff
+		} else {
+			backoff = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-f.refetch:
+			timer.Stop()
+			backoff = 0
+		case <-f.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// nextBackoff doubles backoff, starting at minFetchBackoff and capped at
+// frequency so a long outage can't overflow it
+func nextBackoff(backoff time.Duration, frequency time.Duration) time.Duration {
+	if backoff == 0 {
+		backoff = minFetchBackoff
+	} else {
+		backoff *= 2
+	}
+	if backoff > frequency {
+		backoff = frequency
+	}
+	return backoff
+}
+
+// Refetch fetches at once, a pending refetch already covers this one
+func (f *fetcher) Refetch() {
+	select {
+	case f.refetch <- true:
+	default:
+	}
+}
+
+func (f *fetcher) Close() {
+	close(f.quit)
+}
+
+// Stale returns true when lastUpdated has missed more than one refresh
+func (f *fetcher) Stale(lastUpdated time.Time, now time.Time) bool {
+	return lastUpdated.IsZero() || now.Sub(lastUpdated) > 2*f.frequency
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	backoff := time.Duration(0)
+	for _, want := range []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		5 * time.Minute,
+		5 * time.Minute,
+	} {
+		if backoff = nextBackoff(backoff, 5*time.Minute); backoff != want {
+			t.Errorf("backoff = %v, want %v", backoff, want)
+		}
+	}
+
+	// a long outage used to double until it overflowed
+	for i := 0; i < 100; i++ {
+		backoff = nextBackoff(backoff, 5*time.Minute)
+	}
+	if backoff != 5*time.Minute {
+		t.Errorf("backoff after 100 failures = %v", backoff)
+	}
+}
+
+func TestFetcherRefetch(t *testing.T) {
+	fetched := make(chan error, 1)
+	f := newFetcher("test", time.Hour, func() error {
+		err := errors.New("unavailable")
+		fetched <- err
+		return err
+	})
+	go f.run()
+	defer f.Close()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-fetched:
+		case <-time.After(time.Second):
+			t.Fatalf("fetch %d didn't happen", i)
+		}
+		// refetching skips the backoff
+		f.Refetch()
+	}
+}
+
+func TestFetcherStale(t *testing.T) {
+	f := newFetcher("test", time.Hour, nil)
+	now := time.Now()
+	for _, c := range []struct {
+		lastUpdated time.Time
+		stale       bool
+	}{
+		{time.Time{}, true},
+		{now.Add(-time.Hour), false},
+		{now.Add(-2 * time.Hour), false},
+		{now.Add(-2*time.Hour - time.Second), true},
+	} {
+		if got := f.Stale(c.lastUpdated, now); got != c.stale {
+			t.Errorf("Stale(%v) = %v, want %v", now.Sub(c.lastUpdated), got, c.stale)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 module github.com/donniet/mirror2
 
+go 1.27.1
+
 require (
 	github.com/donniet/cec v0.0.0-20181103233317-c472bad81d48
 	github.com/donniet/mvnc v0.0.0-20181119134154-de2bc7c0c532
 	github.com/gorilla/websocket v1.4.0
 )
+
+// the fork exports received command parameters, see third_party/cec
+replace github.com/donniet/cec => ./third_party/cec
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultIcon = "Cloud"
+
+// conditionIcons maps the conditions providers report to the day and night
+// icons in client/SVG
+var conditionIcons = map[string]struct {
+	Day   string
+	Night string
+}{
+	"clear":            {"Sun", "Moon"},
+	"mostlyclear":      {"Cloud-Sun", "Cloud-Moon"},
+	"partlycloudy":     {"Cloud-Sun", "Cloud-Moon"},
+	"mostlycloudy":     {"Cloud-Sun", "Cloud-Moon"},
+	"cloudy":           {"Cloud", "Cloud"},
+	"fog":              {"Cloud-Fog", "Cloud-Fog"},
+	"haze":             {"Cloud-Fog-Sun", "Cloud-Fog-Moon"},
+	"drizzle":          {"Cloud-Drizzle", "Cloud-Drizzle"},
+	"chancerain":       {"Cloud-Rain-Sun-Alt", "Cloud-Rain-Moon-Alt"},
+	"rain":             {"Cloud-Rain", "Cloud-Rain"},
+	"showers":          {"Cloud-Rain-Sun", "Cloud-Rain-Moon"},
+	"chancesleet":      {"Cloud-Hail-Sun", "Cloud-Hail-Moon"},
+	"sleet":            {"Cloud-Hail", "Cloud-Hail"},
+	"chancesnow":       {"Cloud-Snow-Sun-Alt", "Cloud-Snow-Moon-Alt"},
+	"snow":             {"Cloud-Snow", "Cloud-Snow"},
+	"snowshowers":      {"Cloud-Snow-Sun", "Cloud-Snow-Moon"},
+	"flurries":         {"Cloud-Snow", "Cloud-Snow"},
+	"chancetstorms":    {"Cloud-Lightning-Sun", "Cloud-Lightning-Moon"},
+	"tstorms":          {"Cloud-Lightning", "Cloud-Lightning"},
+	"wind":             {"Wind", "Wind"},
+	"windpartlycloudy": {"Cloud-Wind-Sun", "Cloud-Wind-Moon"},
+	"windcloudy":       {"Cloud-Wind", "Cloud-Wind"},
+	"tornado":          {"Tornado", "Tornado"},
+	"hot":              {"Thermometer-100", "Thermometer-100"},
+	"cold":             {"Thermometer-Zero", "Thermometer-Zero"},
+}
+
+// iconResolver picks the icon for a condition, falling back to defaultIcon
+// when the condition is unknown or its icon isn't in the client
+type iconResolver struct {
+	available map[string]bool
+	warned    map[string]bool
+	lock      *sync.Mutex
+}
+
+var icons = &iconResolver{
+	warned: make(map[string]bool),
+	lock:   &sync.Mutex{},
+}
+
+// Verify reads the svg names in dir and warns about any mapped condition or
+// extra icon that doesn't exist.  Until it is called every name is assumed
+// to exist.
+func (r *iconResolver) Verify(dir string, extra ...string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	available := make(map[string]bool)
+	for _, f := range files {
+		if filepath.Ext(f.Name()) == ".svg" {
+			available[strings.TrimSuffix(f.Name(), ".svg")] = true
+		}
+	}
+
+	names := append([]string{defaultIcon}, extra...)
+	for _, i := range conditionIcons {
+		names = append(names, i.Day, i.Night)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if (i == 0 || names[i-1] != name) && !available[name] {
+			log.Printf("warning: icon %s is not in %s", name, dir)
+		}
+	}
+
+	r.lock.Lock()
+	r.available = available
+	r.lock.Unlock()
+	return nil
+}
+
+// Icon returns the icon for a condition in daylight or at night
+func (r *iconResolver) Icon(condition string, daylight bool) string {
+	i, ok := conditionIcons[condition]
+	if !ok {
+		if condition != "" {
+			r.warn(condition, "warning: no icon for condition '%s', using %s", condition, defaultIcon)
+		}
+		return r.Named(defaultIcon)
+	}
+
+	if daylight {
+		return r.Named(i.Day)
+	}
+	return r.Named(i.Night)
+}
+
+// Named returns name if the client has it and defaultIcon otherwise
+func (r *iconResolver) Named(name string) string {
+	r.lock.Lock()
+	available := r.available
+	r.lock.Unlock()
+
+	if available == nil || available[name] {
+		return name
+	}
+
+	r.warn(name, "warning: icon %s is missing, using %s", name, defaultIcon)
+	if available[defaultIcon] {
+		return defaultIcon
+	}
+	return ""
+}
+
+// warn logs once per key so a missing icon doesn't flood the log
+func (r *iconResolver) warn(key string, format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.warned[key] {
+		r.warned[key] = true
+		log.Printf(format, args...)
+	}
+}
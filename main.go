@@ -19,8 +19,14 @@ import (
 var (
 	graphFile                  = ""
 	deviceName                 = "Smart Mirror"
+	cecTarget                  = "TV"
+	displayName                = "cec"
 	videoFifo                  = "-"
 	motionFifo                 = ""
+	motionRecord               = ""
+	motionRecordRotate         = 1 * time.Hour
+	motionFps                  = 30.
+	replaySpeed                = 1.
 	mbx                        = 120
 	mby                        = 68
 	magnitude                  = 60
@@ -30,22 +36,75 @@ var (
 	persistenceFile    string  = "persist.json"
 	imageMean float64 = 25
 	imageStddev float64 = 30
+	weatherProviderName        = "nws"
+	alertProviderName          = "nws"
+	alertSeverity              = "Severe"
+	airQualityProviderName     = "openmeteo"
+	weatherCache               = "weather-cache"
+	weather                    = weatherConfig{}
+	sensorTimeout              = 15 * time.Minute
+	motionTimeout              = 10 * time.Minute
+	scheduleInterval           = 1 * time.Minute
+	powerPoll                  = 1 * time.Minute
+	reclaimInput               = false
+	mqttBroker                 = ""
+	mqttTopic                  = "mirror/sensors/#"
+	mqttUser                   = ""
+	mqttPassword               = ""
+	location                   = Location{
+		Name:      "Minneapolis",
+		Latitude:  44.9778,
+		Longitude: -93.2650,
+		Timezone:  "America/Chicago",
+	}
 )
 
 func init() {
 	flag.StringVar(&graphFile, "graph", graphFile, "graph file name")
 	flag.StringVar(&deviceName, "deviceName", deviceName, "CEC Device Name")
+	flag.StringVar(&cecTarget, "cecTarget", cecTarget, "logical address or name of the CEC device to control, like TV or Audio")
+	flag.StringVar(&displayName, "display", displayName, "display backend: cec[:adapter], dummy, backlight[:device], vcgencmd or xset")
 	flag.StringVar(&videoFifo, "video", videoFifo, "path to the video fifo")
-	flag.StringVar(&motionFifo, "motion", motionFifo, "path to the motion vectors fifo")
+	flag.StringVar(&motionFifo, "motion", motionFifo, "path to the motion vectors fifo, or replay:<file> to replay a recording")
+	flag.StringVar(&motionRecord, "motionRecord", motionRecord, "directory to record the motion vectors to, empty to disable")
+	flag.DurationVar(&motionRecordRotate, "motionRecordRotate", motionRecordRotate, "how long each motion recording file covers")
+	flag.Float64Var(&motionFps, "motionFps", motionFps, "frame rate the motion vectors were recorded at")
+	flag.Float64Var(&replaySpeed, "replaySpeed", replaySpeed, "speed to replay motion recordings at, 1 is real time and 0 as fast as possible")
 	flag.Float64Var(&detectionThreshold, "detectionThreshold", detectionThreshold, "threshold to constitute detection")
 	flag.IntVar(&mbx, "mbx", mbx, "motion vector X")
 	flag.IntVar(&mby, "mby", mby, "motion vector Y")
 	flag.IntVar(&magnitude, "magnitude", magnitude, "magnitude of motion vector")
 	flag.IntVar(&totalMotion, "totalMotion", totalMotion, "total motion vectors to trigger screen")
+	flag.BoolVar(&defaultAdaptiveMotion, "adaptiveMotion", defaultAdaptiveMotion, "learn each macroblock's background motion rather than using a fixed magnitude")
+	flag.StringVar(&defaultWakeOn, "wakeOn", defaultWakeOn, "motion that wakes the display: any, or approach to ignore people walking past")
+	flag.DurationVar(&defaultLingerTime, "lingerTime", defaultLingerTime, "time someone must stay in view to wake the display with -wakeOn=approach")
+	flag.Float64Var(&defaultMotionSigma, "motionSigma", defaultMotionSigma, "standard deviations above the learned background that count as motion")
 	flag.StringVar(&addr, "addr", addr, "address to host")
 	flag.StringVar(&persistenceFile, "persistenceFile", persistenceFile, "file to persist to")
 	flag.Float64Var(&imageMean, "imageMean", imageMean, "mean image value")
 	flag.Float64Var(&imageStddev, "imageStddev", imageStddev, "stddev image value")
+	flag.StringVar(&weatherProviderName, "weatherProvider", weatherProviderName, "weather provider: wunderground, openmeteo or nws")
+	flag.StringVar(&alertProviderName, "alertProvider", alertProviderName, "weather alert provider: nws or empty to disable")
+	flag.StringVar(&alertSeverity, "alertSeverity", alertSeverity, "minimum alert severity that turns on the display")
+	flag.StringVar(&airQualityProviderName, "airQualityProvider", airQualityProviderName, "air quality and pollen provider: openmeteo or empty to disable")
+	flag.StringVar(&weatherCache, "weatherCache", weatherCache, "directory to cache weather responses in, empty to disable")
+	flag.StringVar(&weather.WundergroundKey, "wundergroundKey", weather.WundergroundKey, "wunderground api key")
+	flag.StringVar(&location.Name, "location", location.Name, "name of the mirror's location")
+	flag.Float64Var(&location.Latitude, "latitude", location.Latitude, "latitude of the mirror")
+	flag.Float64Var(&location.Longitude, "longitude", location.Longitude, "longitude of the mirror")
+	flag.StringVar(&location.Timezone, "timezone", location.Timezone, "timezone of the mirror")
+	flag.DurationVar(&motionTimeout, "motionTimeout", motionTimeout, "time without motion before the display goes to standby")
+	flag.DurationVar(&scheduleInterval, "scheduleInterval", scheduleInterval, "how often the display schedule is checked")
+	flag.BoolVar(&reclaimInput, "reclaimInput", reclaimInput, "switch the display back to the mirror on motion if it was the last active source")
+	flag.Float64Var(&defaultOnWatts, "onWatts", defaultOnWatts, "display's power draw in watts when on, for energy estimates")
+	flag.Float64Var(&defaultStandbyWatts, "standbyWatts", defaultStandbyWatts, "display's power draw in watts in standby")
+	flag.Float64Var(&defaultCostPerKWh, "energyCost", defaultCostPerKWh, "price of a kilowatt hour")
+	flag.DurationVar(&powerPoll, "powerPoll", powerPoll, "how often the display's power status is polled to notice changes made by its own remote, 0 to disable")
+	flag.DurationVar(&sensorTimeout, "sensorTimeout", sensorTimeout, "time after which a sensor's reading is stale")
+	flag.StringVar(&mqttBroker, "mqtt", mqttBroker, "host:port of an MQTT broker to receive sensor readings from, empty to disable")
+	flag.StringVar(&mqttTopic, "mqttTopic", mqttTopic, "MQTT topic filter for sensor readings")
+	flag.StringVar(&mqttUser, "mqttUser", mqttUser, "username to log in to the MQTT broker with, empty for none")
+	flag.StringVar(&mqttPassword, "mqttPassword", mqttPassword, "password to log in to the MQTT broker with")
 }
 
 type Imager interface {
@@ -58,7 +117,15 @@ func main() {
 
 	log.Printf("starting")
 
-	var vid, mot *os.File
+	extraIcons := append([]string{"Sun", "Sunrise", "Sunset"}, moonIcons...)
+	for _, u := range unitSystems {
+		extraIcons = append(extraIcons, u.Icon)
+	}
+	if err := icons.Verify("client/SVG", extraIcons...); err != nil {
+		log.Printf("error verifying icons: %v", err)
+	}
+
+	var vid *os.File
 	var err error
 
 	var socketHandler *socketHandler
@@ -76,10 +143,52 @@ func main() {
 		}
 	}()
 
+	newWeatherProvider, err := newWeatherProviderFactory(weatherProviderName, weather)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newAlertProvider, err := newAlertProviderFactory(alertProviderName, weather)
+	if err != nil {
+		log.Fatal(err)
+	} else if _, ok := alertSeverities[alertSeverity]; !ok {
+		log.Fatalf("unknown alert severity '%s'", alertSeverity)
+	}
+	newAirQualityProvider, err := newAirQualityProviderFactory(airQualityProviderName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// without motion detection the display stays on unless scheduled off
+	schedule := Schedule{
+		Default:       scheduleMotion,
+		MotionTimeout: motionTimeout,
+		CheckInterval: scheduleInterval,
+		ReclaimInput:  reclaimInput,
+	}
+	if motionFifo == "" {
+		schedule.Default = scheduleOn
+	}
+
+	log.Printf("opening %s display", displayName)
+	target, err := cecLogicalAddress(cecTarget)
+	if err != nil {
+		log.Fatal(err)
+	}
+	disp, err := openDisplay(displayName, deviceName, target)
+	if err != nil {
+		log.Fatalf("error opening %s display, use -display=dummy to run without one: %v", displayName, err)
+	}
+	if powerPoll > 0 {
+		go reconcilePowerThread(disp, powerPoll)
+	}
+
 	log.Printf("starting mirror interface")
-	ui := NewMirrorInterface(
-		"http://api.wunderground.com/api/52a3d65a04655627/forecast/q/MN/Minneapolis.json",
-		changed, persistenceFile)
+	ui := NewMirrorInterface(disp, newWeatherProvider, newAlertProvider, newAirQualityProvider, location,
+		alertSeverity, weatherCache, sensorTimeout, schedule, changed, persistenceFile)
+
+	if mqttBroker != "" {
+		go ui.Sensors().Subscribe(mqttBroker, mqttTopic, mqttUser, mqttPassword)
+	}
 
 	socketHandler = newSocketHandler(ui)
 
@@ -122,9 +231,13 @@ func main() {
 	} else {
 		go func() {
 			log.Printf("opening motion fifo")
-			if mot, err = os.OpenFile(motionFifo, os.O_RDONLY, 0600); err != nil {
+			source, clock, err := openMotionSource(motionFifo, mbx+1, mby, motionFps, replaySpeed)
+			if err != nil {
 				log.Fatal(err)
 			}
+			if motionRecord != "" {
+				source = newMotionRecorder(source, motionRecord, mbx+1, mby, motionRecordRotate)
+			}
 			log.Printf("motion processor")
 			motionDetected := MotionProcessor{
 				mbx:       mbx,
@@ -132,37 +245,23 @@ func main() {
 				magnitude: magnitude,
 				total:     totalMotion,
 				throttle:  500 * time.Millisecond,
-			}.Process(mot)
+				monitor:   ui.Motion(),
+				clock:     clock,
+			}.Process(source)
 
 			log.Printf("starting motion detector")
-			go func() {
-
-				sleepAt := time.Now()
-				checker := time.NewTicker(1 * time.Minute)
-
-				for {
-					select {
-					case t := <-motionDetected:
-						if t == (time.Time{}) {
-							log.Printf("motion detection closed, do something smart here..")
-							motionDetected = make(chan time.Time)
-						} else {
-							log.Printf("motion detected at %v", t)
-							if ui.Display().PowerStatus() != "on" {
-								ui.Display().PowerOn()
-							}
-							sleepAt = t.Add(10 * time.Minute)
-						}
-					case <-checker.C:
-						log.Printf("checking power status")
-						powerStatus := ui.Display().PowerStatus()
-						if powerStatus != "standby" && sleepAt.Before(time.Now()) {
-							log.Printf("putting display on standby")
-							ui.Display().Standby()
-						}
-					}
+			for t := range motionDetected {
+				if t == (time.Time{}) {
+					log.Printf("motion detection closed, do something smart here..")
+					break
 				}
-			}()
+				if clock != nil {
+					// a replay's clock may run ahead, the display is timed
+					// by the wall clock
+					t = time.Now()
+				}
+				ui.Scheduler().Motion(t)
+			}
 		}()
 	}
 
@@ -190,8 +289,11 @@ func main() {
 			return
 		}
 
-		jpeg.Encode(w, imager.Image(), &jpeg.Options{75})
+		jpeg.Encode(w, imager.Image(), &jpeg.Options{Quality: 75})
 	})
+	// the heatmap as an image beside its counts at /api/v2/motion/heatmap,
+	// the longer pattern takes it from the api
+	http.Handle("/api/v2/motion/heatmap.png", ui.Motion().Heatmap())
 
 	log.Printf("serving on %s", addr)
 	log.Fatal(http.ListenAndServe(addr, nil))
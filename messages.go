@@ -9,10 +9,19 @@ type Server interface {
 	ServeJSON(paths []string, msg *json.RawMessage) (*json.RawMessage, error)
 }
 
+// Element is a part of the mirror that can be shown and hidden
+type Element interface {
+	Name() string
+	Visible() bool
+	Show()
+	Hide()
+}
+
 type Display interface {
 	Server
-	PowerOn()
-	Standby()
+	PowerOn()                        /* SetPower(true, reasonAPI) */
+	Standby()                        /* SetPower(false, reasonAPI) */
+	SetPower(on bool, reason string) /* records why the power changed in the history */
 	VolumeUp()
 	VolumeDown()
 	Mute()
@@ -21,17 +30,27 @@ type Display interface {
 	Key(key int)
 	OSDName() string
 	IsActiveSource() bool
+	LastActiveSource() bool /* true if no other device has claimed the input since the mirror last had it */
+	SetActiveSource() error
+	InactiveSource() error
+	SelectInput(physicalAddress string) error /* switches the display to another device's input */
 	VendorID() uint64
 	PhysicalAddress() string
 	PowerStatus() string
+	History() *powerHistory
+	Energy() *energyMeter
+	SetBrightness(percent int) error /* 0 to 100, emulated with a client side overlay when the display can't dim */
+	Brightness() int
 	Sleep(duration string) error /* puts the screen in standby mode and ignores motion for the duration */
 	Wake(duration string) error  /* ensures the screen stays awake for the duration regardless of motion */
+	Policy() *powerPolicy        /* who controls the power: motion, the schedule or a forced sleep or wake */
 	MotionActivated() bool
 	SleepingUntil() time.Time
 	WakingUntil() time.Time
 	Sleeping() bool
 	Waking() bool
 	Changed() <-chan bool
+	Keys() <-chan string /* remote control keys translated for the navigator, nil if the display has no remote */
 }
 
 type socketRequest struct {
@@ -22,36 +22,85 @@ func (n *NotFoundError) Error() string {
 	return fmt.Sprintf("path not found: %v", n.Path)
 }
 
-func NewMirrorInterface(weatherURL string, changed chan<- socketResponse, persistenceFile string) *mirrorInterface {
-	log.Printf("creating cec display interface")
-	var disp Display
-	var err error
-	if disp, err = NewCECDisplay("", "Smart Mirror"); err != nil {
-		log.Printf("error opening CEC display, using dummy: %v", err)
-		disp = NewDummyDisplay()
+// serveValuePath marshals v and walks the remaining path through the
+// resulting objects and arrays, e.g. []string{"2", "high"}
+func serveValuePath(v interface{}, path []string) (*json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var node interface{}
+	if err = json.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+
+	for i, p := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if node, ok = n[p]; !ok {
+				return nil, &NotFoundError{Path: path[i:]}
+			}
+		case []interface{}:
+			j, err := strconv.Atoi(p)
+			if err != nil || j < 0 || j >= len(n) {
+				return nil, &NotFoundError{Path: path[i:]}
+			}
+			node = n[j]
+		default:
+			return nil, &NotFoundError{Path: path[i:]}
+		}
 	}
 
-	log.Printf("creating rest of mirror interface")
+	b, err = json.Marshal(node)
+	return (*json.RawMessage)(&b), err
+}
+
+func NewMirrorInterface(disp Display, newWeatherProvider weatherProviderFactory, newAlertProvider alertProviderFactory, newAirQualityProvider airQualityProviderFactory, location Location, alertSeverity string, weatherCache string, sensorTimeout time.Duration, schedule Schedule, changed chan<- socketResponse, persistenceFile string) *mirrorInterface {
+	log.Printf("creating mirror interface")
 	mi := &mirrorInterface{
-		changed: changed,
-		weather: newWeatherElement(weatherURL, make(chan bool), time.Hour),
-		display: disp,
+		changed:   changed,
+		scheduler: newDisplayScheduler(disp, schedule, realClock{}, make(chan bool)),
+		weather:   newWeatherElement(newWeatherProvider, location, make(chan bool), time.Hour, weatherCache),
+		display:   disp,
+		astronomy: newAstronomyElement(location, make(chan bool)),
+		sensors:   newSensorsElement(sensorTimeout, make(chan bool)),
+		motion:    newMotionMonitor(make(chan bool)),
 		date: &dateTimeElement{
 			visible: false,
 			changed: make(chan bool),
 		},
 		streamChanged:   make(chan *streamElement),
 		persistenceFile: persistenceFile,
+		persistPending:  make(chan bool, 1),
+	}
+
+	if newAlertProvider != nil {
+		mi.weather.alerts = newAlertsElement(newAlertProvider, location, disp, alertSeverity, make(chan bool), 5*time.Minute)
+	}
+	if newAirQualityProvider != nil {
+		mi.airQuality = newAirQualityElement(newAirQualityProvider, location, make(chan bool), 30*time.Minute)
 	}
 
+	mi.navigator = newNavigator(mi)
+	go mi.navigator.listen(disp.Keys())
+
 	log.Printf("starting changed loop")
 	go mi.handleChanged()
 
 	if b, err := ioutil.ReadFile(persistenceFile); err == nil {
+		if err = mi.restoreEnergy(b); err != nil {
+			log.Printf("error restoring energy totals: %v", err)
+		}
 		if err = json.Unmarshal(b, mi); err != nil {
 			log.Printf("error reading persistence file: %v", err)
 		}
 	}
+	go mi.persistThread()
+	if mi.weather.alerts != nil {
+		mi.weather.alerts.Start()
+	}
 
 	log.Printf("done creating mirror interface")
 	return mi
@@ -61,22 +110,79 @@ type mirrorInterface struct {
 	changed         chan<- socketResponse
 	weather         *weatherElement
 	date            *dateTimeElement
+	astronomy       *astronomyElement
+	sensors         *sensorsElement
+	airQuality      *airQualityElement
 	display         Display
+	scheduler       *displayScheduler
+	motion          *motionMonitor
+	navigator       *navigator
 	streams         []*streamElement
 	video           *videoElement
 	streamChanged   chan *streamElement
 	persistenceFile string
+	persistPending  chan bool
 }
 
+// persistDelay collects the changes that follow one another, e.g. every
+// element after a location change, into one write
+const persistDelay = 2 * time.Second
+
+// persist writes the mirror to the persistence file soon, a pending write
+// covers this change too
 func (ui *mirrorInterface) persist() {
+	select {
+	case ui.persistPending <- true:
+	default:
+	}
+}
+
+func (ui *mirrorInterface) persistThread() {
+	for range ui.persistPending {
+		time.Sleep(persistDelay)
+		select {
+		case <-ui.persistPending:
+		default:
+		}
+		ui.writePersistenceFile()
+	}
+}
+
+func (ui *mirrorInterface) writePersistenceFile() {
 	if b, err := json.Marshal(ui); err != nil {
-		log.Fatal(err)
+		log.Printf("error persisting the mirror: %v", err)
 	} else if err = ioutil.WriteFile(ui.persistenceFile, b, 0660); err != nil {
-		log.Fatal(err)
+		log.Printf("error writing persistence file: %v", err)
 	}
 }
 
+// restoreEnergy adds the display's energy totals in the persistence file b to
+// its history.  Totals posted to the api are ignored so they can't be made
+// up.
+func (ui *mirrorInterface) restoreEnergy(b []byte) error {
+	var persisted struct {
+		Display struct {
+			Energy *json.RawMessage `json:"energy"`
+		} `json:"display"`
+	}
+	if err := json.Unmarshal(b, &persisted); err != nil {
+		return err
+	} else if persisted.Display.Energy == nil {
+		return nil
+	}
+	return ui.display.Energy().Restore(*persisted.Display.Energy)
+}
+
 func (ui *mirrorInterface) handleChanged() {
+	var alertsChanged chan bool
+	if ui.weather.alerts != nil {
+		alertsChanged = ui.weather.alerts.changed
+	}
+	var airQualityChanged chan bool
+	if ui.airQuality != nil {
+		airQualityChanged = ui.airQuality.changed
+	}
+
 	for {
 		select {
 		case <-ui.weather.changed:
@@ -84,6 +190,29 @@ func (ui *mirrorInterface) handleChanged() {
 				Request:  &socketRequest{Path: "weather"},
 				Response: ui.weather,
 			}
+			// the astronomy and air quality follow the main weather location
+			loc := ui.weather.Location()
+			if ui.airQuality != nil {
+				ui.airQuality.setLocation(loc)
+			}
+			if ui.astronomy.setLocation(loc) {
+				ui.changed <- socketResponse{
+					Request:  &socketRequest{Path: "astronomy"},
+					Response: ui.astronomy,
+				}
+			}
+			ui.persist()
+		case <-alertsChanged:
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "weather/alerts"},
+				Response: ui.weather.alerts,
+			}
+			ui.persist()
+		case <-airQualityChanged:
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "airQuality"},
+				Response: ui.airQuality,
+			}
 			ui.persist()
 		case <-ui.date.changed:
 			ui.changed <- socketResponse{
@@ -91,11 +220,57 @@ func (ui *mirrorInterface) handleChanged() {
 				Response: ui.date,
 			}
 			ui.persist()
+		case <-ui.astronomy.changed:
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "astronomy"},
+				Response: ui.astronomy,
+			}
+			// sunrise and sunset switch the weather between day and night
+			// icons
+			if ui.weather.refreshIcons() {
+				ui.changed <- socketResponse{
+					Request:  &socketRequest{Path: "weather"},
+					Response: ui.weather,
+				}
+			}
+			ui.persist()
+		case <-ui.sensors.changed:
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "sensors"},
+				Response: ui.sensors,
+			}
+			ui.persist()
+		case <-ui.sensors.readingsChanged:
+			// readings aren't persisted
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "sensors"},
+				Response: ui.sensors,
+			}
 		case <-ui.display.Changed():
 			ui.changed <- socketResponse{
 				Request:  &socketRequest{Path: "display"},
 				Response: ui.display,
 			}
+			// the display's policy deadlines and energy totals are persisted
+			ui.persist()
+		case <-ui.scheduler.changed:
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "schedule"},
+				Response: ui.scheduler,
+			}
+			ui.persist()
+		case <-ui.motion.changed:
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "motion"},
+				Response: ui.motion,
+			}
+			ui.persist()
+		case <-ui.motion.eventsChanged:
+			// only the latest event is pushed, the rest are at motion/events
+			ui.changed <- socketResponse{
+				Request:  &socketRequest{Path: "motion/event"},
+				Response: ui.motion.LastEvent(),
+			}
 		case <-ui.streamChanged:
 			ui.sendStreamsChanged()
 			ui.persist()
@@ -128,10 +303,28 @@ func (ui *mirrorInterface) ServeJSON(path []string, msg *json.RawMessage) (ret *
 		ret, err = ui.weather.ServeJSON(path[1:], msg)
 	case "dateTime":
 		ret, err = ui.date.ServeJSON(path[1:], msg)
+	case "astronomy":
+		ret, err = ui.astronomy.ServeJSON(path[1:], msg)
+	case "sensors":
+		ret, err = ui.sensors.ServeJSON(path[1:], msg)
+	case "airQuality":
+		if ui.airQuality == nil {
+			ret, err = nil, &NotFoundError{Path: path}
+		} else {
+			ret, err = ui.airQuality.ServeJSON(path[1:], msg)
+		}
 	case "video":
 		ret, err = ui.video.ServeJSON(path[1:], msg)
 	case "display":
 		ret, err = ui.display.ServeJSON(path[1:], msg)
+	case "schedule":
+		ret, err = ui.scheduler.ServeJSON(path[1:], msg)
+	case "motion":
+		ret, err = ui.motion.ServeJSON(path[1:], msg)
+	case "navigation":
+		ret, err = ui.navigator.ServeJSON(path[1:], msg)
+	case "units":
+		ret, err = ui.serveJSONUnits(path[1:], msg)
 	default:
 		ret, err = nil, &NotFoundError{Path: path}
 	}
@@ -139,6 +332,23 @@ func (ui *mirrorInterface) ServeJSON(path []string, msg *json.RawMessage) (ret *
 	return
 }
 
+func (ui *mirrorInterface) serveJSONUnits(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		var units string
+		if err := json.Unmarshal(*msg, &units); err != nil {
+			return nil, err
+		} else if err := ui.SetUnits(units); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(path) == 0 {
+		b, err := json.Marshal(ui.Units())
+		return (*json.RawMessage)(&b), err
+	}
+	return serveValuePath(unitSystems[ui.Units()], path)
+}
+
 func (ui *mirrorInterface) serveJSONStreams(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
 	ss := ui.Streams()
 
@@ -181,6 +391,14 @@ func (ui *mirrorInterface) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	if u := m["units"]; u != nil {
+		var units string
+		if err := json.Unmarshal(*u, &units); err != nil {
+			return err
+		} else if err := ui.SetUnits(units); err != nil {
+			return err
+		}
+	}
 	if w := m["weather"]; w != nil {
 		if err := json.Unmarshal(*w, ui.weather); err != nil {
 			return err
@@ -191,6 +409,21 @@ func (ui *mirrorInterface) UnmarshalJSON(data []byte) error {
 			return err
 		}
 	}
+	if a := m["astronomy"]; a != nil {
+		if err := json.Unmarshal(*a, ui.astronomy); err != nil {
+			return err
+		}
+	}
+	if s := m["sensors"]; s != nil {
+		if err := json.Unmarshal(*s, ui.sensors); err != nil {
+			return err
+		}
+	}
+	if a := m["airQuality"]; a != nil && ui.airQuality != nil {
+		if err := json.Unmarshal(*a, ui.airQuality); err != nil {
+			return err
+		}
+	}
 	if v := m["video"]; v != nil {
 		if err := json.Unmarshal(*v, ui.video); err != nil {
 			return err
@@ -201,6 +434,16 @@ func (ui *mirrorInterface) UnmarshalJSON(data []byte) error {
 			return err
 		}
 	}
+	if s := m["schedule"]; s != nil {
+		if err := json.Unmarshal(*s, ui.scheduler); err != nil {
+			return err
+		}
+	}
+	if mo := m["motion"]; mo != nil {
+		if err := json.Unmarshal(*mo, ui.motion); err != nil {
+			return err
+		}
+	}
 	if s := m["streams"]; s != nil {
 		var sl []*json.RawMessage
 
@@ -225,10 +468,18 @@ func (ui *mirrorInterface) UnmarshalJSON(data []byte) error {
 func (ui *mirrorInterface) MarshalJSON() ([]byte, error) {
 	ret := make(map[string]interface{})
 	ret["streams"] = ui.Streams()
+	ret["units"] = ui.Units()
 	ret["weather"] = ui.Weather()
 	ret["dateTime"] = ui.DateTime()
+	ret["astronomy"] = ui.Astronomy()
+	ret["sensors"] = ui.Sensors()
+	if ui.airQuality != nil {
+		ret["airQuality"] = ui.AirQuality()
+	}
 	ret["video"] = ui.Video()
 	ret["display"] = ui.Display()
+	ret["schedule"] = ui.Scheduler()
+	ret["motion"] = ui.Motion()
 	return json.Marshal(ret)
 }
 
@@ -239,6 +490,19 @@ func (ui *mirrorInterface) Streams() (ret []*streamElement) {
 	return
 }
 
+// Units returns the unit system, imperial, metric or mixed, that elements
+// display their measurements in
+func (ui *mirrorInterface) Units() string {
+	return ui.weather.Units()
+}
+
+func (ui *mirrorInterface) SetUnits(units string) error {
+	if err := ui.weather.SetUnits(units); err != nil {
+		return err
+	}
+	return ui.sensors.SetUnits(units)
+}
+
 func (ui *mirrorInterface) Weather() *weatherElement {
 	return ui.weather
 }
@@ -247,6 +511,18 @@ func (ui *mirrorInterface) DateTime() *dateTimeElement {
 	return ui.date
 }
 
+func (ui *mirrorInterface) Astronomy() *astronomyElement {
+	return ui.astronomy
+}
+
+func (ui *mirrorInterface) Sensors() *sensorsElement {
+	return ui.sensors
+}
+
+func (ui *mirrorInterface) AirQuality() *airQualityElement {
+	return ui.airQuality
+}
+
 func (ui *mirrorInterface) Video() *videoElement {
 	return ui.video
 }
@@ -255,6 +531,14 @@ func (ui *mirrorInterface) Display() Display {
 	return ui.display
 }
 
+func (ui *mirrorInterface) Scheduler() *displayScheduler {
+	return ui.scheduler
+}
+
+func (ui *mirrorInterface) Motion() *motionMonitor {
+	return ui.motion
+}
+
 func (ui *mirrorInterface) AddStream(url string, visible bool) *streamElement {
 	s := &streamElement{
 		url:     url,
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// the baseline forgets at about this many frames, ten minutes at the
+	// motion processor's two frames a second
+	motionBaselineFrames = 1200
+	// frames learned before the baseline replaces the fixed magnitude
+	motionBaselineWarmup = 20
+	// floors on the deviations so a perfectly still block isn't triggered by
+	// the smallest change
+	minMagnitudeStd = 1.
+	minSadStd       = 16.
+)
+
+// defaults for adaptive detection, set by flags and the api
+var (
+	defaultAdaptiveMotion = false
+	defaultMotionSigma    = 3.
+)
+
+// motionBaseline learns each macroblock's usual vector magnitude and SAD so
+// a fan or flickering light stops looking like motion.  With adaptive
+// detection a block is active when either is more than sigma standard
+// deviations above its mean, otherwise the fixed magnitude applies.
+type motionBaseline struct {
+	width           int
+	height          int
+	magMean         []float64
+	magVar          []float64
+	sadMean         []float64
+	sadVar          []float64
+	frames          int
+	adaptive        bool
+	sigma           float64
+	calibrateFor    time.Duration
+	calibrateUntil  time.Time
+	calibrateFrames int
+	last            time.Time
+	lock            *sync.Mutex
+}
+
+func newMotionBaseline() *motionBaseline {
+	return &motionBaseline{
+		adaptive: defaultAdaptiveMotion,
+		sigma:    defaultMotionSigma,
+		lock:     &sync.Mutex{},
+	}
+}
+
+// reset forgets what was learned, the lock must be held
+func (b *motionBaseline) reset(width, height int) {
+	b.width = width
+	b.height = height
+	b.magMean = make([]float64, width*height)
+	b.magVar = make([]float64, width*height)
+	b.sadMean = make([]float64, width*height)
+	b.sadVar = make([]float64, width*height)
+	b.frames = 0
+	b.calibrateFrames = 0
+}
+
+// Observe marks the active blocks of a width by height frame and learns from
+// it.  Blocks whose squared magnitude exceeds mag2 are active until the
+// baseline has warmed up or when it isn't adaptive.  It returns true while
+// calibrating, when every frame is taken as background and there is no
+// motion.  now is the processor's clock, a replay's frame time.
+func (b *motionBaseline) Observe(now time.Time, vect []motionVector, width, height int, mag2 int, active []bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.width != width || b.height != height {
+		b.reset(width, height)
+	}
+	if b.calibrateFor > 0 {
+		// a calibration starts with the first frame after it was asked for
+		b.calibrateUntil = now.Add(b.calibrateFor)
+		b.calibrateFor = 0
+	}
+	b.last = now
+
+	calibrating := now.Before(b.calibrateUntil)
+	adaptive := b.adaptive && b.frames >= motionBaselineWarmup && !calibrating
+
+	// learn quickly as a plain average while calibrating or warming up
+	rate := 1. / motionBaselineFrames
+	if calibrating {
+		b.calibrateFrames++
+		rate = 1 / float64(b.calibrateFrames)
+	} else if b.frames < motionBaselineWarmup {
+		rate = 1 / float64(b.frames+1)
+	}
+
+	for i, v := range vect {
+		m2 := int(v.X)*int(v.X) + int(v.Y)*int(v.Y)
+		m := math.Sqrt(float64(m2))
+		s := float64(v.Sad)
+
+		if adaptive {
+			magStd := math.Max(math.Sqrt(b.magVar[i]), minMagnitudeStd)
+			sadStd := math.Max(math.Sqrt(b.sadVar[i]), minSadStd)
+			active[i] = m-b.magMean[i] > b.sigma*magStd || s-b.sadMean[i] > b.sigma*sadStd
+		} else {
+			active[i] = m2 > mag2
+		}
+
+		r := rate
+		if active[i] && !calibrating {
+			// motion is learned slowly so a person standing still doesn't
+			// become background at once
+			r /= 10
+		}
+		d := m - b.magMean[i]
+		b.magMean[i] += r * d
+		b.magVar[i] = (1 - r) * (b.magVar[i] + r*d*d)
+		d = s - b.sadMean[i]
+		b.sadMean[i] += r * d
+		b.sadVar[i] = (1 - r) * (b.sadVar[i] + r*d*d)
+	}
+	b.frames++
+
+	return calibrating
+}
+
+// Calibrate forgets the baseline and learns every frame as background for
+// the duration, timed by the frames so a replay calibrates for as long
+func (b *motionBaseline) Calibrate(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("motion calibration must last a positive duration")
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.reset(b.width, b.height)
+	b.calibrateFor = d
+	b.calibrateUntil = time.Time{}
+	return nil
+}
+
+func (b *motionBaseline) SetAdaptive(adaptive bool, sigma float64) error {
+	if sigma <= 0 {
+		return fmt.Errorf("motion sigma must be positive")
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.adaptive = adaptive
+	b.sigma = sigma
+	return nil
+}
+
+// Summary is the baseline without its model, small enough for the
+// websocket and the persistence file
+func (b *motionBaseline) Summary() map[string]interface{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.summary()
+}
+
+func (b *motionBaseline) summary() map[string]interface{} {
+	r := map[string]interface{}{
+		"adaptive": b.adaptive,
+		"sigma":    b.sigma,
+		"frames":   b.frames,
+		"ready":    b.frames >= motionBaselineWarmup,
+		"width":    b.width,
+		"height":   b.height,
+	}
+	if b.calibrateFor > 0 {
+		// no frame has been seen since calibration was asked for
+		r["calibratingFor"] = b.calibrateFor.String()
+	} else if b.last.Before(b.calibrateUntil) {
+		r["calibratingUntil"] = b.calibrateUntil.Format(time.RFC3339)
+	}
+	return r
+}
+
+// MarshalJSON includes each block's means and standard deviations, row by
+// row, rounded to a tenth
+func (b *motionBaseline) MarshalJSON() ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	std := func(v []float64) []float64 {
+		ret := make([]float64, len(v))
+		for i := range v {
+			ret[i] = math.Round(math.Sqrt(v[i])*10) / 10
+		}
+		return ret
+	}
+	round := func(v []float64) []float64 {
+		ret := make([]float64, len(v))
+		for i := range v {
+			ret[i] = math.Round(v[i]*10) / 10
+		}
+		return ret
+	}
+
+	r := b.summary()
+	r["magnitude"] = map[string]interface{}{
+		"mean": round(b.magMean),
+		"std":  std(b.magVar),
+	}
+	r["sad"] = map[string]interface{}{
+		"mean": round(b.sadMean),
+		"std":  std(b.sadVar),
+	}
+	return json.Marshal(r)
+}
+
+// UnmarshalJSON sets adaptive and sigma, the model itself is learned
+func (b *motionBaseline) UnmarshalJSON(data []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	adaptive, sigma := b.adaptive, b.sigma
+	b.lock.Unlock()
+
+	if a, ok := m["adaptive"]; ok {
+		if adaptive, ok = a.(bool); !ok {
+			return fmt.Errorf("motion baseline adaptive must be a boolean")
+		}
+	}
+	if s, ok := m["sigma"]; ok {
+		if sigma, ok = s.(float64); !ok {
+			return fmt.Errorf("motion baseline sigma must be a number")
+		}
+	}
+	return b.SetAdaptive(adaptive, sigma)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBaselineCalibratesOnTheFrameClock(t *testing.T) {
+	// a replay recorded long ago
+	start := time.Date(2020, 3, 1, 8, 0, 0, 0, time.UTC)
+	vect := make([]motionVector, 4)
+	active := make([]bool, len(vect))
+
+	b := newMotionBaseline()
+	if err := b.Calibrate(0); err == nil {
+		t.Errorf("calibrated for no time")
+	}
+	if err := b.Calibrate(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.Summary(); s["calibratingFor"] != "1m0s" {
+		t.Errorf("summary before a frame %v", s)
+	}
+
+	for _, test := range []struct {
+		at          time.Duration
+		calibrating bool
+	}{
+		{0, true},
+		{30 * time.Second, true},
+		{59 * time.Second, true},
+		{time.Minute, false},
+		{2 * time.Minute, false},
+	} {
+		if c := b.Observe(start.Add(test.at), vect, 2, 2, 4, active); c != test.calibrating {
+			t.Errorf("calibrating %v at %v", c, test.at)
+		}
+		_, calibrating := b.Summary()["calibratingUntil"]
+		if calibrating != test.calibrating {
+			t.Errorf("summary calibrating %v at %v", calibrating, test.at)
+		}
+	}
+
+	if until := b.Summary()["calibratingUntil"]; until != nil {
+		t.Errorf("still calibrating until %v", until)
+	}
+}
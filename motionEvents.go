@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// motion events kept for the api
+const motionEventCount = 100
+
+type motionPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// motionDirection is the average movement of the active blocks in the
+// encoder's motion vector units.  The vectors point back to where each block came
+// from, so the movement is their negated mean.
+type motionDirection struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Heading string  `json:"heading"`
+}
+
+// motionEvent describes a frame in which motion was detected, positions are
+// in macroblocks from the top left of the frame.  Class is what the tracked
+// blob is doing and Wake whether the wake policy let it wake the display.
+type motionEvent struct {
+	Time      time.Time       `json:"time"`
+	Blocks    int             `json:"blocks"`
+	Score     float64         `json:"score"`
+	Region    string          `json:"region"`
+	Centroid  motionPoint     `json:"centroid"`
+	Bounds    motionRect      `json:"bounds"`
+	Direction motionDirection `json:"direction"`
+	Class     string          `json:"class"`
+	Wake      bool            `json:"wake"`
+}
+
+func newMotionEvent(t time.Time, vect []motionVector, active []bool, width int, score float64, region string) motionEvent {
+	ev := motionEvent{
+		Time:   t,
+		Score:  score,
+		Region: region,
+	}
+
+	minX, minY, maxX, maxY := width, len(active)/width, -1, -1
+	var sx, sy, dx, dy float64
+	for i, a := range active {
+		if !a {
+			continue
+		}
+		x, y := i%width, i/width
+		ev.Blocks++
+		sx += float64(x)
+		sy += float64(y)
+		dx -= float64(vect[i].X)
+		dy -= float64(vect[i].Y)
+		if x < minX {
+			minX = x
+		}
+		if x > maxX {
+			maxX = x
+		}
+		if y < minY {
+			minY = y
+		}
+		if y > maxY {
+			maxY = y
+		}
+	}
+	if ev.Blocks == 0 {
+		ev.Direction.Heading = "none"
+		return ev
+	}
+
+	n := float64(ev.Blocks)
+	ev.Centroid = motionPoint{X: sx / n, Y: sy / n}
+	ev.Bounds = motionRect{X: minX, Y: minY, Width: maxX - minX + 1, Height: maxY - minY + 1}
+	ev.Direction = motionDirection{X: dx / n, Y: dy / n}
+	ev.Direction.Heading = heading(ev.Direction.X, ev.Direction.Y)
+	return ev
+}
+
+// heading names the larger component of a movement
+func heading(x, y float64) string {
+	switch {
+	case x == 0 && y == 0:
+		return "none"
+	case math.Abs(x) >= math.Abs(y) && x > 0:
+		return "right"
+	case math.Abs(x) >= math.Abs(y):
+		return "left"
+	case y > 0:
+		return "down"
+	default:
+		return "up"
+	}
+}
+
+// motionHeatmap counts how often each macroblock has been active.  Excluded
+// regions are counted too so noisy areas worth excluding show up.
+type motionHeatmap struct {
+	width  int
+	height int
+	frames int
+	since  time.Time
+	counts []int
+	lock   *sync.Mutex
+}
+
+func newMotionHeatmap() *motionHeatmap {
+	return &motionHeatmap{
+		since: time.Now(),
+		lock:  &sync.Mutex{},
+	}
+}
+
+func (h *motionHeatmap) Add(active []bool, width, height int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.width != width || h.height != height {
+		h.reset(width, height)
+	}
+	for i, a := range active {
+		if a {
+			h.counts[i]++
+		}
+	}
+	h.frames++
+}
+
+func (h *motionHeatmap) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.reset(h.width, h.height)
+}
+
+// reset clears the counts, the lock must be held
+func (h *motionHeatmap) reset(width, height int) {
+	h.width = width
+	h.height = height
+	h.frames = 0
+	h.since = time.Now()
+	h.counts = make([]int, width*height)
+}
+
+// Image renders the heatmap with scale pixels per macroblock, from black
+// through red and yellow to white at the busiest block
+func (h *motionHeatmap) Image(scale int) image.Image {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, h.width*scale, h.height*scale))
+
+	most := 0
+	for _, c := range h.counts {
+		if c > most {
+			most = c
+		}
+	}
+
+	for i, c := range h.counts {
+		v := 0.
+		if most > 0 {
+			v = 3 * float64(c) / float64(most)
+		}
+		col := color.RGBA{
+			R: uint8(255 * math.Min(v, 1)),
+			G: uint8(255 * math.Min(math.Max(v-1, 0), 1)),
+			B: uint8(255 * math.Min(math.Max(v-2, 0), 1)),
+			A: 255,
+		}
+		x, y := i%h.width*scale, i/h.width*scale
+		for py := y; py < y+scale; py++ {
+			for px := x; px < x+scale; px++ {
+				img.SetRGBA(px, py, col)
+			}
+		}
+	}
+	return img
+}
+
+func (h *motionHeatmap) MarshalJSON() ([]byte, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return json.Marshal(map[string]interface{}{
+		"width":  h.width,
+		"height": h.height,
+		"frames": h.frames,
+		"since":  h.since.Format(time.RFC3339),
+		"counts": h.counts,
+	})
+}
+
+// ServeHTTP serves the heatmap as a PNG with the scale query parameter's
+// pixels per macroblock, 8 by default
+func (h *motionHeatmap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scale := 8
+	if s := r.URL.Query().Get("scale"); s != "" {
+		var err error
+		if scale, err = strconv.Atoi(s); err != nil || scale < 1 || scale > 64 {
+			http.Error(w, "scale must be between 1 and 64", 400)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, h.Image(scale))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeatmap(t *testing.T) {
+	h := newMotionHeatmap()
+
+	// a 3x2 grid, the top left block is active in every frame and two
+	// others in one each
+	h.Add([]bool{true, false, false, false, false, false}, 3, 2)
+	h.Add([]bool{true, true, false, false, false, false}, 3, 2)
+	h.Add([]bool{true, false, false, false, false, true}, 3, 2)
+
+	counts := func() (frames int, c []int) {
+		t.Helper()
+		var m struct {
+			Width  int   `json:"width"`
+			Height int   `json:"height"`
+			Frames int   `json:"frames"`
+			Counts []int `json:"counts"`
+		}
+		b, err := json.Marshal(h)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		} else if len(m.Counts) != m.Width*m.Height {
+			t.Fatalf("%d counts for a %dx%d grid", len(m.Counts), m.Width, m.Height)
+		}
+		return m.Frames, m.Counts
+	}
+
+	frames, c := counts()
+	if want := []int{3, 1, 0, 0, 0, 1}; frames != 3 || !reflect.DeepEqual(c, want) {
+		t.Errorf("%d frames counted %v, want 3 frames counted %v", frames, c, want)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/motion/heatmap.png?scale=4", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("served %s", ct)
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 3*4 || b.Dy() != 2*4 {
+		t.Errorf("image is %dx%d, want 12x8 for a 3x2 grid at scale 4", b.Dx(), b.Dy())
+	}
+	// the busiest block is white and those never active black
+	for _, test := range []struct {
+		x, y int
+		col  color.RGBA
+	}{
+		{0, 0, color.RGBA{255, 255, 255, 255}},
+		{3, 3, color.RGBA{255, 255, 255, 255}},
+		{4, 0, color.RGBA{255, 0, 0, 255}},
+		{8, 0, color.RGBA{0, 0, 0, 255}},
+		{11, 7, color.RGBA{255, 0, 0, 255}},
+	} {
+		if col := color.RGBAModel.Convert(img.At(test.x, test.y)); col != test.col {
+			t.Errorf("pixel %d,%d is %v, want %v", test.x, test.y, col, test.col)
+		}
+	}
+
+	for _, scale := range []string{"0", "65", "big"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/motion/heatmap.png?scale="+scale, nil))
+		if rec.Code != 400 {
+			t.Errorf("scale %s served %d", scale, rec.Code)
+		}
+	}
+
+	// another grid starts over
+	h.Add([]bool{true, true}, 2, 1)
+	if frames, c := counts(); frames != 1 || !reflect.DeepEqual(c, []int{1, 1}) {
+		t.Errorf("%d frames counted %v after the grid changed", frames, c)
+	}
+	h.Reset()
+	if frames, c := counts(); frames != 0 || !reflect.DeepEqual(c, []int{0, 0}) {
+		t.Errorf("%d frames counted %v after a reset", frames, c)
+	}
+}
+
+func TestMotionEventRing(t *testing.T) {
+	start := time.Date(2018, 11, 18, 21, 4, 5, 0, time.UTC)
+	m := newMotionMonitor(make(chan bool, 1))
+	vect := make([]motionVector, 4)
+	active := []bool{true, false, false, false}
+
+	if ev := m.LastEvent(); ev != nil {
+		t.Errorf("last event %+v before any motion", ev)
+	}
+
+	// frames without motion aren't events
+	m.Observed(start, vect, active, 2, 2, 0, "")
+	if n := len(m.Events()); n != 0 {
+		t.Errorf("%d events without motion", n)
+	}
+
+	frame := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+	for i := 0; i < motionEventCount+50; i++ {
+		m.Observed(frame(i), vect, active, 2, 2, 1, "door")
+	}
+
+	events := m.Events()
+	if len(events) != motionEventCount {
+		t.Fatalf("kept %d events, want %d", len(events), motionEventCount)
+	}
+	// the oldest are dropped
+	if first := events[0].Time; !first.Equal(frame(50)) {
+		t.Errorf("oldest event at %v, want %v", first, frame(50))
+	}
+	for i := 1; i < len(events); i++ {
+		if !events[i].Time.After(events[i-1].Time) {
+			t.Errorf("event %d at %v isn't after %v", i, events[i].Time, events[i-1].Time)
+		}
+	}
+	last := m.LastEvent()
+	if last == nil || !last.Time.Equal(frame(motionEventCount+49)) || last.Region != "door" {
+		t.Errorf("last event %+v", last)
+	}
+
+	// what's returned is a copy
+	events[0].Region = "changed"
+	if m.Events()[0].Region != "door" {
+		t.Errorf("changing the returned events changed the monitor's")
+	}
+}
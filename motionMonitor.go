@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// motionMonitor holds the motion detection settings served at the motion
+// path and persisted with the mirror, the motion processor reads them for
+// every frame and reports what it saw back.  Events aren't persisted and
+// are signalled on their own channel without blocking the processor.
+type motionMonitor struct {
+	regions       []motionRegion
+	mask          *motionMask
+	baseline      *motionBaseline
+	heatmap       *motionHeatmap
+	events        []motionEvent
+	tracker       motionTracker
+	wakeOn        string
+	lingerTime    time.Duration
+	lock          *sync.Mutex
+	changed       chan bool
+	eventsChanged chan bool
+}
+
+func newMotionMonitor(changed chan bool) *motionMonitor {
+	return &motionMonitor{
+		baseline:      newMotionBaseline(),
+		heatmap:       newMotionHeatmap(),
+		wakeOn:        defaultWakeOn,
+		lingerTime:    defaultLingerTime,
+		lock:          &sync.Mutex{},
+		changed:       changed,
+		eventsChanged: make(chan bool, 1),
+	}
+}
+
+// Observed is called by the motion processor for every frame it looks at,
+// region is empty unless motion was detected.  It returns true if the
+// motion should wake the display, which with wakeOn approach is only once
+// the tracked blob is approaching or lingering.
+func (m *motionMonitor) Observed(t time.Time, vect []motionVector, active []bool, width, height int, score float64, region string) bool {
+	m.heatmap.Add(active, width, height)
+	mask := m.Mask(width, height)
+
+	m.lock.Lock()
+	class := m.tracker.Observe(t, active, mask, m.lingerTime)
+	wake := region != "" && wakes(m.wakeOn, class)
+	m.lock.Unlock()
+
+	if region == "" {
+		return false
+	}
+
+	ev := newMotionEvent(t, vect, active, width, score, region)
+	ev.Class = class
+	ev.Wake = wake
+	m.lock.Lock()
+	m.events = append(m.events, ev)
+	if len(m.events) > motionEventCount {
+		m.events = m.events[len(m.events)-motionEventCount:]
+	}
+	m.lock.Unlock()
+
+	select {
+	case m.eventsChanged <- true:
+	default:
+	}
+	return wake
+}
+
+// Track returns the blob being followed or nil if there isn't one
+func (m *motionMonitor) Track() *motionTrack {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.tracker.track == nil {
+		return nil
+	}
+	t := *m.tracker.track
+	t.points = append([]motionBlob{}, t.points...)
+	return &t
+}
+
+// SetWakePolicy sets whether any motion wakes the display or only someone
+// approaching or lingering for lingerTime
+func (m *motionMonitor) SetWakePolicy(wakeOn string, lingerTime time.Duration) error {
+	if err := validWakeOn(wakeOn); err != nil {
+		return err
+	} else if lingerTime <= 0 {
+		return fmt.Errorf("motion lingerTime must be positive")
+	}
+
+	m.lock.Lock()
+	modified := m.wakeOn != wakeOn || m.lingerTime != lingerTime
+	m.wakeOn = wakeOn
+	m.lingerTime = lingerTime
+	m.lock.Unlock()
+
+	if modified {
+		m.changed <- true
+	}
+	return nil
+}
+
+// Events returns the recent motion events, oldest first
+func (m *motionMonitor) Events() []motionEvent {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]motionEvent{}, m.events...)
+}
+
+// LastEvent returns the latest motion event or nil if there hasn't been one
+func (m *motionMonitor) LastEvent() *motionEvent {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.events) == 0 {
+		return nil
+	}
+	ev := m.events[len(m.events)-1]
+	return &ev
+}
+
+// Heatmap returns the accumulated counts of active blocks
+func (m *motionMonitor) Heatmap() *motionHeatmap {
+	return m.heatmap
+}
+
+// Mask returns the regions applied to a width by height macroblock grid
+func (m *motionMonitor) Mask(width, height int) *motionMask {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.mask == nil || m.mask.width != width || m.mask.height != height {
+		m.mask = newMotionMask(m.regions, width, height)
+	}
+	return m.mask
+}
+
+// Baseline returns the learned background motion
+func (m *motionMonitor) Baseline() *motionBaseline {
+	return m.baseline
+}
+
+func (m *motionMonitor) Regions() []motionRegion {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]motionRegion{}, m.regions...)
+}
+
+// SetRegions replaces every region, names must be unique
+func (m *motionMonitor) SetRegions(regions []motionRegion) error {
+	names := make(map[string]bool)
+	for _, r := range regions {
+		if names[r.Name] {
+			return fmt.Errorf("motion region %s is defined twice", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	m.lock.Lock()
+	m.regions = regions
+	m.mask = nil
+	m.lock.Unlock()
+
+	m.changed <- true
+	return nil
+}
+
+// setRegion adds or replaces the region with r's name, or removes it if r
+// is nil
+func (m *motionMonitor) setRegion(name string, r *motionRegion) error {
+	regions := []motionRegion{}
+	found := false
+	for _, old := range m.Regions() {
+		if old.Name != name {
+			regions = append(regions, old)
+		} else if r != nil {
+			regions = append(regions, *r)
+			found = true
+		}
+	}
+	if r != nil && !found {
+		regions = append(regions, *r)
+	}
+	return m.SetRegions(regions)
+}
+
+func (m *motionMonitor) region(name string) (motionRegion, bool) {
+	for _, r := range m.Regions() {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return motionRegion{}, false
+}
+
+func (m *motionMonitor) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) > 0 && path[0] == "regions" {
+		return m.serveRegions(path[1:], msg)
+	} else if len(path) > 0 && path[0] == "baseline" {
+		return m.serveBaseline(path[1:], msg)
+	} else if len(path) > 0 && path[0] == "events" {
+		if msg != nil {
+			return nil, &NotFoundError{Path: path}
+		}
+		return serveValuePath(m.Events(), path[1:])
+	} else if len(path) > 0 && path[0] == "track" {
+		if msg != nil {
+			return nil, &NotFoundError{Path: path}
+		}
+		return serveValuePath(m.Track(), path[1:])
+	} else if len(path) == 1 && msg != nil && (path[0] == "wakeOn" || path[0] == "lingerTime") {
+		// the wake policy can be set a field at a time by wrapping the
+		// message in its key
+		b, err := json.Marshal(map[string]*json.RawMessage{path[0]: msg})
+		if err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(b, m); err != nil {
+			return nil, err
+		}
+		return serveValuePath(m, path)
+	} else if len(path) > 0 && path[0] == "event" {
+		// the latest event, as pushed on the websocket
+		if msg != nil {
+			return nil, &NotFoundError{Path: path}
+		}
+		return serveValuePath(m.LastEvent(), path[1:])
+	} else if len(path) > 0 && path[0] == "heatmap" {
+		// posting null to the heatmap starts counting again
+		if msg != nil {
+			if len(path) > 1 || string(*msg) != "null" {
+				return nil, &NotFoundError{Path: path}
+			}
+			m.heatmap.Reset()
+		}
+		return serveValuePath(m.heatmap, path[1:])
+	}
+
+	if msg != nil {
+		if len(path) > 0 {
+			return nil, &NotFoundError{Path: path}
+		}
+		if err := json.Unmarshal(*msg, m); err != nil {
+			return nil, err
+		}
+	}
+	return serveValuePath(m, path)
+}
+
+// serveRegions serves the list of regions and each region by name.  Posting
+// a list replaces every region, posting an object to regions/<name> adds the
+// region or updates its fields and posting null removes it.  A PGM or PNG
+// posted to regions/<name>/bitmap, raw or as a base64 string, becomes the
+// region's shape.
+func (m *motionMonitor) serveRegions(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			var regions []motionRegion
+			if err := json.Unmarshal(*msg, &regions); err != nil {
+				return nil, err
+			} else if err := m.SetRegions(regions); err != nil {
+				return nil, err
+			}
+		}
+		return serveValuePath(m.Regions(), nil)
+	}
+
+	name := path[0]
+	switch {
+	case msg != nil && len(path) == 2 && path[1] == "bitmap":
+		data := []byte(*msg)
+		if bytes.HasPrefix(data, []byte(`"`)) {
+			var s string
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, err
+			}
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(s); err != nil {
+				return nil, fmt.Errorf("motion region %s bitmap must be base64: %v", name, err)
+			}
+		}
+
+		r, ok := m.region(name)
+		if !ok {
+			r = motionRegion{Name: name, Mode: regionInclude, Weight: 1}
+		}
+		if err := r.setBitmap(data); err != nil {
+			return nil, err
+		} else if err := m.setRegion(name, &r); err != nil {
+			return nil, err
+		}
+		path = path[:1]
+	case msg != nil && len(path) == 1:
+		if string(*msg) == "null" {
+			if err := m.setRegion(name, nil); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+
+		// the posted fields are merged into an existing region and the name
+		// in the path wins over any in the body
+		v := make(map[string]*json.RawMessage)
+		if old, ok := m.region(name); ok {
+			b, err := json.Marshal(old)
+			if err != nil {
+				return nil, err
+			} else if err := json.Unmarshal(b, &v); err != nil {
+				return nil, err
+			}
+		}
+		posted := make(map[string]*json.RawMessage)
+		if err := json.Unmarshal(*msg, &posted); err != nil {
+			return nil, err
+		}
+		if posted["rect"] != nil {
+			delete(v, "bitmap")
+		} else if posted["bitmap"] != nil {
+			delete(v, "rect")
+		}
+		for key, value := range posted {
+			v[key] = value
+		}
+		n, _ := json.Marshal(name)
+		v["name"] = (*json.RawMessage)(&n)
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var r motionRegion
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, err
+		} else if err := m.setRegion(name, &r); err != nil {
+			return nil, err
+		}
+	case msg != nil:
+		return nil, &NotFoundError{Path: path}
+	}
+
+	r, ok := m.region(name)
+	if !ok {
+		return nil, &NotFoundError{Path: path}
+	}
+	return serveValuePath(r, path[1:])
+}
+
+// serveBaseline serves the learned model.  Posting a duration like "60s" to
+// baseline/calibrate relearns the background from scratch for that long,
+// adaptive and sigma can be posted to baseline or individually.
+func (m *motionMonitor) serveBaseline(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		switch {
+		case len(path) == 1 && path[0] == "calibrate":
+			var s string
+			if err := json.Unmarshal(*msg, &s); err != nil {
+				return nil, fmt.Errorf("motion calibration must be a duration string")
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, err
+			} else if err := m.baseline.Calibrate(d); err != nil {
+				return nil, err
+			}
+			path = nil
+		case len(path) == 1 && (path[0] == "adaptive" || path[0] == "sigma"):
+			b, err := json.Marshal(map[string]*json.RawMessage{path[0]: msg})
+			if err != nil {
+				return nil, err
+			} else if err := json.Unmarshal(b, m.baseline); err != nil {
+				return nil, err
+			}
+		case len(path) == 0:
+			if err := json.Unmarshal(*msg, m.baseline); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &NotFoundError{Path: path}
+		}
+		m.changed <- true
+	}
+	return serveValuePath(m.baseline, path)
+}
+
+func (m *motionMonitor) MarshalJSON() ([]byte, error) {
+	m.lock.Lock()
+	wakeOn, lingerTime := m.wakeOn, m.lingerTime
+	m.lock.Unlock()
+
+	return json.Marshal(map[string]interface{}{
+		"regions":    m.Regions(),
+		"baseline":   m.baseline.Summary(),
+		"wakeOn":     wakeOn,
+		"lingerTime": lingerTime.String(),
+	})
+}
+
+func (m *motionMonitor) UnmarshalJSON(b []byte) error {
+	v := make(map[string]*json.RawMessage)
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	wakeOn, lingerTime := m.wakeOn, m.lingerTime
+	m.lock.Unlock()
+
+	if w := v["wakeOn"]; w != nil {
+		if err := json.Unmarshal(*w, &wakeOn); err != nil {
+			return fmt.Errorf("motion wakeOn must be a string")
+		}
+	}
+	if l := v["lingerTime"]; l != nil {
+		var s string
+		if err := json.Unmarshal(*l, &s); err != nil {
+			return fmt.Errorf("motion lingerTime must be a duration string")
+		}
+		var err error
+		if lingerTime, err = time.ParseDuration(s); err != nil {
+			return err
+		}
+	}
+	if err := m.SetWakePolicy(wakeOn, lingerTime); err != nil {
+		return err
+	}
+
+	if b := v["baseline"]; b != nil {
+		if err := json.Unmarshal(*b, m.baseline); err != nil {
+			return err
+		} else if v["regions"] == nil {
+			m.changed <- true
+		}
+	}
+
+	if r := v["regions"]; r != nil {
+		var regions []motionRegion
+		if err := json.Unmarshal(*r, &regions); err != nil {
+			return err
+		}
+		return m.SetRegions(regions)
+	}
+	return nil
+}
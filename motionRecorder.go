@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// motionRecorder copies the motion vectors read through it into files in
+// dir named for the time they were started and the macroblock grid, like
+// motion-20181118-210405-121x68.bin.  A new file is started every rotate on
+// a frame boundary so every file can be replayed on its own.
+type motionRecorder struct {
+	reader    io.Reader
+	dir       string
+	grid      string
+	frameSize int
+	rotate    time.Duration
+	file      *os.File
+	opened    time.Time
+	written   int
+}
+
+// newMotionRecorder records frames of width by height macroblocks read from
+// r
+func newMotionRecorder(r io.Reader, dir string, width, height int, rotate time.Duration) *motionRecorder {
+	return &motionRecorder{
+		reader:    r,
+		dir:       dir,
+		grid:      fmt.Sprintf("%dx%d", width, height),
+		frameSize: width * height * 4,
+		rotate:    rotate,
+	}
+}
+
+func (m *motionRecorder) Read(p []byte) (int, error) {
+	n, err := m.reader.Read(p)
+	if n > 0 && m.dir != "" {
+		if werr := m.record(p[:n]); werr != nil {
+			// motion detection carries on without the recording
+			log.Printf("stopping motion recording: %v", werr)
+			m.Close()
+			m.dir = ""
+		}
+	}
+	if err == io.EOF {
+		m.Close()
+	}
+	return n, err
+}
+
+// record writes b, splitting it at a frame boundary if the file is due to
+// rotate
+func (m *motionRecorder) record(b []byte) error {
+	for len(b) > 0 {
+		if m.written%m.frameSize == 0 && (m.file == nil || (m.rotate > 0 && time.Since(m.opened) >= m.rotate)) {
+			if err := m.open(); err != nil {
+				return err
+			}
+		}
+
+		chunk := b
+		if rest := m.frameSize - m.written%m.frameSize; len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		if _, err := m.file.Write(chunk); err != nil {
+			return err
+		}
+		m.written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return nil
+}
+
+func (m *motionRecorder) open() error {
+	m.Close()
+
+	m.opened = time.Now()
+	name := filepath.Join(m.dir, fmt.Sprintf("motion-%s-%s.bin", m.opened.Format("20060102-150405"), m.grid))
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	log.Printf("recording motion vectors to %s", name)
+	m.file = f
+	m.written = 0
+	return nil
+}
+
+func (m *motionRecorder) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// motionReplay reads a recording back a frame at a time, paced at fps times
+// speed frames a second or as fast as possible if speed is 0.  Its clock
+// runs at the recording's pace however fast it is read, so detection
+// throttling sees the same frames at any speed.
+type motionReplay struct {
+	reader    io.Reader
+	frameSize int
+	interval  time.Duration
+	speed     float64
+	start     time.Time
+	began     time.Time
+	read      int
+}
+
+func newMotionReplay(r io.Reader, width, height int, fps float64, speed float64) *motionReplay {
+	return &motionReplay{
+		reader:    r,
+		frameSize: width * height * 4,
+		interval:  time.Duration(float64(time.Second) / fps),
+		speed:     speed,
+		start:     time.Now(),
+	}
+}
+
+func (m *motionReplay) Read(p []byte) (int, error) {
+	if m.began.IsZero() {
+		m.began = time.Now()
+	}
+
+	frame := m.read / m.frameSize
+	if m.read%m.frameSize == 0 && m.speed > 0 {
+		due := m.began.Add(time.Duration(float64(frame) * float64(m.interval) / m.speed))
+		time.Sleep(time.Until(due))
+	}
+
+	// never read past the current frame so each one is paced
+	if rest := m.frameSize - m.read%m.frameSize; len(p) > rest {
+		p = p[:rest]
+	}
+	n, err := m.reader.Read(p)
+	m.read += n
+	return n, err
+}
+
+// Now is the time in the recording of the frame last read
+func (m *motionReplay) Now() time.Time {
+	return m.start.Add(time.Duration(m.read/m.frameSize) * m.interval)
+}
+
+// openMotionSource opens the motion fifo or, for replay:<file>, a recording.
+// It returns the clock the motion processor should use, nil for the wall
+// clock.
+func openMotionSource(spec string, width, height int, fps float64, speed float64) (io.Reader, func() time.Time, error) {
+	if !strings.HasPrefix(spec, "replay:") {
+		f, err := os.OpenFile(spec, os.O_RDONLY, 0600)
+		return f, nil, err
+	}
+
+	if fps <= 0 || speed < 0 {
+		return nil, nil, fmt.Errorf("motion replay needs a positive frame rate and a speed of at least 0")
+	}
+	name := strings.TrimPrefix(spec, "replay:")
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size()%int64(width*height*4) != 0 {
+		log.Printf("%s is not a whole number of %dx%d frames, was it recorded with other -mbx and -mby?", name, width, height)
+	}
+	log.Printf("replaying motion vectors from %s at %gx", name, speed)
+	r := newMotionReplay(f, width, height, fps, speed)
+	return r, r.Now, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+var (
+	updateGolden     = flag.Bool("update", false, "rewrite the motion replay golden files")
+	generateCaptures = flag.Bool("generate", false, "rewrite the synthesized motion captures")
+)
+
+// the captures in testdata/motion are 10 frames a second on a 17x12 grid
+const (
+	captureFps    = 10
+	captureWidth  = 17
+	captureHeight = 12
+)
+
+// synthesizedCaptures draws the frames of each capture in testdata/motion
+var synthesizedCaptures = map[string]func(w io.Writer){
+	"still-17x12": func(w io.Writer) {
+		noise := uint32(2)
+		for f := 0; f < 60; f++ {
+			synthesizeFrame(w, &noise, nil)
+		}
+	},
+	"approach-17x12": func(w io.Writer) {
+		noise := uint32(1)
+		for f := 0; f < 70; f++ {
+			k := f - 10
+			if k < 0 || k >= 50 {
+				synthesizeFrame(w, &noise, nil)
+				continue
+			}
+			// growing from 2x2 to 7x10 in the middle, the sides of someone
+			// walking towards the camera move apart
+			width, height := 2+k*5/49, 2+k*8/49
+			synthesizeFrame(w, &noise, captureRect(8-width/2, 6-height/2, width, height, func(x, y int) motionVector {
+				v := motionVector{X: -15, Y: -4}
+				if x >= 8 {
+					v.X = 15
+				}
+				if y >= 6 {
+					v.Y = 4
+				}
+				return v
+			}))
+		}
+	},
+	"passby-17x12": func(w io.Writer) {
+		noise := uint32(3)
+		for f := 0; f < 70; f++ {
+			// 3 blocks wide and 6 high walking in from the left at 4
+			// blocks a second, the vectors point back to the left
+			k := f - 5
+			if x := -3 + k*4/captureFps; k >= 0 && x < captureWidth {
+				synthesizeFrame(w, &noise, captureRect(x, 4, 3, 6, func(x, y int) motionVector {
+					return motionVector{X: -20}
+				}))
+			} else {
+				synthesizeFrame(w, &noise, nil)
+			}
+		}
+	},
+}
+
+// synthesizeFrame writes a frame of noise drawn from the linear congruential
+// generator noise, with a fan flickering in the top right block and blocks
+// moving as given
+func synthesizeFrame(w io.Writer, noise *uint32, blocks map[image.Point]motionVector) {
+	for y := 0; y < captureHeight; y++ {
+		for x := 0; x < captureWidth; x++ {
+			*noise = (*noise*1103515245 + 12345) & 0x7fffffff
+			r := *noise
+			v := motionVector{X: int8(r%5) - 2, Y: int8((r>>8)%5) - 2, Sad: int16((r >> 16) % 200)}
+			if x == captureWidth-1 && y == 0 {
+				v.X, v.Y = -12, 0
+				if (r>>4)&1 == 1 {
+					v.X = 12
+				}
+			}
+			if b, ok := blocks[image.Pt(x, y)]; ok {
+				v.X, v.Y = b.X, b.Y
+				v.Sad += 400
+			}
+			binary.Write(w, binary.LittleEndian, v)
+		}
+	}
+}
+
+// captureRect is the blocks of a rectangle inside the grid, moving by v
+func captureRect(x0, y0, width, height int, v func(x, y int) motionVector) map[image.Point]motionVector {
+	blocks := make(map[image.Point]motionVector)
+	for y := y0; y < y0+height; y++ {
+		for x := x0; x < x0+width; x++ {
+			if x >= 0 && x < captureWidth && y >= 0 && y < captureHeight {
+				blocks[image.Pt(x, y)] = v(x, y)
+			}
+		}
+	}
+	return blocks
+}
+
+func TestSynthesizedCaptures(t *testing.T) {
+	for name, synthesize := range synthesizedCaptures {
+		var b bytes.Buffer
+		synthesize(&b)
+
+		capture := filepath.Join("testdata", "motion", name+".bin")
+		if *generateCaptures {
+			if err := ioutil.WriteFile(capture, b.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(capture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), want) {
+			t.Errorf("%s isn't what synthesizing it draws, run the test with -generate if that's intended", capture)
+		}
+	}
+}
+
+// replayResult is what a capture's golden file holds
+type replayResult struct {
+	Detections []time.Time   `json:"detections"`
+	Events     []motionEvent `json:"events"`
+}
+
+// replayCapture runs testdata/motion/<name>.bin through the motion processor
+// as fast as it can be read, waking only on approach.  The name ends in the
+// grid, e.g. approach-17x12.
+func replayCapture(t *testing.T, name string) replayResult {
+	var width, height int
+	if _, err := fmt.Sscanf(name[strings.LastIndex(name, "-")+1:], "%dx%d", &width, &height); err != nil {
+		t.Fatalf("capture %s has no grid: %v", name, err)
+	}
+
+	f, err := os.Open(filepath.Join("testdata", "motion", name+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	replay := newMotionReplay(f, width, height, captureFps, 0)
+	replay.start = time.Date(2018, 11, 18, 21, 4, 5, 0, time.UTC)
+
+	monitor := newMotionMonitor(make(chan bool, 1))
+	if err := monitor.SetWakePolicy(wakeOnApproach, defaultLingerTime); err != nil {
+		t.Fatal(err)
+	}
+
+	detected := MotionProcessor{
+		mbx:       width - 1,
+		mby:       height,
+		magnitude: 10,
+		total:     10,
+		throttle:  500 * time.Millisecond,
+		monitor:   monitor,
+		clock:     replay.Now,
+	}.Process(replay)
+
+	ret := replayResult{Detections: []time.Time{}}
+	for d := range detected {
+		ret.Detections = append(ret.Detections, d)
+	}
+	// detections are sent concurrently
+	sort.Slice(ret.Detections, func(i, j int) bool { return ret.Detections[i].Before(ret.Detections[j]) })
+	ret.Events = monitor.Events()
+	return ret
+}
+
+func TestMotionReplayGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		events bool
+		wakes  bool
+	}{
+		// a fan flickers in the top right corner of every capture
+		{"still-17x12", false, false},
+		{"approach-17x12", true, true},
+		// entering from the edge grows as fast as approaching
+		{"passby-17x12", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := replayCapture(t, test.name)
+
+			wakes := false
+			for _, ev := range res.Events {
+				wakes = wakes || ev.Wake
+			}
+			if (len(res.Events) > 0) != test.events {
+				t.Errorf("%d events", len(res.Events))
+			}
+			if wakes != test.wakes || len(res.Detections) > 0 != test.wakes {
+				t.Errorf("woke %v with %d detections, want %v", wakes, len(res.Detections), test.wakes)
+			}
+
+			got, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "motion", test.name+".golden.json")
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(append(got, '\n'), want) {
+				t.Errorf("replay differs from %s, run the test with -update if that's intended:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestMotionRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "motion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// three frames of a 2x2 grid, read a byte at a time
+	data := make([]byte, 3*2*2*4)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	rec := newMotionRecorder(iotest.OneByteReader(bytes.NewReader(data)), dir, 2, 2, 0)
+	read, err := ioutil.ReadAll(rec)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, data) {
+		t.Errorf("the recorder changed what was read")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "motion-*-2x2.bin"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("recorded %v: %v", files, err)
+	}
+	recorded, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(recorded, data) {
+		t.Errorf("recorded %d bytes, want the %d read", len(recorded), len(data))
+	}
+
+	// a replay's clock follows the frames read, not how long they took
+	replay := newMotionReplay(bytes.NewReader(recorded), 2, 2, captureFps, 0)
+	start := replay.Now()
+	if _, err := ioutil.ReadAll(replay); err != nil {
+		t.Fatal(err)
+	}
+	if d := replay.Now().Sub(start); d != 300*time.Millisecond {
+		t.Errorf("replaying three frames took %v on its clock", d)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"io"
+	"strconv"
+)
+
+// region modes, motion in an excluded region is ignored.  When there are
+// include regions only motion inside them counts, otherwise the whole frame
+// does.
+const (
+	regionInclude = "include"
+	regionExclude = "exclude"
+)
+
+// motionRect is a rectangle of macroblocks
+type motionRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// motionRegion is a rectangle or a bitmap of the macroblock grid.  A bitmap
+// is a PGM or PNG, base64 in json, stretched over the grid where light
+// pixels are inside the region.  Active blocks in an include region count
+// Weight each towards the total, and a region with a Threshold detects
+// motion by itself once its weighted count reaches it.
+type motionRegion struct {
+	Name      string      `json:"name"`
+	Mode      string      `json:"mode"`
+	Rect      *motionRect `json:"rect,omitempty"`
+	Bitmap    string      `json:"bitmap,omitempty"`
+	Weight    float64     `json:"weight"`
+	Threshold float64     `json:"threshold"`
+	bitmap    image.Image
+}
+
+func (r *motionRegion) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Name      string      `json:"name"`
+		Mode      string      `json:"mode"`
+		Rect      *motionRect `json:"rect"`
+		Bitmap    string      `json:"bitmap"`
+		Weight    *float64    `json:"weight"`
+		Threshold float64     `json:"threshold"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	ret := motionRegion{
+		Name:      v.Name,
+		Mode:      v.Mode,
+		Rect:      v.Rect,
+		Bitmap:    v.Bitmap,
+		Weight:    1,
+		Threshold: v.Threshold,
+	}
+	if v.Weight != nil {
+		ret.Weight = *v.Weight
+	}
+	if ret.Mode == "" {
+		ret.Mode = regionInclude
+	}
+
+	if ret.Bitmap != "" {
+		data, err := base64.StdEncoding.DecodeString(ret.Bitmap)
+		if err != nil {
+			return fmt.Errorf("motion region %s bitmap must be base64: %v", ret.Name, err)
+		}
+		if ret.bitmap, err = decodeRegionBitmap(ret.Name, data); err != nil {
+			return err
+		}
+	}
+
+	if err := ret.validate(); err != nil {
+		return err
+	}
+	*r = ret
+	return nil
+}
+
+func (r *motionRegion) validate() error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("motion region needs a name")
+	case r.Mode != regionInclude && r.Mode != regionExclude:
+		return fmt.Errorf("motion region %s mode must be include or exclude", r.Name)
+	case (r.Rect == nil) == (r.bitmap == nil):
+		return fmt.Errorf("motion region %s needs either a rect or a bitmap", r.Name)
+	case r.Rect != nil && (r.Rect.Width <= 0 || r.Rect.Height <= 0):
+		return fmt.Errorf("motion region %s rect must have a positive width and height", r.Name)
+	case r.Weight < 0 || r.Threshold < 0:
+		return fmt.Errorf("motion region %s weight and threshold must not be negative", r.Name)
+	}
+	return nil
+}
+
+// setBitmap replaces the region's shape with an encoded PGM or PNG
+func (r *motionRegion) setBitmap(data []byte) error {
+	img, err := decodeRegionBitmap(r.Name, data)
+	if err != nil {
+		return err
+	}
+	r.Rect = nil
+	r.bitmap = img
+	r.Bitmap = base64.StdEncoding.EncodeToString(data)
+	return nil
+}
+
+// maxRegionBitmap is the largest side of a region bitmap, decoding allocates
+// the whole image the header asks for
+const maxRegionBitmap = 4096
+
+// decodeRegionBitmap decodes the PGM or PNG data of the region name once its
+// header shows it isn't too large
+func decodeRegionBitmap(name string, data []byte) (image.Image, error) {
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("motion region %s bitmap must be a PGM or PNG: %v", name, err)
+	} else if conf.Width > maxRegionBitmap || conf.Height > maxRegionBitmap {
+		return nil, fmt.Errorf("motion region %s bitmap must be at most %dx%d", name, maxRegionBitmap, maxRegionBitmap)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("motion region %s bitmap must be a PGM or PNG: %v", name, err)
+	}
+	return img, nil
+}
+
+// contains returns true if the macroblock at x, y of a width by height grid
+// is in the region
+func (r *motionRegion) contains(x, y, width, height int) bool {
+	if r.Rect != nil {
+		return x >= r.Rect.X && x < r.Rect.X+r.Rect.Width &&
+			y >= r.Rect.Y && y < r.Rect.Y+r.Rect.Height
+	}
+
+	// sample the bitmap at the centre of the block
+	b := r.bitmap.Bounds()
+	px := b.Min.X + (2*x+1)*b.Dx()/(2*width)
+	py := b.Min.Y + (2*y+1)*b.Dy()/(2*height)
+	return color.GrayModel.Convert(r.bitmap.At(px, py)).(color.Gray).Y >= 128
+}
+
+// motionMask is the regions applied to a grid, built once for the motion
+// processor rather than every frame
+type motionMask struct {
+	width   int
+	height  int
+	weights []float64
+	regions []maskRegion
+}
+
+// maskRegion is an include region with a threshold of its own
+type maskRegion struct {
+	name      string
+	blocks    []int
+	threshold float64
+}
+
+func newMotionMask(regions []motionRegion, width, height int) *motionMask {
+	m := &motionMask{
+		width:   width,
+		height:  height,
+		weights: make([]float64, width*height),
+	}
+
+	include := false
+	for _, r := range regions {
+		include = include || r.Mode == regionInclude
+	}
+	if !include {
+		for i := range m.weights {
+			m.weights[i] = 1
+		}
+	}
+
+	excluded := make([]bool, width*height)
+	for _, r := range regions {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if !r.contains(x, y, width, height) {
+					continue
+				}
+				if r.Mode == regionExclude {
+					excluded[y*width+x] = true
+				} else {
+					m.weights[y*width+x] = r.Weight
+				}
+			}
+		}
+	}
+
+	for _, r := range regions {
+		if r.Mode != regionInclude || r.Threshold <= 0 {
+			continue
+		}
+		mr := maskRegion{name: r.Name, threshold: r.Threshold}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if i := y*width + x; !excluded[i] && r.contains(x, y, width, height) {
+					mr.blocks = append(mr.blocks, i)
+				}
+			}
+		}
+		m.regions = append(m.regions, mr)
+	}
+
+	for i, e := range excluded {
+		if e {
+			m.weights[i] = 0
+		}
+	}
+	return m
+}
+
+// detect returns the weighted count of active blocks and the name of the
+// region that detected motion, total if the whole count exceeded total or
+// empty if there was no motion
+func (m *motionMask) detect(active []bool, total float64) (float64, string) {
+	score := 0.
+	for i, a := range active {
+		if a {
+			score += m.weights[i]
+		}
+	}
+
+	for _, r := range m.regions {
+		c := 0.
+		for _, i := range r.blocks {
+			if active[i] {
+				c += m.weights[i]
+			}
+		}
+		if c >= r.threshold {
+			return score, r.name
+		}
+	}
+
+	if score > total {
+		return score, "total"
+	}
+	return score, ""
+}
+
+func init() {
+	image.RegisterFormat("pgm", "P5", decodePGM, decodePGMConfig)
+	image.RegisterFormat("pgm", "P2", decodePGM, decodePGMConfig)
+}
+
+// readPGMHeader reads the magic number, size and maximum value of a PGM
+func readPGMHeader(br *bufio.Reader) (magic string, width, height, max int, err error) {
+	fields := make([]string, 0, 4)
+	for len(fields) < 4 {
+		var tok []byte
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				return "", 0, 0, 0, err
+			}
+			if c == '#' && len(tok) == 0 {
+				// comments run to the end of the line
+				if _, err := br.ReadString('\n'); err != nil {
+					return "", 0, 0, 0, err
+				}
+				continue
+			}
+			if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				if len(tok) > 0 {
+					break
+				}
+				continue
+			}
+			tok = append(tok, c)
+		}
+		fields = append(fields, string(tok))
+	}
+
+	magic = fields[0]
+	if magic != "P5" && magic != "P2" {
+		return "", 0, 0, 0, fmt.Errorf("not a PGM")
+	}
+	var nums [3]int
+	for i, f := range fields[1:] {
+		if nums[i], err = strconv.Atoi(f); err != nil || nums[i] <= 0 {
+			return "", 0, 0, 0, fmt.Errorf("bad PGM header")
+		}
+	}
+	if nums[2] > 65535 {
+		return "", 0, 0, 0, fmt.Errorf("bad PGM maximum value")
+	}
+	return magic, nums[0], nums[1], nums[2], nil
+}
+
+func decodePGMConfig(r io.Reader) (image.Config, error) {
+	_, w, h, _, err := readPGMHeader(bufio.NewReader(r))
+	return image.Config{ColorModel: color.GrayModel, Width: w, Height: h}, err
+}
+
+// decodePGM decodes binary (P5) and plain (P2) PGM images
+func decodePGM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	magic, w, h, max, err := readPGMHeader(br)
+	if err != nil {
+		return nil, err
+	} else if w > maxRegionBitmap || h > maxRegionBitmap {
+		return nil, fmt.Errorf("PGM is larger than %dx%d", maxRegionBitmap, maxRegionBitmap)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		var v int
+		switch {
+		case magic == "P2":
+			if _, err := fmt.Fscan(br, &v); err != nil {
+				return nil, err
+			}
+		case max < 256:
+			c, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			v = int(c)
+		default:
+			var b [2]byte
+			if _, err := io.ReadFull(br, b[:]); err != nil {
+				return nil, err
+			}
+			v = int(b[0])<<8 | int(b[1])
+		}
+		if v > max {
+			v = max
+		}
+		img.Pix[i] = uint8(v * 255 / max)
+	}
+	return img, nil
+}
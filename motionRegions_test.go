@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRegionBitmap(t *testing.T) {
+	// the right half of a 4x2 grid
+	pgm := "P2\n# right half\n4 2\n255\n0 0 255 255\n0 0 255 255\n"
+	var r motionRegion
+	if err := json.Unmarshal([]byte(`{"name":"door","bitmap":"`+base64.StdEncoding.EncodeToString([]byte(pgm))+`"}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 8; x++ {
+		if in := r.contains(x, 3, 8, 4); in != (x >= 4) {
+			t.Errorf("block %d inside the region is %v", x, in)
+		}
+	}
+}
+
+func TestRegionBitmapTooLarge(t *testing.T) {
+	var large bytes.Buffer
+	if err := png.Encode(&large, image.NewGray(image.Rect(0, 0, maxRegionBitmap+1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		// only the header, the pixels would be 400MB
+		"pgm": []byte("P5 20000 20000 255\n"),
+		"png": large.Bytes(),
+	} {
+		r := motionRegion{Name: name}
+		err := r.setBitmap(data)
+		if err == nil || !strings.Contains(err.Error(), "at most") {
+			t.Errorf("%s larger than %d accepted: %v", name, maxRegionBitmap, err)
+		}
+	}
+
+	if _, _, err := image.Decode(strings.NewReader("P5 20000 20000 255\n")); err == nil {
+		t.Errorf("decoded a PGM larger than %d", maxRegionBitmap)
+	}
+}
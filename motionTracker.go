@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// motion classifications, a track is undecided until it fits one
+const (
+	motionApproaching = "approaching"
+	motionPassing     = "passing"
+	motionLingering   = "lingering"
+)
+
+// wake policies, any motion or only someone approaching or lingering
+const (
+	wakeOnAny      = "any"
+	wakeOnApproach = "approach"
+)
+
+const (
+	// blobs smaller than this many blocks are noise
+	minBlobBlocks = 2
+	// a track ends after this long without a blob
+	trackGap = 1500 * time.Millisecond
+	// a blob further than this fraction of the frame from the last one
+	// starts a new track
+	trackJump = 1. / 3
+	// moving this fraction of the frame across is passing
+	passingTravel = 1. / 4
+	// growing this much is approaching the camera
+	approachGrowth = 1.5
+	// a track has to last this long to be approaching, someone walking in
+	// from the side grows as fast while they enter the frame but has
+	// travelled across it by then
+	approachTime = 1500 * time.Millisecond
+	// points kept per track
+	trackPoints = 120
+)
+
+// defaults for the wake policy, set by flags and the api
+var (
+	defaultWakeOn     = wakeOnAny
+	defaultLingerTime = 3 * time.Second
+)
+
+// motionBlob is a connected group of active blocks
+type motionBlob struct {
+	Time     time.Time   `json:"time"`
+	Blocks   int         `json:"blocks"`
+	Centroid motionPoint `json:"centroid"`
+	Bounds   motionRect  `json:"bounds"`
+}
+
+// largestBlob returns the largest 8-connected group of active blocks with
+// a weight in the mask, or false if there is none big enough.  Only the
+// largest is followed, the mirror expects one person at a time.
+func largestBlob(t time.Time, active []bool, mask *motionMask) (motionBlob, bool) {
+	width, height := mask.width, mask.height
+	seen := make([]bool, len(active))
+	var best motionBlob
+	stack := []int{}
+
+	for start := range active {
+		if seen[start] || !active[start] || mask.weights[start] <= 0 {
+			continue
+		}
+
+		blob := motionBlob{Time: t}
+		minX, minY, maxX, maxY := width, height, -1, -1
+		var sx, sy float64
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%width, i/width
+			blob.Blocks++
+			sx += float64(x)
+			sy += float64(y)
+			if x < minX {
+				minX = x
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if y > maxY {
+				maxY = y
+			}
+
+			for ny := y - 1; ny <= y+1; ny++ {
+				for nx := x - 1; nx <= x+1; nx++ {
+					if nx < 0 || ny < 0 || nx >= width || ny >= height {
+						continue
+					}
+					if j := ny*width + nx; !seen[j] && active[j] && mask.weights[j] > 0 {
+						seen[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+
+		if blob.Blocks > best.Blocks {
+			n := float64(blob.Blocks)
+			blob.Centroid = motionPoint{X: sx / n, Y: sy / n}
+			blob.Bounds = motionRect{X: minX, Y: minY, Width: maxX - minX + 1, Height: maxY - minY + 1}
+			best = blob
+		}
+	}
+	return best, best.Blocks >= minBlobBlocks
+}
+
+// motionTrack follows a blob across frames
+type motionTrack struct {
+	points []motionBlob
+	class  string
+}
+
+func (t *motionTrack) first() motionBlob {
+	return t.points[0]
+}
+
+func (t *motionTrack) last() motionBlob {
+	return t.points[len(t.points)-1]
+}
+
+// classify decides what the track is doing on a frame width blocks wide.
+// Approaching has grown for at least approachTime without crossing the
+// frame, lingering has stayed put for lingerTime and passing has crossed the
+// frame.  Otherwise it is
+// undecided.
+func (t *motionTrack) classify(width int, lingerTime time.Duration) string {
+	first, last := t.first(), t.last()
+	duration := last.Time.Sub(first.Time)
+	travel := math.Abs(last.Centroid.X-first.Centroid.X) / float64(width)
+	growth := float64(last.Blocks) / float64(first.Blocks)
+
+	// movement over the last lingerTime only, so someone who walked in and
+	// stopped lingers
+	recent := first
+	for _, p := range t.points {
+		if last.Time.Sub(p.Time) <= lingerTime {
+			recent = p
+			break
+		}
+	}
+	recentTravel := math.Abs(last.Centroid.X-recent.Centroid.X) / float64(width)
+
+	switch {
+	case growth >= approachGrowth && travel < passingTravel && duration >= approachTime:
+		return motionApproaching
+	case duration >= lingerTime && recentTravel < passingTravel/2:
+		return motionLingering
+	case travel >= passingTravel:
+		return motionPassing
+	}
+	return ""
+}
+
+// motionTracker keeps the current track, it is only used from the motion
+// processor's goroutine through the monitor which holds its lock
+type motionTracker struct {
+	track *motionTrack
+}
+
+// Observe adds the frame's largest blob to the track, starting a new one if
+// it is too far from the last or the last has gone quiet, and returns the
+// track's classification
+func (tr *motionTracker) Observe(t time.Time, active []bool, mask *motionMask, lingerTime time.Duration) string {
+	if tr.track != nil && t.Sub(tr.track.last().Time) > trackGap {
+		tr.track = nil
+	}
+
+	blob, ok := largestBlob(t, active, mask)
+	if !ok {
+		if tr.track == nil {
+			return ""
+		}
+		return tr.track.class
+	}
+
+	if tr.track != nil {
+		last := tr.track.last()
+		dx := (blob.Centroid.X - last.Centroid.X) / float64(mask.width)
+		dy := (blob.Centroid.Y - last.Centroid.Y) / float64(mask.height)
+		if math.Hypot(dx, dy) > trackJump {
+			tr.track = nil
+		}
+	}
+	if tr.track == nil {
+		tr.track = &motionTrack{}
+	}
+
+	tr.track.points = append(tr.track.points, blob)
+	if len(tr.track.points) > trackPoints {
+		tr.track.points = tr.track.points[1:]
+	}
+	tr.track.class = tr.track.classify(mask.width, lingerTime)
+	return tr.track.class
+}
+
+// wakes returns true if a track classified as class should wake the display
+func wakes(wakeOn string, class string) bool {
+	return wakeOn == wakeOnAny || class == motionApproaching || class == motionLingering
+}
+
+func validWakeOn(wakeOn string) error {
+	if wakeOn != wakeOnAny && wakeOn != wakeOnApproach {
+		return fmt.Errorf("motion wakeOn must be any or approach")
+	}
+	return nil
+}
+
+func (t *motionTrack) MarshalJSON() ([]byte, error) {
+	first, last := t.first(), t.last()
+	return json.Marshal(map[string]interface{}{
+		"class":    t.class,
+		"started":  first.Time.Format(time.RFC3339),
+		"duration": last.Time.Sub(first.Time).String(),
+		"first":    first,
+		"last":     last,
+		"frames":   len(t.points),
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackClassify(t *testing.T) {
+	start := time.Date(2018, 11, 18, 21, 4, 5, 0, time.UTC)
+	// point is a blob of blocks at x seconds into the track
+	point := func(seconds float64, x float64, blocks int) motionBlob {
+		return motionBlob{
+			Time:     start.Add(time.Duration(seconds * float64(time.Second))),
+			Blocks:   blocks,
+			Centroid: motionPoint{X: x, Y: 6},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		points []motionBlob
+		class  string
+	}{
+		{"entering from the edge", []motionBlob{point(0, 0, 6), point(0.5, 0.5, 12), point(1, 1, 18)}, ""},
+		{"walking past", []motionBlob{point(0, 0, 6), point(0.5, 0.5, 12), point(1, 1, 18), point(1.5, 5, 18)}, motionPassing},
+		{"approaching", []motionBlob{point(0, 8, 4), point(0.5, 8, 6), point(1, 8.5, 9), point(1.5, 8, 12)}, motionApproaching},
+		{"growing too briefly", []motionBlob{point(0, 8, 4), point(0.5, 8, 12)}, ""},
+		{"lingering", []motionBlob{point(0, 8, 10), point(1.5, 8.5, 10), point(3, 8, 11)}, motionLingering},
+		{"walked in and stopped", []motionBlob{point(0, 0, 10), point(1, 4, 10), point(2, 5, 10), point(5, 5, 10)}, motionLingering},
+	}
+
+	for _, test := range tests {
+		track := &motionTrack{points: test.points}
+		if class := track.classify(17, 3*time.Second); class != test.class {
+			t.Errorf("%s classified %q, want %q", test.name, class, test.class)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Package mqtt is a minimal MQTT 3.1.1 client that subscribes to topics at
+// QoS 0, which is all the mirror needs to receive sensor readings.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	packetConnect      = 0x10
+	packetConnAck      = 0x20
+	packetPublish      = 0x30
+	packetSubscribe    = 0x82
+	packetSubAck       = 0x90
+	packetPingReq      = 0xC0
+	packetPingResp     = 0xD0
+	packetDisconnect   = 0xE0
+	protocolLevel      = 4
+	flagCleanSession   = 0x02
+	flagPassword       = 0x40
+	flagUsername       = 0x80
+	maxRemainingLength = 268435455
+)
+
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+type Options struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+}
+
+type Client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messages  chan Message
+	keepAlive time.Duration
+	err       error
+	done      chan bool
+	lock      *sync.Mutex
+}
+
+// Subscribe connects to the broker at addr and subscribes to topics.  The
+// messages channel is closed when the connection ends, Err returns why.
+func Subscribe(addr string, topics []string, opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 60 * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:      conn,
+		reader:    bufio.NewReader(conn),
+		messages:  make(chan Message, 16),
+		keepAlive: opts.KeepAlive,
+		done:      make(chan bool),
+		lock:      &sync.Mutex{},
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	} else if err := c.subscribe(topics); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	go c.readThread()
+	go c.pingThread()
+	return c, nil
+}
+
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+func (c *Client) Close() error {
+	c.lock.Lock()
+	select {
+	case <-c.done:
+		c.lock.Unlock()
+		return nil
+	default:
+		close(c.done)
+	}
+	c.lock.Unlock()
+
+	c.write(packetDisconnect, nil)
+	return c.conn.Close()
+}
+
+func (c *Client) connect(opts Options) error {
+	flags := byte(flagCleanSession)
+	if opts.Username != "" {
+		flags |= flagUsername
+	}
+	if opts.Password != "" {
+		flags |= flagPassword
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = appendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+	}
+	if opts.Password != "" {
+		body = appendString(body, opts.Password)
+	}
+
+	if err := c.write(packetConnect, body); err != nil {
+		return err
+	}
+
+	header, b, err := c.read()
+	if err != nil {
+		return err
+	} else if header&0xF0 != packetConnAck || len(b) != 2 {
+		return fmt.Errorf("mqtt: expected CONNACK, got %x", header)
+	} else if b[1] != 0 {
+		return fmt.Errorf("mqtt: connection refused with code %d", b[1])
+	}
+	return nil
+}
+
+func (c *Client) subscribe(topics []string) error {
+	body := appendUint16(nil, 1)
+	for _, t := range topics {
+		body = appendString(body, t)
+		body = append(body, 0)
+	}
+
+	if err := c.write(packetSubscribe, body); err != nil {
+		return err
+	}
+
+	header, b, err := c.read()
+	if err != nil {
+		return err
+	} else if header&0xF0 != packetSubAck || len(b) < 2 {
+		return fmt.Errorf("mqtt: expected SUBACK, got %x", header)
+	}
+	for i, rc := range b[2:] {
+		if rc == 0x80 {
+			return fmt.Errorf("mqtt: subscription to %s refused", topics[i])
+		}
+	}
+	return nil
+}
+
+func (c *Client) readThread() {
+	defer close(c.messages)
+
+	for {
+		// pings go out every half keepalive so a broker that hasn't sent
+		// anything for one and a half is gone, even if the connection
+		// never closed
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		header, b, err := c.read()
+		if err != nil {
+			c.finish(err)
+			return
+		}
+
+		switch header & 0xF0 {
+		case packetPublish:
+			m, err := parsePublish(header, b)
+			if err != nil {
+				c.finish(err)
+				return
+			}
+			select {
+			case c.messages <- m:
+			case <-c.done:
+				return
+			}
+		case packetPingResp:
+		default:
+			// anything else is unexpected at QoS 0 and ignored
+		}
+	}
+}
+
+func (c *Client) pingThread() {
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write(packetPingReq, nil); err != nil {
+				c.finish(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) finish(err error) {
+	c.lock.Lock()
+	if c.err == nil {
+		select {
+		case <-c.done:
+			// closed on purpose
+		default:
+			c.err = err
+		}
+	}
+	c.lock.Unlock()
+	c.Close()
+}
+
+func parsePublish(header byte, b []byte) (Message, error) {
+	if len(b) < 2 {
+		return Message{}, errors.New("mqtt: short PUBLISH")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return Message{}, errors.New("mqtt: short PUBLISH topic")
+	}
+	m := Message{Topic: string(b[2 : 2+n])}
+	b = b[2+n:]
+
+	// QoS 1 and 2 carry a packet id we don't acknowledge
+	if (header>>1)&0x03 != 0 {
+		if len(b) < 2 {
+			return Message{}, errors.New("mqtt: short PUBLISH packet id")
+		}
+		b = b[2:]
+	}
+	m.Payload = b
+	return m, nil
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return errors.New("mqtt: packet too large")
+	}
+
+	packet := []byte{header}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		packet = append(packet, d)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *Client) read() (header byte, body []byte, err error) {
+	if header, err = c.reader.ReadByte(); err != nil {
+		return
+	}
+
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		var d byte
+		if d, err = c.reader.ReadByte(); err != nil {
+			return
+		} else if i == 4 {
+			err = errors.New("mqtt: malformed remaining length")
+			return
+		}
+		n += int(d&0x7F) * multiplier
+		multiplier *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+
+	body = make([]byte, n)
+	_, err = io.ReadFull(c.reader, body)
+	return
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
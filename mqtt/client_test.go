@@ -0,0 +1,173 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// broker accepts one connection, acknowledges the CONNECT and SUBSCRIBE and
+// hands the connection to serve as a client sharing the packet code
+func broker(t *testing.T, serve func(b *Client)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := &Client{conn: conn, reader: bufio.NewReader(conn), lock: &sync.Mutex{}}
+
+		if header, _, err := b.read(); err != nil || header&0xF0 != packetConnect {
+			t.Errorf("expected CONNECT, got %x: %v", header, err)
+			return
+		}
+		b.write(packetConnAck, []byte{0, 0})
+		header, body, err := b.read()
+		if err != nil || header != packetSubscribe {
+			t.Errorf("expected SUBSCRIBE, got %x: %v", header, err)
+			return
+		}
+		// packet id then a single granted QoS 0
+		b.write(packetSubAck, append(body[:2:2], 0))
+		serve(b)
+	}()
+	return l.Addr().String()
+}
+
+func publish(b *Client, topic string, payload string) error {
+	return b.write(packetPublish, append(appendString(nil, topic), payload...))
+}
+
+func TestSubscribeReceives(t *testing.T) {
+	addr := broker(t, func(b *Client) {
+		publish(b, "mirror/sensors/kitchen", "21.5")
+		publish(b, "mirror/sensors/bedroom", `{"temperature":19}`)
+		// stay connected until the client disconnects
+		for {
+			if header, _, err := b.read(); err != nil || header == packetDisconnect {
+				return
+			}
+		}
+	})
+
+	c, err := Subscribe(addr, []string{"mirror/sensors/#"}, Options{ClientID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	want := []Message{
+		{Topic: "mirror/sensors/kitchen", Payload: []byte("21.5")},
+		{Topic: "mirror/sensors/bedroom", Payload: []byte(`{"temperature":19}`)},
+	}
+	for _, w := range want {
+		select {
+		case m := <-c.Messages():
+			if m.Topic != w.Topic || string(m.Payload) != string(w.Payload) {
+				t.Errorf("got %s %q, want %s %q", m.Topic, m.Payload, w.Topic, w.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", w.Topic)
+		}
+	}
+}
+
+func TestSubscribeRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := &Client{conn: conn, reader: bufio.NewReader(conn), lock: &sync.Mutex{}}
+		b.read()
+		// not authorized
+		b.write(packetConnAck, []byte{0, 5})
+	}()
+
+	if _, err := Subscribe(l.Addr().String(), []string{"a"}, Options{}); err == nil {
+		t.Errorf("expected the refused connection to fail")
+	}
+}
+
+func TestSubscribeCredentials(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := &Client{conn: conn, reader: bufio.NewReader(conn), lock: &sync.Mutex{}}
+		_, body, err := b.read()
+		if err != nil {
+			return
+		}
+		// the variable header is "MQTT", the level, the flags and the keep
+		// alive, then the client id, username and password
+		want := appendString(appendString(appendString(nil, "test"), "mirror"), "secret")
+		if len(body) < 10 || body[7]&(flagUsername|flagPassword) != flagUsername|flagPassword || !bytes.Equal(body[10:], want) {
+			b.write(packetConnAck, []byte{0, 5})
+			return
+		}
+		b.write(packetConnAck, []byte{0, 0})
+		header, body, err := b.read()
+		if err != nil || header != packetSubscribe {
+			return
+		}
+		b.write(packetSubAck, append(body[:2:2], 0))
+		b.read()
+	}()
+
+	c, err := Subscribe(l.Addr().String(), []string{"a"}, Options{ClientID: "test", Username: "mirror", Password: "secret"})
+	if err != nil {
+		t.Fatalf("the broker refused the credentials: %v", err)
+	}
+	c.Close()
+}
+
+func TestHalfOpenConnection(t *testing.T) {
+	stop := make(chan bool)
+	defer close(stop)
+
+	// a broker that stops answering, pings included, without closing
+	addr := broker(t, func(b *Client) {
+		<-stop
+	})
+
+	c, err := Subscribe(addr, []string{"a"}, Options{KeepAlive: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case _, ok := <-c.Messages():
+		if ok {
+			t.Fatalf("unexpected message")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client still waiting on a silent broker")
+	}
+	if ne, ok := c.Err().(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Err() = %v, want a timeout", c.Err())
+	}
+}
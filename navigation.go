@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// keys the navigator understands, displays translate their remote's keys
+// into these and the digits 0 to 9
+const (
+	keySelect = "select"
+	keyUp     = "up"
+	keyDown   = "down"
+	keyLeft   = "left"
+	keyRight  = "right"
+	keyBack   = "back"
+)
+
+// navigationEvent is broadcast on the websocket at the navigation path for
+// every key, the client highlights the focused element and pages within it
+type navigationEvent struct {
+	Key    string `json:"key"`
+	Action string `json:"action"`
+	Focus  string `json:"focus"`
+	Page   int    `json:"page"`
+}
+
+// navigator moves a focus between the mirror's elements from remote control
+// keys.  Left and right change the focused element, up and down page within
+// it, digits toggle streams and back dismisses weather alerts.
+type navigator struct {
+	ui    *mirrorInterface
+	focus string
+	page  int
+	lock  *sync.Mutex
+}
+
+func newNavigator(ui *mirrorInterface) *navigator {
+	return &navigator{
+		ui:   ui,
+		lock: &sync.Mutex{},
+	}
+}
+
+type namedElement struct {
+	path    string
+	element Element
+}
+
+// elements returns the elements that can be focused in the order they are
+// paged through
+func (n *navigator) elements() []namedElement {
+	ret := []namedElement{
+		{"dateTime", n.ui.date},
+		{"weather", n.ui.weather},
+		{"astronomy", n.ui.astronomy},
+		{"sensors", n.ui.sensors},
+	}
+	if n.ui.airQuality != nil {
+		ret = append(ret, namedElement{"airQuality", n.ui.airQuality})
+	}
+	return ret
+}
+
+// listen handles keys until the channel is closed, a nil channel blocks
+// forever
+func (n *navigator) listen(keys <-chan string) {
+	for key := range keys {
+		if err := n.Key(key); err != nil {
+			log.Printf("error handling remote key: %v", err)
+		}
+	}
+}
+
+// Key handles a key press and broadcasts the resulting event
+func (n *navigator) Key(key string) error {
+	ev := navigationEvent{Key: key}
+
+	switch key {
+	case keyLeft, keyRight:
+		ev.Action = "focus"
+		n.moveFocus(key == keyRight)
+	case keyUp, keyDown:
+		ev.Action = "page"
+		n.lock.Lock()
+		if key == keyDown {
+			n.page++
+		} else if n.page > 0 {
+			n.page--
+		}
+		n.lock.Unlock()
+	case keySelect:
+		ev.Action = "select"
+	case keyBack:
+		ev.Action = "back"
+		if alerts := n.ui.weather.alerts; alerts != nil && alerts.Dismiss() {
+			ev.Action = "dismissAlerts"
+		} else {
+			n.lock.Lock()
+			n.focus = ""
+			n.page = 0
+			n.lock.Unlock()
+		}
+	default:
+		d, err := strconv.Atoi(key)
+		if err != nil || d < 0 || d > 9 || len(key) != 1 {
+			return fmt.Errorf("unknown navigation key '%s'", key)
+		}
+		ev.Action = "toggleStream"
+		n.toggleStream(d)
+	}
+
+	n.lock.Lock()
+	ev.Focus = n.focus
+	ev.Page = n.page
+	n.lock.Unlock()
+
+	n.ui.changed <- socketResponse{
+		Request:  &socketRequest{Path: "navigation"},
+		Response: ev,
+	}
+	return nil
+}
+
+// moveFocus focuses the next or previous visible element, wrapping around
+func (n *navigator) moveFocus(forward bool) {
+	var visible []string
+	for _, e := range n.elements() {
+		if e.element.Visible() {
+			visible = append(visible, e.path)
+		}
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.page = 0
+	if len(visible) == 0 {
+		n.focus = ""
+		return
+	}
+
+	i := -1
+	for j, p := range visible {
+		if p == n.focus {
+			i = j
+		}
+	}
+	switch {
+	case i < 0 && forward:
+		i = 0
+	case i < 0:
+		i = len(visible) - 1
+	case forward:
+		i = (i + 1) % len(visible)
+	default:
+		i = (i + len(visible) - 1) % len(visible)
+	}
+	n.focus = visible[i]
+}
+
+// toggleStream shows or hides stream d, counting from 1 as on a remote.
+// Zero hides every stream.
+func (n *navigator) toggleStream(d int) {
+	streams := n.ui.Streams()
+	if d == 0 {
+		for _, s := range streams {
+			s.Hide()
+		}
+		return
+	}
+	if d > len(streams) {
+		return
+	}
+
+	if s := streams[d-1]; s.Visible() {
+		s.Hide()
+	} else {
+		s.Show()
+	}
+}
+
+func (n *navigator) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		if len(path) > 0 {
+			return nil, &NotFoundError{Path: path}
+		}
+
+		var key string
+		if err := json.Unmarshal(*msg, &key); err != nil {
+			return nil, err
+		} else if err := n.Key(key); err != nil {
+			return nil, err
+		}
+	}
+	return serveValuePath(n, path)
+}
+
+func (n *navigator) MarshalJSON() ([]byte, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return json.Marshal(map[string]interface{}{
+		"focus": n.focus,
+		"page":  n.page,
+	})
+}
@@ -0,0 +1,40 @@
+package nws
+
+import (
+	"time"
+)
+
+const CAPNamespace = "urn:oasis:names:tc:emergency:cap:1.2"
+
+// AlertFeed is the ATOM feed of CAP alerts returned by /alerts/active.atom
+type AlertFeed struct {
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated time.Time    `xml:"updated"`
+	Entries []AlertEntry `xml:"entry"`
+}
+
+type AlertEntry struct {
+	ID        string    `xml:"id"`
+	Updated   time.Time `xml:"updated"`
+	Published time.Time `xml:"published"`
+	Title     string    `xml:"title"`
+	Summary   string    `xml:"summary"`
+	Link      Link      `xml:"link"`
+	Event     string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 event"`
+	Sent      time.Time `xml:"urn:oasis:names:tc:emergency:cap:1.2 sent"`
+	Effective time.Time `xml:"urn:oasis:names:tc:emergency:cap:1.2 effective"`
+	Onset     time.Time `xml:"urn:oasis:names:tc:emergency:cap:1.2 onset"`
+	Expires   time.Time `xml:"urn:oasis:names:tc:emergency:cap:1.2 expires"`
+	Status    string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 status"`
+	MsgType   string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 msgType"`
+	Category  string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 category"`
+	Urgency   string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 urgency"`
+	Severity  string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 severity"`
+	Certainty string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 certainty"`
+	AreaDesc  string    `xml:"urn:oasis:names:tc:emergency:cap:1.2 areaDesc"`
+}
+
+type Link struct {
+	Href string `xml:"href,attr"`
+}
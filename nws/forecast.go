@@ -0,0 +1,50 @@
+package nws
+
+import (
+	"time"
+)
+
+type PointResponse struct {
+	Properties PointProperties `json:"properties"`
+}
+
+type PointProperties struct {
+	GridID         string `json:"gridId"`
+	GridX          int    `json:"gridX"`
+	GridY          int    `json:"gridY"`
+	Forecast       string `json:"forecast"`
+	ForecastHourly string `json:"forecastHourly"`
+	TimeZone       string `json:"timeZone"`
+}
+
+type ForecastResponse struct {
+	Properties ForecastProperties `json:"properties"`
+}
+
+type ForecastProperties struct {
+	Updated time.Time `json:"updated"`
+	Units   string    `json:"units"`
+	Periods []Period  `json:"periods"`
+}
+
+type Period struct {
+	Number                     int        `json:"number"`
+	Name                       string     `json:"name"`
+	StartTime                  time.Time  `json:"startTime"`
+	EndTime                    time.Time  `json:"endTime"`
+	IsDaytime                  bool       `json:"isDaytime"`
+	Temperature                float64    `json:"temperature"`
+	TemperatureUnit            string     `json:"temperatureUnit"`
+	ProbabilityOfPrecipitation QuantValue `json:"probabilityOfPrecipitation"`
+	RelativeHumidity           QuantValue `json:"relativeHumidity"`
+	WindSpeed                  string     `json:"windSpeed"`
+	WindDirection              string     `json:"windDirection"`
+	Icon                       string     `json:"icon"`
+	ShortForecast              string     `json:"shortForecast"`
+	DetailedForecast           string     `json:"detailedForecast"`
+}
+
+type QuantValue struct {
+	UnitCode string   `json:"unitCode"`
+	Value    *float64 `json:"value"`
+}
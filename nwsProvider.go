@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donniet/mirror2/nws"
+)
+
+type nwsProvider struct {
+	baseURL     string
+	latitude    float64
+	longitude   float64
+	forecastURL string
+	hourlyURL   string
+}
+
+func newNWSProvider(latitude float64, longitude float64) *nwsProvider {
+	return &nwsProvider{
+		baseURL:   "https://api.weather.gov",
+		latitude:  latitude,
+		longitude: longitude,
+	}
+}
+
+func (p *nwsProvider) Name() string {
+	return "nws"
+}
+
+func (p *nwsProvider) PointURL() string {
+	return fmt.Sprintf("%s/points/%.4f,%.4f", p.baseURL, p.latitude, p.longitude)
+}
+
+// lookup resolves the latitude and longitude to a forecast office grid.  The
+// result doesn't change so it is only fetched once.
+func (p *nwsProvider) lookup(client *http.Client) error {
+	if p.forecastURL != "" {
+		return nil
+	}
+
+	var point nws.PointResponse
+	if err := getJSON(client, p.PointURL(), &point); err != nil {
+		return err
+	} else if point.Properties.Forecast == "" {
+		return fmt.Errorf("nws point %s has no forecast", p.PointURL())
+	}
+	p.forecastURL = point.Properties.Forecast
+	p.hourlyURL = point.Properties.ForecastHourly
+	return nil
+}
+
+func (p *nwsProvider) Forecast(client *http.Client) (*Forecast, error) {
+	var f nws.ForecastResponse
+
+	if err := p.lookup(client); err != nil {
+		return nil, err
+	} else if err := getJSON(client, p.forecastURL, &f); err != nil {
+		return nil, err
+	} else if len(f.Properties.Periods) == 0 {
+		return nil, fmt.Errorf("nws response does not contain a valid forecast")
+	}
+
+	// periods alternate between day and night, the day's high comes from the
+	// daytime period and the low from the night that follows it
+	ret := &Forecast{}
+	for _, period := range f.Properties.Periods {
+		temp := nwsTemperature(period)
+
+		if period.IsDaytime || len(ret.Days) == 0 {
+			y, m, d := period.StartTime.Date()
+			ret.Days = append(ret.Days, ForecastDay{
+				Date:          time.Date(y, m, d, 0, 0, 0, 0, period.StartTime.Location()),
+				High:          math.NaN(),
+				Low:           math.NaN(),
+				Code:          nwsCondition(period.Icon),
+				Pop:           nwsValue(period.ProbabilityOfPrecipitation),
+				WindSpeed:     nwsWindSpeed(period.WindSpeed),
+				WindDirection: nwsWindDirection(period.WindDirection),
+				Humidity:      nwsValue(period.RelativeHumidity),
+				Precipitation: math.NaN(),
+				Snow:          math.NaN(),
+				Conditions:    period.ShortForecast,
+			})
+		}
+
+		day := &ret.Days[len(ret.Days)-1]
+		if period.IsDaytime {
+			day.High = temp
+		} else {
+			day.Low = temp
+		}
+	}
+
+	// the hourly forecast is nice to have, don't fail the whole forecast
+	// without it
+	var hourly nws.ForecastResponse
+	if p.hourlyURL == "" {
+		return ret, nil
+	} else if err := getJSON(client, p.hourlyURL, &hourly); err != nil {
+		log.Printf("error fetching nws hourly forecast: %v", err)
+		return ret, nil
+	}
+
+	for _, period := range hourly.Properties.Periods {
+		ret.Hours = append(ret.Hours, ForecastHour{
+			Time:          period.StartTime,
+			Temperature:   nwsTemperature(period),
+			Code:          nwsCondition(period.Icon),
+			Pop:           nwsValue(period.ProbabilityOfPrecipitation),
+			WindSpeed:     nwsWindSpeed(period.WindSpeed),
+			WindDirection: nwsWindDirection(period.WindDirection),
+			Humidity:      nwsValue(period.RelativeHumidity),
+			Precipitation: math.NaN(),
+			Conditions:    period.ShortForecast,
+		})
+	}
+	return ret, nil
+}
+
+func nwsTemperature(period nws.Period) float64 {
+	if period.TemperatureUnit == "F" {
+		return fahrenheitToCelsius(period.Temperature)
+	}
+	return period.Temperature
+}
+
+func nwsValue(v nws.QuantValue) float64 {
+	if v.Value == nil {
+		return math.NaN()
+	}
+	return *v.Value
+}
+
+// nwsWindSpeed parses wind speeds like "10 mph" or "5 to 15 mph" using the
+// highest speed in the range
+func nwsWindSpeed(s string) float64 {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return math.NaN()
+	}
+
+	speed, err := strconv.ParseFloat(fields[len(fields)-2], 64)
+	if err != nil {
+		return math.NaN()
+	}
+	if fields[len(fields)-1] == "mph" {
+		return mphToKPH(speed)
+	}
+	return speed
+}
+
+func nwsWindDirection(s string) float64 {
+	if d, ok := compassDegrees[s]; ok {
+		return d
+	}
+	return math.NaN()
+}
+
+// nwsCondition converts an icon url such as
+// https://api.weather.gov/icons/land/day/tsra_sct,20/rain,40?size=medium
+// into a condition using the first condition in the path
+func nwsCondition(iconURL string) string {
+	u, err := url.Parse(iconURL)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/icons/"), "/")
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return nwsConditions[strings.SplitN(parts[2], ",", 2)[0]]
+}
+
+var nwsConditions = map[string]string{
+	"skc":             "clear",
+	"few":             "mostlyclear",
+	"sct":             "partlycloudy",
+	"bkn":             "mostlycloudy",
+	"ovc":             "cloudy",
+	"wind_skc":        "wind",
+	"wind_few":        "wind",
+	"wind_sct":        "windpartlycloudy",
+	"wind_bkn":        "windpartlycloudy",
+	"wind_ovc":        "windcloudy",
+	"snow":            "snow",
+	"rain_snow":       "snow",
+	"rain_sleet":      "sleet",
+	"snow_sleet":      "sleet",
+	"fzra":            "sleet",
+	"rain_fzra":       "sleet",
+	"snow_fzra":       "sleet",
+	"sleet":           "sleet",
+	"rain":            "rain",
+	"rain_showers":    "showers",
+	"rain_showers_hi": "showers",
+	"tsra":            "tstorms",
+	"tsra_sct":        "chancetstorms",
+	"tsra_hi":         "chancetstorms",
+	"tornado":         "tornado",
+	"hurricane":       "tornado",
+	"tropical_storm":  "tornado",
+	"dust":            "fog",
+	"smoke":           "fog",
+	"haze":            "haze",
+	"hot":             "hot",
+	"cold":            "cold",
+	"blizzard":        "snow",
+	"fog":             "fog",
+}
+
+type nwsAlertProvider struct {
+	baseURL   string
+	latitude  float64
+	longitude float64
+}
+
+func newNWSAlertProvider(latitude float64, longitude float64) *nwsAlertProvider {
+	return &nwsAlertProvider{
+		baseURL:   "https://api.weather.gov",
+		latitude:  latitude,
+		longitude: longitude,
+	}
+}
+
+func (p *nwsAlertProvider) Name() string {
+	return "nws"
+}
+
+func (p *nwsAlertProvider) URL() string {
+	return fmt.Sprintf("%s/alerts/active.atom?point=%.4f,%.4f", p.baseURL, p.latitude, p.longitude)
+}
+
+func (p *nwsAlertProvider) Alerts(client *http.Client) ([]Alert, error) {
+	var feed nws.AlertFeed
+
+	if err := getXML(client, p.URL(), &feed); err != nil {
+		return nil, err
+	}
+
+	var ret []Alert
+	for _, entry := range feed.Entries {
+		// ignore tests, exercises and cancellations
+		if entry.Status != "Actual" || entry.MsgType == "Cancel" {
+			continue
+		}
+		ret = append(ret, Alert{
+			ID:        entry.ID,
+			Event:     entry.Event,
+			Headline:  entry.Title,
+			Summary:   entry.Summary,
+			Area:      entry.AreaDesc,
+			Severity:  entry.Severity,
+			Urgency:   entry.Urgency,
+			Certainty: entry.Certainty,
+			Effective: entry.Effective,
+			Expires:   entry.Expires,
+		})
+	}
+	return ret, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func nwsFixtures(t *testing.T) (*nwsProvider, *nwsAlertProvider, func() int) {
+	server, requests := fixtureServer(t, map[string]string{
+		"/points/44.9778,-93.2650":               "nws/point.json",
+		"/gridpoints/MPX/107,71/forecast":        "nws/forecast.json",
+		"/gridpoints/MPX/107,71/forecast/hourly": "nws/hourly.json",
+		"/alerts/active.atom":                    "nws/alerts.atom",
+	})
+
+	p := newNWSProvider(44.9778, -93.265)
+	p.baseURL = server.URL
+	a := newNWSAlertProvider(44.9778, -93.265)
+	a.baseURL = server.URL
+	return p, a, func() int { return len(*requests) }
+}
+
+func TestNWSForecast(t *testing.T) {
+	p, _, requests := nwsFixtures(t)
+	client := &http.Client{}
+
+	f, err := p.Forecast(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests() != 3 {
+		t.Errorf("made %d requests, want 3", requests())
+	}
+
+	// the forecast starts at night so the first day has no high
+	if len(f.Days) != 2 {
+		t.Fatalf("got %d days, want 2", len(f.Days))
+	}
+	if d := f.Days[0]; !math.IsNaN(d.High) || !approx(celsiusToFahrenheit(d.Low), 58) || d.Code != "mostlyclear" {
+		t.Errorf("unexpected first day %+v", d)
+	}
+	d := f.Days[1]
+	if !approx(celsiusToFahrenheit(d.High), 81) || !approx(celsiusToFahrenheit(d.Low), 63) {
+		t.Errorf("high and low = %v, %v want 81F, 63F", celsiusToFahrenheit(d.High), celsiusToFahrenheit(d.Low))
+	}
+	if d.Code != "chancetstorms" || d.Pop != 60 || d.Humidity != 70 || d.WindDirection != 180 {
+		t.Errorf("unexpected day %+v", d)
+	}
+	if !approx(d.WindSpeed, mphToKPH(15)) {
+		t.Errorf("wind speed = %v, want the top of the range", d.WindSpeed)
+	}
+	if y, m, day := d.Date.Date(); y != 2018 || m != time.June || day != 22 {
+		t.Errorf("date = %v, want June 22", d.Date)
+	}
+
+	if len(f.Hours) != 1 {
+		t.Fatalf("got %d hours, want 1", len(f.Hours))
+	}
+	if h := f.Hours[0]; !approx(celsiusToFahrenheit(h.Temperature), 72) || h.Code != "clear" {
+		t.Errorf("unexpected hour %+v", h)
+	}
+
+	// the grid lookup is only made once
+	if _, err := p.Forecast(client); err != nil {
+		t.Fatal(err)
+	} else if requests() != 5 {
+		t.Errorf("made %d requests, want 5", requests())
+	}
+}
+
+func TestNWSAlerts(t *testing.T) {
+	_, a, _ := nwsFixtures(t)
+
+	alerts, err := a.Alerts(&http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the test message is dropped
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	alert := alerts[0]
+	if alert.Event != "Severe Thunderstorm Warning" || alert.Severity != "Severe" || alert.Urgency != "Immediate" ||
+		alert.Area != "Hennepin, MN" || alert.ID != "urn:oid:2.49.0.1.840.0.1" {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	if alert.Expires.IsZero() || !alert.Expires.After(alert.Effective) {
+		t.Errorf("expires %v should follow effective %v", alert.Expires, alert.Effective)
+	}
+}
+
+func TestNWSCondition(t *testing.T) {
+	for icon, want := range map[string]string{
+		"https://api.weather.gov/icons/land/day/tsra_sct,20/rain,40?size=medium": "chancetstorms",
+		"https://api.weather.gov/icons/land/night/ovc?size=small":                "cloudy",
+		"https://api.weather.gov/icons/land/day/unknown":                         "",
+		"not a url": "",
+	} {
+		if got := nwsCondition(icon); got != want {
+			t.Errorf("nwsCondition(%s) = %q, want %q", icon, got, want)
+		}
+	}
+}
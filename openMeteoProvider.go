@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/donniet/mirror2/openmeteo"
+)
+
+type openMeteoProvider struct {
+	baseURL   string
+	latitude  float64
+	longitude float64
+}
+
+func newOpenMeteoProvider(latitude float64, longitude float64) *openMeteoProvider {
+	return &openMeteoProvider{
+		baseURL:   "https://api.open-meteo.com/v1",
+		latitude:  latitude,
+		longitude: longitude,
+	}
+}
+
+func (p *openMeteoProvider) Name() string {
+	return "openmeteo"
+}
+
+func (p *openMeteoProvider) URL() string {
+	q := url.Values{}
+	q.Set("latitude", fmt.Sprintf("%.4f", p.latitude))
+	q.Set("longitude", fmt.Sprintf("%.4f", p.longitude))
+	q.Set("daily", "weather_code,temperature_2m_max,temperature_2m_min,"+
+		"precipitation_probability_max,wind_speed_10m_max,wind_direction_10m_dominant,"+
+		"relative_humidity_2m_mean,precipitation_sum,snowfall_sum")
+	q.Set("hourly", "weather_code,temperature_2m,precipitation_probability,precipitation,"+
+		"wind_speed_10m,wind_direction_10m,relative_humidity_2m")
+	q.Set("forecast_hours", "24")
+	q.Set("timezone", "auto")
+	return p.baseURL + "/forecast?" + q.Encode()
+}
+
+func (p *openMeteoProvider) Forecast(client *http.Client) (*Forecast, error) {
+	var f openmeteo.ForecastResponse
+
+	if err := getJSON(client, p.URL(), &f); err != nil {
+		return nil, err
+	} else if f.Error {
+		return nil, fmt.Errorf("open-meteo error: %s", f.Reason)
+	} else if f.Daily == nil || len(f.Daily.Time) == 0 {
+		return nil, fmt.Errorf("open-meteo response does not contain a daily forecast")
+	}
+
+	loc := f.Location()
+	ret := &Forecast{}
+	for i := range f.Daily.Time {
+		code := intAt(f.Daily.WeatherCode, i)
+		ret.Days = append(ret.Days, ForecastDay{
+			Date:          f.Daily.Date(i, loc),
+			High:          valueAt(f.Daily.Temperature2mMax, i),
+			Low:           valueAt(f.Daily.Temperature2mMin, i),
+			Code:          wmoConditionCodes[code],
+			Pop:           valueAt(f.Daily.PrecipitationProbability, i),
+			WindSpeed:     valueAt(f.Daily.WindSpeed10mMax, i),
+			WindDirection: valueAt(f.Daily.WindDirection10m, i),
+			Humidity:      valueAt(f.Daily.RelativeHumidity2mMean, i),
+			Precipitation: valueAt(f.Daily.PrecipitationSum, i),
+			Snow:          valueAt(f.Daily.SnowfallSum, i),
+			Conditions:    wmoDescriptions[code],
+		})
+	}
+	if f.Hourly != nil {
+		for i := range f.Hourly.Time {
+			code := intAt(f.Hourly.WeatherCode, i)
+			ret.Hours = append(ret.Hours, ForecastHour{
+				Time:          f.Hourly.Date(i, loc),
+				Temperature:   valueAt(f.Hourly.Temperature2m, i),
+				Code:          wmoConditionCodes[code],
+				Pop:           valueAt(f.Hourly.PrecipitationProbability, i),
+				WindSpeed:     valueAt(f.Hourly.WindSpeed10m, i),
+				WindDirection: valueAt(f.Hourly.WindDirection10m, i),
+				Humidity:      valueAt(f.Hourly.RelativeHumidity2m, i),
+				Precipitation: valueAt(f.Hourly.Precipitation, i),
+				Conditions:    wmoDescriptions[code],
+			})
+		}
+	}
+	return ret, nil
+}
+
+var openMeteoGeocodingURL = "https://geocoding-api.open-meteo.com/v1/search"
+
+// geocode looks up a place name such as "Duluth" or "Duluth, Minnesota"
+// using the Open-Meteo geocoding api, which only matches on the place so
+// anything after the first comma is used to pick between the results
+func geocode(client *http.Client, name string) (Location, error) {
+	parts := strings.SplitN(name, ",", 2)
+
+	q := url.Values{}
+	q.Set("name", strings.TrimSpace(parts[0]))
+	q.Set("count", "10")
+	q.Set("format", "json")
+
+	var res openmeteo.GeocodingResponse
+	if err := getJSON(client, openMeteoGeocodingURL+"?"+q.Encode(), &res); err != nil {
+		return Location{}, err
+	} else if len(res.Results) == 0 {
+		return Location{}, fmt.Errorf("location '%s' not found", name)
+	}
+
+	r := res.Results[0]
+	if len(parts) > 1 {
+		region := strings.ToLower(strings.TrimSpace(parts[1]))
+		for _, c := range res.Results {
+			if strings.ToLower(c.Admin1) == region || strings.ToLower(c.Country) == region {
+				r = c
+				break
+			}
+		}
+	}
+
+	return Location{
+		Name:      name,
+		Latitude:  r.Latitude,
+		Longitude: r.Longitude,
+		Timezone:  r.Timezone,
+	}, nil
+}
+
+func valueAt(a []float64, i int) float64 {
+	if i < len(a) {
+		return a[i]
+	}
+	return math.NaN()
+}
+
+func intAt(a []int, i int) int {
+	if i < len(a) {
+		return a[i]
+	}
+	return -1
+}
+
+// wmoConditionCodes maps WMO weather interpretation codes to conditions
+var wmoConditionCodes = map[int]string{
+	0:  "clear",
+	1:  "mostlyclear",
+	2:  "partlycloudy",
+	3:  "cloudy",
+	45: "fog",
+	48: "fog",
+	51: "drizzle",
+	53: "drizzle",
+	55: "drizzle",
+	56: "sleet",
+	57: "sleet",
+	61: "rain",
+	63: "rain",
+	65: "rain",
+	66: "sleet",
+	67: "sleet",
+	71: "snow",
+	73: "snow",
+	75: "snow",
+	77: "flurries",
+	80: "showers",
+	81: "showers",
+	82: "showers",
+	85: "snowshowers",
+	86: "snowshowers",
+	95: "tstorms",
+	96: "tstorms",
+	99: "tstorms",
+}
+
+var wmoDescriptions = map[int]string{
+	0:  "Clear",
+	1:  "Mainly Clear",
+	2:  "Partly Cloudy",
+	3:  "Overcast",
+	45: "Fog",
+	48: "Freezing Fog",
+	51: "Light Drizzle",
+	53: "Drizzle",
+	55: "Heavy Drizzle",
+	56: "Freezing Drizzle",
+	57: "Heavy Freezing Drizzle",
+	61: "Light Rain",
+	63: "Rain",
+	65: "Heavy Rain",
+	66: "Freezing Rain",
+	67: "Heavy Freezing Rain",
+	71: "Light Snow",
+	73: "Snow",
+	75: "Heavy Snow",
+	77: "Snow Grains",
+	80: "Rain Showers",
+	81: "Rain Showers",
+	82: "Heavy Rain Showers",
+	85: "Snow Showers",
+	86: "Heavy Snow Showers",
+	95: "Thunderstorm",
+	96: "Thunderstorm with Hail",
+	99: "Thunderstorm with Heavy Hail",
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpenMeteoForecast(t *testing.T) {
+	server, requests := fixtureServer(t, map[string]string{
+		"/forecast": "openmeteo/forecast.json",
+	})
+
+	p := newOpenMeteoProvider(44.9778, -93.265)
+	p.baseURL = server.URL
+
+	f, err := p.Forecast(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := (*requests)[0].URL.Query()
+	if q.Get("latitude") != "44.9778" || q.Get("longitude") != "-93.2650" || q.Get("timezone") != "auto" {
+		t.Errorf("unexpected query %v", q)
+	}
+
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	if len(f.Days) != 2 {
+		t.Fatalf("got %d days, want 2", len(f.Days))
+	}
+	d := f.Days[0]
+	if !d.Date.Equal(time.Date(2018, 6, 21, 0, 0, 0, 0, chicago)) {
+		t.Errorf("date = %v, want midnight June 21 in Chicago", d.Date)
+	}
+	if d.High != 23.9 || d.Low != 14.4 || d.Pop != 20 || d.WindSpeed != 24.1 || d.WindDirection != 310 ||
+		d.Humidity != 55 || d.Precipitation != 1 || d.Snow != 0 {
+		t.Errorf("unexpected day %+v", d)
+	}
+	if d.Code != "partlycloudy" || d.Conditions != "Partly Cloudy" {
+		t.Errorf("condition = %s (%s)", d.Code, d.Conditions)
+	}
+	if f.Days[1].Code != "tstorms" {
+		t.Errorf("second day condition = %s, want tstorms", f.Days[1].Code)
+	}
+
+	if len(f.Hours) != 2 {
+		t.Fatalf("got %d hours, want 2", len(f.Hours))
+	}
+	h := f.Hours[1]
+	if !h.Time.Equal(time.Date(2018, 6, 21, 20, 0, 0, 0, chicago)) {
+		t.Errorf("hour = %v, want 8pm June 21 in Chicago", h.Time)
+	}
+	if h.Temperature != 21 || h.Code != "mostlyclear" || h.Pop != 5 || h.Humidity != 52 {
+		t.Errorf("unexpected hour %+v", h)
+	}
+}
+
+func TestOpenMeteoForecastError(t *testing.T) {
+	server, _ := fixtureServer(t, map[string]string{
+		"/forecast": "openmeteo/error.json",
+	})
+
+	p := newOpenMeteoProvider(91, 0)
+	p.baseURL = server.URL
+
+	if _, err := p.Forecast(server.Client()); err == nil {
+		t.Error("expected an open-meteo error to fail")
+	}
+}
+
+func TestGeocode(t *testing.T) {
+	server, requests := fixtureServer(t, map[string]string{
+		"/v1/search": "openmeteo/geocoding.json",
+	})
+
+	defer func(u string) { openMeteoGeocodingURL = u }(openMeteoGeocodingURL)
+	openMeteoGeocodingURL = server.URL + "/v1/search"
+
+	loc, err := geocode(server.Client(), "Duluth, Georgia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := (*requests)[0].URL.Query().Get("name"); name != "Duluth" {
+		t.Errorf("searched for %q, want Duluth", name)
+	}
+	if loc.Name != "Duluth, Georgia" || loc.Latitude != 34.00288 || loc.Timezone != "America/New_York" {
+		t.Errorf("unexpected location %+v", loc)
+	}
+
+	// without a region the first result wins
+	if loc, err = geocode(server.Client(), "Duluth"); err != nil {
+		t.Fatal(err)
+	} else if loc.Timezone != "America/Chicago" {
+		t.Errorf("unexpected location %+v", loc)
+	}
+}
@@ -0,0 +1,44 @@
+package openmeteo
+
+import (
+	"time"
+)
+
+// AirQualityResponse is returned by the air quality api.  Values are null
+// where a pollutant isn't modelled, pollen is only forecast for Europe.
+type AirQualityResponse struct {
+	Latitude       float64           `json:"latitude"`
+	Longitude      float64           `json:"longitude"`
+	Timezone       string            `json:"timezone"`
+	UTCOffset      int               `json:"utc_offset_seconds"`
+	Hourly         *AirQualityHourly `json:"hourly,omitempty"`
+	Error          bool              `json:"error,omitempty"`
+	Reason         string            `json:"reason,omitempty"`
+	GenerationTime float64           `json:"generationtime_ms"`
+}
+
+type AirQualityHourly struct {
+	Time            []string   `json:"time"`
+	PM25            []*float64 `json:"pm2_5"`
+	PM10            []*float64 `json:"pm10"`
+	Ozone           []*float64 `json:"ozone"`
+	NitrogenDioxide []*float64 `json:"nitrogen_dioxide"`
+	AlderPollen     []*float64 `json:"alder_pollen"`
+	BirchPollen     []*float64 `json:"birch_pollen"`
+	GrassPollen     []*float64 `json:"grass_pollen"`
+	MugwortPollen   []*float64 `json:"mugwort_pollen"`
+	OlivePollen     []*float64 `json:"olive_pollen"`
+	RagweedPollen   []*float64 `json:"ragweed_pollen"`
+}
+
+func (r *AirQualityResponse) Location() *time.Location {
+	if loc, err := time.LoadLocation(r.Timezone); err == nil {
+		return loc
+	}
+	return time.FixedZone(r.Timezone, r.UTCOffset)
+}
+
+func (h *AirQualityHourly) Date(i int, loc *time.Location) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02T15:04", h.Time[i], loc)
+	return t
+}
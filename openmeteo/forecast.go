@@ -0,0 +1,82 @@
+package openmeteo
+
+import (
+	"time"
+)
+
+type ForecastResponse struct {
+	Latitude       float64     `json:"latitude"`
+	Longitude      float64     `json:"longitude"`
+	Timezone       string      `json:"timezone"`
+	UTCOffset      int         `json:"utc_offset_seconds"`
+	Daily          *Daily      `json:"daily,omitempty"`
+	DailyUnits     *DailyUnits `json:"daily_units,omitempty"`
+	Hourly         *Hourly     `json:"hourly,omitempty"`
+	Error          bool        `json:"error,omitempty"`
+	Reason         string      `json:"reason,omitempty"`
+	GenerationTime float64     `json:"generationtime_ms"`
+}
+
+type Daily struct {
+	Time                     []string  `json:"time"`
+	WeatherCode              []int     `json:"weather_code"`
+	Temperature2mMax         []float64 `json:"temperature_2m_max"`
+	Temperature2mMin         []float64 `json:"temperature_2m_min"`
+	PrecipitationProbability []float64 `json:"precipitation_probability_max"`
+	PrecipitationSum         []float64 `json:"precipitation_sum"`
+	SnowfallSum              []float64 `json:"snowfall_sum"`
+	WindSpeed10mMax          []float64 `json:"wind_speed_10m_max"`
+	WindDirection10m         []float64 `json:"wind_direction_10m_dominant"`
+	RelativeHumidity2mMean   []float64 `json:"relative_humidity_2m_mean"`
+}
+
+type Hourly struct {
+	Time                     []string  `json:"time"`
+	WeatherCode              []int     `json:"weather_code"`
+	Temperature2m            []float64 `json:"temperature_2m"`
+	PrecipitationProbability []float64 `json:"precipitation_probability"`
+	Precipitation            []float64 `json:"precipitation"`
+	WindSpeed10m             []float64 `json:"wind_speed_10m"`
+	WindDirection10m         []float64 `json:"wind_direction_10m"`
+	RelativeHumidity2m       []float64 `json:"relative_humidity_2m"`
+	IsDay                    []int     `json:"is_day"`
+}
+
+type DailyUnits struct {
+	Temperature2mMax string `json:"temperature_2m_max"`
+	WindSpeed10mMax  string `json:"wind_speed_10m_max"`
+	PrecipitationSum string `json:"precipitation_sum"`
+}
+
+func (r *ForecastResponse) Location() *time.Location {
+	if loc, err := time.LoadLocation(r.Timezone); err == nil {
+		return loc
+	}
+	return time.FixedZone(r.Timezone, r.UTCOffset)
+}
+
+func (d *Daily) Date(i int, loc *time.Location) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02", d.Time[i], loc)
+	return t
+}
+
+func (h *Hourly) Date(i int, loc *time.Location) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02T15:04", h.Time[i], loc)
+	return t
+}
+
+type GeocodingResponse struct {
+	Results        []GeocodingResult `json:"results"`
+	GenerationTime float64           `json:"generationtime_ms"`
+}
+
+type GeocodingResult struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Elevation float64 `json:"elevation"`
+	Timezone  string  `json:"timezone"`
+	Country   string  `json:"country"`
+	Admin1    string  `json:"admin1"`
+}
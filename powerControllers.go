@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// powerController turns a screen on and off for powerDisplay
+type powerController interface {
+	Name() string
+	SetPower(on bool) error
+	Power() (on bool, err error)
+}
+
+// brightnessController is implemented by controllers that can dim the
+// screen themselves
+type brightnessController interface {
+	SetBrightness(percent int) error
+	Brightness() (int, error)
+}
+
+// commandRunner runs external programs so command based controllers can be
+// tested with a fake
+type commandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+const sysfsBacklight = "/sys/class/backlight"
+
+// backlightController uses the kernel backlight class, as found on DSI
+// screens like the official Raspberry Pi display.  bl_power takes the
+// framebuffer blanking levels where 0 is on and 4 is powered down.
+type backlightController struct {
+	dir string
+}
+
+// newBacklightController opens the named device under root, or the first
+// device if name is empty
+func newBacklightController(root string, name string) (*backlightController, error) {
+	if name == "" {
+		entries, err := ioutil.ReadDir(root)
+		if err != nil {
+			return nil, err
+		} else if len(entries) == 0 {
+			return nil, fmt.Errorf("no backlight devices in %s", root)
+		}
+		name = entries[0].Name()
+	}
+
+	c := &backlightController{dir: filepath.Join(root, name)}
+	if _, err := c.Power(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *backlightController) Name() string {
+	return "backlight:" + filepath.Base(c.dir)
+}
+
+func (c *backlightController) read(file string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, file))
+	return strings.TrimSpace(string(b)), err
+}
+
+func (c *backlightController) write(file string, value string) error {
+	return ioutil.WriteFile(filepath.Join(c.dir, file), []byte(value), 0644)
+}
+
+func (c *backlightController) SetPower(on bool) error {
+	if on {
+		return c.write("bl_power", "0")
+	}
+	return c.write("bl_power", "4")
+}
+
+func (c *backlightController) Power() (bool, error) {
+	v, err := c.read("bl_power")
+	if err != nil {
+		return false, err
+	}
+	return v == "0", nil
+}
+
+func (c *backlightController) maxBrightness() (int, error) {
+	v, err := c.read("max_brightness")
+	if err != nil {
+		return 0, err
+	}
+	max, err := strconv.Atoi(v)
+	if err == nil && max <= 0 {
+		err = fmt.Errorf("%s max_brightness is %d", c.Name(), max)
+	}
+	return max, err
+}
+
+func (c *backlightController) SetBrightness(percent int) error {
+	max, err := c.maxBrightness()
+	if err != nil {
+		return err
+	}
+	return c.write("brightness", strconv.Itoa((percent*max+50)/100))
+}
+
+func (c *backlightController) Brightness() (int, error) {
+	max, err := c.maxBrightness()
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.read("brightness")
+	if err != nil {
+		return 0, err
+	}
+	b, err := strconv.Atoi(v)
+	return (b*100 + max/2) / max, err
+}
+
+// vcgencmdController switches the HDMI output of a Raspberry Pi
+type vcgencmdController struct {
+	runner commandRunner
+}
+
+func (c *vcgencmdController) Name() string {
+	return "vcgencmd"
+}
+
+func (c *vcgencmdController) SetPower(on bool) error {
+	v := "0"
+	if on {
+		v = "1"
+	}
+	_, err := c.runner.Run("vcgencmd", "display_power", v)
+	return err
+}
+
+// Power parses output like "display_power=1"
+func (c *vcgencmdController) Power() (bool, error) {
+	out, err := c.runner.Run("vcgencmd", "display_power")
+	if err != nil {
+		return false, err
+	}
+
+	s := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(s, "display_power=") {
+		return false, fmt.Errorf("unexpected vcgencmd output '%s'", s)
+	}
+	return strings.TrimPrefix(s, "display_power=") != "0", nil
+}
+
+// xsetController uses DPMS through the X server named by $DISPLAY
+type xsetController struct {
+	runner commandRunner
+}
+
+func (c *xsetController) Name() string {
+	return "xset"
+}
+
+func (c *xsetController) SetPower(on bool) error {
+	v := "off"
+	if on {
+		v = "on"
+	}
+	_, err := c.runner.Run("xset", "dpms", "force", v)
+	return err
+}
+
+// Power looks for "Monitor is On" in the DPMS section of xset q, standby,
+// suspend and off all count as off
+func (c *xsetController) Power() (bool, error) {
+	out, err := c.runner.Run("xset", "q")
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Monitor is") {
+			return line == "Monitor is On", nil
+		}
+	}
+	// DPMS disabled means the monitor is never blanked
+	return true, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunner answers commands from outputs by their command line and
+// records what was run.  A command with a channel in block waits for it.
+type fakeRunner struct {
+	outputs map[string]string
+	block   map[string]chan bool
+	ran     []string
+	lock    *sync.Mutex
+}
+
+func newFakeRunner(outputs map[string]string) *fakeRunner {
+	return &fakeRunner{
+		outputs: outputs,
+		block:   make(map[string]chan bool),
+		lock:    &sync.Mutex{},
+	}
+}
+
+func (r *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+
+	r.lock.Lock()
+	r.ran = append(r.ran, cmd)
+	out, ok := r.outputs[cmd]
+	block := r.block[cmd]
+	r.lock.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	if !ok {
+		return nil, errors.New(cmd + ": not found")
+	}
+	return []byte(out), nil
+}
+
+func (r *fakeRunner) Ran() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.ran...)
+}
+
+func TestVcgencmdController(t *testing.T) {
+	for _, c := range []struct {
+		output string
+		on     bool
+		err    bool
+	}{
+		{"display_power=1\n", true, false},
+		{"display_power=0\n", false, false},
+		{"display_power=-1\n", true, false},
+		{"VCHI initialization failed\n", false, true},
+	} {
+		r := newFakeRunner(map[string]string{"vcgencmd display_power": c.output})
+		on, err := (&vcgencmdController{runner: r}).Power()
+		if on != c.on || (err != nil) != c.err {
+			t.Errorf("Power() with %q = %v, %v", c.output, on, err)
+		}
+	}
+
+	r := newFakeRunner(map[string]string{
+		"vcgencmd display_power 0": "display_power=0",
+		"vcgencmd display_power 1": "display_power=1",
+	})
+	c := &vcgencmdController{runner: r}
+	if err := c.SetPower(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetPower(true); err != nil {
+		t.Fatal(err)
+	}
+	if ran := strings.Join(r.Ran(), "; "); ran != "vcgencmd display_power 0; vcgencmd display_power 1" {
+		t.Errorf("ran %s", ran)
+	}
+}
+
+const xsetQuery = `Keyboard Control:
+  auto repeat:  on    key click percent:  0    LED mask:  00000000
+Screen Saver:
+  prefer blanking:  yes    allow exposures:  yes
+  timeout:  600    cycle:  600
+DPMS (Energy Star):
+  Standby: 600    Suspend: 600    Off: 600
+  DPMS is Enabled
+  Monitor is %s
+`
+
+func TestXsetController(t *testing.T) {
+	for _, c := range []struct {
+		output string
+		on     bool
+	}{
+		{strings.Replace(xsetQuery, "%s", "On", 1), true},
+		{strings.Replace(xsetQuery, "%s", "Off", 1), false},
+		{strings.Replace(xsetQuery, "%s", "in Standby", 1), false},
+		{"DPMS (Energy Star):\n  Server does not have the DPMS Extension\n", true},
+	} {
+		r := newFakeRunner(map[string]string{"xset q": c.output})
+		on, err := (&xsetController{runner: r}).Power()
+		if err != nil || on != c.on {
+			t.Errorf("Power() = %v, %v, want %v", on, err, c.on)
+		}
+	}
+
+	r := newFakeRunner(map[string]string{"xset dpms force off": ""})
+	if err := (&xsetController{runner: r}).SetPower(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&xsetController{runner: r}).SetPower(true); err == nil {
+		t.Errorf("a failing xset should return its error")
+	}
+}
+
+func TestPowerDisplaySetPower(t *testing.T) {
+	r := newFakeRunner(map[string]string{
+		"vcgencmd display_power":   "display_power=0",
+		"vcgencmd display_power 1": "",
+	})
+	d := newPowerDisplay(&vcgencmdController{runner: r})
+	if d.PowerStatus() != "standby" {
+		t.Fatalf("power status = %s, want standby", d.PowerStatus())
+	}
+
+	// a slow vcgencmd doesn't hold up the display's state
+	release := make(chan bool)
+	r.lock.Lock()
+	r.block["vcgencmd display_power 1"] = release
+	r.lock.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		d.SetPower(true, reasonMotion)
+		close(done)
+	}()
+	for ran := r.Ran(); ran[len(ran)-1] != "vcgencmd display_power 1"; ran = r.Ran() {
+		time.Sleep(time.Millisecond)
+	}
+	marshaled := make(chan bool)
+	go func() {
+		d.MarshalJSON()
+		d.Brightness()
+		close(marshaled)
+	}()
+	select {
+	case <-marshaled:
+	case <-time.After(time.Second):
+		t.Fatalf("the display was locked while vcgencmd ran")
+	}
+	close(release)
+	<-done
+
+	events := d.History().Events()
+	if last := events[len(events)-1]; last.PowerStatus != "on" || last.Reason != reasonMotion {
+		t.Errorf("last power event %+v", last)
+	}
+
+	// a failed command leaves the status alone and reports the error
+	d.SetPower(false, reasonAPI)
+	d.lock.Lock()
+	status, err := d.powerStatus, d.err
+	d.lock.Unlock()
+	if status != "on" || err == nil {
+		t.Errorf("after a failed standby status = %s, error %v", status, err)
+	}
+}
+
+// fakeBacklight makes a sysfs backlight class under a temporary root
+func fakeBacklight(t *testing.T, devices map[string]int) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, max := range devices {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for file, value := range map[string]string{
+			"bl_power":       "4\n",
+			"brightness":     "0\n",
+			"max_brightness": strconv.Itoa(max) + "\n",
+		} {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func TestBacklightController(t *testing.T) {
+	root := fakeBacklight(t, map[string]int{"rpi_backlight": 255, "other": 100})
+
+	// without a name the first device is used
+	c, err := newBacklightController(root, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if c.Name() != "backlight:other" {
+		t.Errorf("picked %s, want the first device", c.Name())
+	}
+	if _, err := newBacklightController(root, "missing"); err == nil {
+		t.Errorf("opened a device that isn't there")
+	}
+	if _, err := newBacklightController(t.TempDir(), ""); err == nil {
+		t.Errorf("opened a device without any backlights")
+	}
+
+	c, err = newBacklightController(root, "rpi_backlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(file string) string {
+		t.Helper()
+		b, err := ioutil.ReadFile(filepath.Join(root, "rpi_backlight", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	if on, err := c.Power(); err != nil || on {
+		t.Errorf("powered %v, %v, want off", on, err)
+	}
+	if err := c.SetPower(true); err != nil {
+		t.Fatal(err)
+	} else if v := read("bl_power"); v != "0" {
+		t.Errorf("bl_power %s when on, want 0", v)
+	}
+	if on, err := c.Power(); err != nil || !on {
+		t.Errorf("powered %v, %v, want on", on, err)
+	}
+	if err := c.SetPower(false); err != nil {
+		t.Fatal(err)
+	} else if v := read("bl_power"); v != "4" {
+		t.Errorf("bl_power %s when off, want 4", v)
+	}
+
+	// brightness is a percentage of max_brightness
+	for _, b := range []struct {
+		percent int
+		raw     string
+	}{
+		{100, "255"},
+		{50, "128"},
+		{1, "3"},
+		{0, "0"},
+	} {
+		if err := c.SetBrightness(b.percent); err != nil {
+			t.Fatal(err)
+		} else if v := read("brightness"); v != b.raw {
+			t.Errorf("%d%% wrote %s, want %s", b.percent, v, b.raw)
+		}
+		if percent, err := c.Brightness(); err != nil || percent != b.percent {
+			t.Errorf("read %d%%, %v back, want %d%%", percent, err, b.percent)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "rpi_backlight", "max_brightness"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBrightness(50); err == nil {
+		t.Errorf("scaled brightness by a max_brightness of 0")
+	}
+}
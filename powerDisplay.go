@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// powerDisplay is a Display without CEC, it can only turn the screen on and
+// off through its controller.  Brightness is set by the controller if it can
+// dim and emulated by the client otherwise.  Volume and key presses are
+// ignored.
+type powerDisplay struct {
+	controller  powerController
+	dimmer      brightnessController
+	powerStatus string
+	brightness  int
+	err         error
+	history     *powerHistory
+	energy      *energyMeter
+	lock        *sync.Mutex
+	powerLock   *sync.Mutex
+	changeNotifier
+	*powerPolicy
+}
+
+func newPowerDisplay(controller powerController) *powerDisplay {
+	d := &powerDisplay{
+		controller:     controller,
+		brightness:     100,
+		lock:           &sync.Mutex{},
+		powerLock:      &sync.Mutex{},
+		changeNotifier: newChangeNotifier(),
+	}
+	d.powerStatus, d.err = d.queryPower()
+	d.history = newPowerHistory(d.powerStatus)
+	d.powerPolicy = newPowerPolicy(d.SetPower, d.notify)
+	d.energy = newEnergyMeter(d.history, d.notify)
+	if dimmer, ok := controller.(brightnessController); ok {
+		d.dimmer = dimmer
+		if b, err := dimmer.Brightness(); err != nil {
+			log.Printf("error reading %s brightness: %v", controller.Name(), err)
+		} else {
+			d.brightness = b
+		}
+	}
+	return d
+}
+
+func (d *powerDisplay) queryPower() (string, error) {
+	on, err := d.controller.Power()
+	if err != nil {
+		return "", err
+	} else if on {
+		return "on", nil
+	}
+	return "standby", nil
+}
+
+func (d *powerDisplay) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			if err := json.Unmarshal(*msg, d); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(d)
+		return (*json.RawMessage)(&b), err
+	}
+
+	switch path[0] {
+	case "history":
+		return serveValuePath(d.History(), path[1:])
+	case "policy":
+		return d.powerPolicy.ServeJSON(path[1:], msg)
+	case "input":
+		return serveInput(d, path[1:], msg)
+	case "energy":
+		return d.energy.ServeJSON(path[1:], msg)
+	}
+
+	if len(path) > 1 {
+		return nil, &NotFoundError{Path: path}
+	}
+
+	if path[0] == "brightness" && msg != nil {
+		var b int
+		if err := json.Unmarshal(*msg, &b); err != nil {
+			return nil, err
+		} else if err := d.SetBrightness(b); err != nil {
+			return nil, err
+		}
+	}
+
+	var v interface{}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	switch path[0] {
+	case "powerStatus":
+		v = d.powerStatus
+	case "backend":
+		v = d.controller.Name()
+	case "brightness":
+		v = d.brightness
+	case "dim":
+		v = d.dim()
+	case "sleeping":
+		v = d.Sleeping()
+	case "waking":
+		v = d.Waking()
+	case "error":
+		if d.err != nil {
+			v = d.err.Error()
+		}
+	default:
+		return nil, &NotFoundError{Path: path}
+	}
+
+	b, err := json.Marshal(v)
+	return (*json.RawMessage)(&b), err
+}
+
+func (d *powerDisplay) UnmarshalJSON(b []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if err := unmarshalKey(m, "energy", d.energy); err != nil {
+		return err
+	}
+
+	if p, ok := m["powerStatus"]; ok {
+		var ps string
+		if ps, ok = p.(string); !ok {
+			return fmt.Errorf("display powerStatus must be a string")
+		}
+
+		if ps != d.PowerStatus() {
+			switch ps {
+			case "on":
+				d.PowerOn()
+			case "standby":
+				d.Standby()
+			default:
+				return fmt.Errorf("display powerStatus must be 'on' or 'standby'")
+			}
+		}
+	}
+
+	if err := unmarshalBrightness(d, m); err != nil {
+		return err
+	}
+
+	if err := unmarshalKey(m, "policy", d.Policy()); err != nil {
+		return err
+	}
+
+	if s, ok := m["sleep"]; ok {
+		var ss string
+		if ss, ok = s.(string); !ok {
+			return fmt.Errorf("display sleep must be a string")
+		}
+
+		if err := d.Sleep(ss); err != nil {
+			return err
+		}
+	}
+
+	if w, ok := m["wake"]; ok {
+		var ws string
+		if ws, ok = w.(string); !ok {
+			return fmt.Errorf("display wake must be a string")
+		}
+
+		if err := d.Wake(ws); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *powerDisplay) MarshalJSON() ([]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	r := make(map[string]interface{})
+	r["powerStatus"] = d.powerStatus
+	r["backend"] = d.controller.Name()
+	r["brightness"] = d.brightness
+	r["dim"] = d.dim()
+	r["sleeping"] = d.Sleeping()
+	r["waking"] = d.Waking()
+	r["policy"] = d.powerPolicy
+	r["energy"] = d.energy
+	if d.err != nil {
+		r["error"] = d.err.Error()
+	}
+	return json.Marshal(r)
+}
+
+func (d *powerDisplay) SetPower(on bool, reason string) {
+	status := "standby"
+	if on {
+		status = "on"
+	}
+
+	// the controller may run a command that takes a while, only power
+	// changes wait for it so they reach the controller in order
+	d.powerLock.Lock()
+	defer d.powerLock.Unlock()
+	err := d.controller.SetPower(on)
+
+	d.lock.Lock()
+	d.err = err
+	if d.err != nil {
+		log.Printf("error turning %s %s: %v", d.controller.Name(), status, d.err)
+	} else {
+		d.powerStatus = status
+	}
+	status = d.powerStatus
+	d.lock.Unlock()
+	d.history.Record(status, reason, "")
+	d.notify()
+}
+
+func (d *powerDisplay) PowerOn() {
+	d.SetPower(true, reasonAPI)
+}
+
+func (d *powerDisplay) Standby() {
+	d.SetPower(false, reasonAPI)
+}
+
+func (d *powerDisplay) SetBrightness(percent int) error {
+	if err := validBrightness(percent); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	if d.dimmer != nil {
+		if err := d.dimmer.SetBrightness(percent); err != nil {
+			d.lock.Unlock()
+			return err
+		}
+	}
+	modified := d.brightness != percent
+	d.brightness = percent
+	d.lock.Unlock()
+
+	if modified {
+		d.notify()
+	}
+	return nil
+}
+
+func (d *powerDisplay) Brightness() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.brightness
+}
+
+// dim is the client overlay's opacity, the lock must be held
+func (d *powerDisplay) dim() float64 {
+	if d.dimmer != nil {
+		return 0
+	}
+	return dimOverlay(d.brightness)
+}
+
+func (d *powerDisplay) VolumeUp()        {}
+func (d *powerDisplay) VolumeDown()      {}
+func (d *powerDisplay) Mute()            {}
+func (d *powerDisplay) KeyPress(key int) {}
+func (d *powerDisplay) KeyRelease()      {}
+func (d *powerDisplay) Key(key int)      {}
+
+func (d *powerDisplay) Keys() <-chan string {
+	return nil
+}
+
+func (d *powerDisplay) OSDName() string {
+	return d.controller.Name()
+}
+
+// IsActiveSource is always true, without CEC the mirror can't tell which
+// input the display shows
+func (d *powerDisplay) IsActiveSource() bool {
+	return true
+}
+
+func (d *powerDisplay) LastActiveSource() bool {
+	return true
+}
+
+func (d *powerDisplay) SetActiveSource() error {
+	return nil
+}
+
+func (d *powerDisplay) InactiveSource() error {
+	return fmt.Errorf("%s display can't switch inputs", d.controller.Name())
+}
+
+func (d *powerDisplay) SelectInput(physicalAddress string) error {
+	return fmt.Errorf("%s display can't switch inputs", d.controller.Name())
+}
+
+func (d *powerDisplay) VendorID() uint64 {
+	return 0
+}
+
+func (d *powerDisplay) PhysicalAddress() string {
+	return ""
+}
+
+// PowerStatus asks the controller, so changes made outside the mirror are
+// noticed
+func (d *powerDisplay) PowerStatus() string {
+	p, err := d.queryPower()
+
+	d.lock.Lock()
+	if err != nil {
+		d.err = err
+		p = d.powerStatus
+		d.lock.Unlock()
+		return p
+	}
+
+	modified := p != d.powerStatus
+	d.powerStatus = p
+	d.lock.Unlock()
+
+	if modified {
+		d.history.Record(p, reasonRemote, "poll")
+		d.notify()
+	}
+	return p
+}
+
+func (d *powerDisplay) Energy() *energyMeter {
+	return d.energy
+}
+
+func (d *powerDisplay) History() *powerHistory {
+	return d.history
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// why the display's power changed
+const (
+	reasonMotion   = "motion"
+	reasonSchedule = "schedule"
+	reasonAPI      = "api"
+	reasonRemote   = "remote"
+	reasonAlert    = "alert"
+)
+
+const (
+	powerHistorySize = 200
+	powerHistoryDays = 14
+)
+
+type powerEvent struct {
+	Time        time.Time `json:"time"`
+	PowerStatus string    `json:"powerStatus"`
+	Reason      string    `json:"reason"`
+	Detail      string    `json:"detail,omitempty"`
+}
+
+// powerHistory keeps the last power changes in a ring buffer and totals how
+// long the display spent in each power status each day
+type powerHistory struct {
+	events []powerEvent
+	next   int
+	status string
+	since  time.Time
+	daily  map[string]map[string]time.Duration
+	lock   *sync.Mutex
+}
+
+func newPowerHistory(status string) *powerHistory {
+	h := &powerHistory{
+		events: make([]powerEvent, 0, powerHistorySize),
+		daily:  make(map[string]map[string]time.Duration),
+		lock:   &sync.Mutex{},
+	}
+	h.record(time.Now(), status, "", "initial")
+	return h
+}
+
+// Record adds an event if status differs from the last one and returns true
+// if it did
+func (h *powerHistory) Record(status string, reason string, detail string) bool {
+	return h.record(time.Now(), status, reason, detail)
+}
+
+func (h *powerHistory) record(t time.Time, status string, reason string, detail string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if status == h.status || status == "" {
+		return false
+	}
+
+	if h.status != "" {
+		addStatusTime(h.daily, h.status, h.since, t)
+	}
+	h.status = status
+	h.since = t
+
+	ev := powerEvent{Time: t, PowerStatus: status, Reason: reason, Detail: detail}
+	if len(h.events) < powerHistorySize {
+		h.events = append(h.events, ev)
+	} else {
+		h.events[h.next] = ev
+	}
+	h.next = (h.next + 1) % powerHistorySize
+	return true
+}
+
+// addStatusTime splits from-to at midnights into daily totals for status
+// and forgets old days
+func addStatusTime(daily map[string]map[string]time.Duration, status string, from time.Time, to time.Time) {
+	for from.Before(to) {
+		y, m, d := from.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, from.Location())
+		end := to
+		if midnight.Before(end) {
+			end = midnight
+		}
+		day := from.Format("2006-01-02")
+		if daily[day] == nil {
+			daily[day] = make(map[string]time.Duration)
+		}
+		daily[day][status] += end.Sub(from)
+		from = end
+	}
+
+	oldest := to.AddDate(0, 0, -powerHistoryDays).Format("2006-01-02")
+	for day := range daily {
+		if day < oldest {
+			delete(daily, day)
+		}
+	}
+}
+
+// Events returns the history oldest first
+func (h *powerHistory) Events() []powerEvent {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ret := make([]powerEvent, 0, len(h.events))
+	if len(h.events) == powerHistorySize {
+		ret = append(ret, h.events[h.next:]...)
+		ret = append(ret, h.events[:h.next]...)
+	} else {
+		ret = append(ret, h.events...)
+	}
+	return ret
+}
+
+// Daily returns the time spent in each status by day, including the
+// current status up to now
+func (h *powerHistory) Daily(now time.Time) map[string]map[string]time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ret := make(map[string]map[string]time.Duration)
+	for day, statuses := range h.daily {
+		ret[day] = make(map[string]time.Duration)
+		for status, d := range statuses {
+			ret[day][status] = d
+		}
+	}
+	if h.status != "" {
+		addStatusTime(ret, h.status, h.since, now)
+	}
+	return ret
+}
+
+// restore adds persisted totals to a day
+func (h *powerHistory) restore(day string, statuses map[string]time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.daily[day] == nil {
+		h.daily[day] = make(map[string]time.Duration)
+	}
+	for status, d := range statuses {
+		h.daily[day][status] += d
+	}
+}
+
+type dailyOnTime struct {
+	Date  string  `json:"date"`
+	On    string  `json:"on"`
+	Hours float64 `json:"hours"`
+}
+
+func (h *powerHistory) MarshalJSON() ([]byte, error) {
+	events := h.Events()
+	daily := h.Daily(time.Now())
+
+	days := make([]dailyOnTime, 0, len(daily))
+	for day := time.Now().AddDate(0, 0, -powerHistoryDays); !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		if statuses, ok := daily[day.Format("2006-01-02")]; ok {
+			d := statuses["on"]
+			days = append(days, dailyOnTime{day.Format("2006-01-02"), d.Round(time.Second).String(), d.Hours()})
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"events": events,
+		"daily":  days,
+	})
+}
+
+// reconcilePowerThread polls the display so changes made outside the
+// mirror, like the TV's own remote, are noticed and recorded
+func reconcilePowerThread(d Display, interval time.Duration) {
+	for range time.Tick(interval) {
+		d.PowerStatus()
+	}
+}
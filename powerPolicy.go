@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// power policy states, awake and asleep are forced until a deadline and then
+// return to motion or scheduled, whichever the schedule last asked for
+const (
+	policyMotion    = "motion"
+	policyScheduled = "scheduled"
+	policyAwake     = "awake"
+	policyAsleep    = "asleep"
+)
+
+// powerPolicy decides who controls a display's power: motion, a schedule
+// window, or a forced sleep or wake until a deadline.  Every display embeds
+// one, the deadline is persisted with the display so it survives a restart.
+type powerPolicy struct {
+	state    string
+	base     string
+	until    time.Time
+	timer    *time.Timer
+	onPower  func(on bool, reason string)
+	onChange func()
+	lock     *sync.Mutex
+}
+
+// newPowerPolicy starts motion activated, onPower is called when a forced
+// state begins and onChange after every transition
+func newPowerPolicy(onPower func(on bool, reason string), onChange func()) *powerPolicy {
+	return &powerPolicy{
+		state:    policyMotion,
+		base:     policyMotion,
+		onPower:  onPower,
+		onChange: onChange,
+		lock:     &sync.Mutex{},
+	}
+}
+
+func forcedPolicy(state string) bool {
+	return state == policyAwake || state == policyAsleep
+}
+
+// transition moves to state, a forced state expires at until.  It returns
+// false if nothing changed.
+func (p *powerPolicy) transition(state string, until time.Time) bool {
+	p.lock.Lock()
+	if !forcedPolicy(state) {
+		until = time.Time{}
+	}
+	if state == p.state && until.Equal(p.until) {
+		p.lock.Unlock()
+		return false
+	}
+
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.state = state
+	p.until = until
+	if forcedPolicy(state) {
+		p.timer = time.AfterFunc(time.Until(until), p.expire)
+	}
+	p.lock.Unlock()
+
+	p.onChange()
+	return true
+}
+
+// expire ends a forced state once its deadline has passed
+func (p *powerPolicy) expire() {
+	p.lock.Lock()
+	if !forcedPolicy(p.state) || time.Now().Before(p.until) {
+		// the state was changed after the timer fired
+		p.lock.Unlock()
+		return
+	}
+	p.state = p.base
+	p.until = time.Time{}
+	p.timer = nil
+	p.lock.Unlock()
+
+	p.onChange()
+}
+
+// Force keeps the display awake or asleep until the deadline regardless of
+// motion or the schedule
+func (p *powerPolicy) Force(state string, until time.Time) error {
+	return p.force(state, until, reasonAPI)
+}
+
+// force is Force with reason recorded as what changed the power
+func (p *powerPolicy) force(state string, until time.Time, reason string) error {
+	if !forcedPolicy(state) {
+		return fmt.Errorf("display can only be forced awake or asleep")
+	} else if !until.After(time.Now()) {
+		return fmt.Errorf("display %s deadline must be in the future", state)
+	}
+
+	p.transition(state, until)
+	p.onPower(state == policyAwake, reason)
+	return nil
+}
+
+// Release ends a forced state early
+func (p *powerPolicy) Release() {
+	p.lock.Lock()
+	base := p.base
+	p.lock.Unlock()
+
+	p.transition(base, time.Time{})
+}
+
+// SetScheduled is called by the scheduler, true when a schedule window
+// decides the power and false when motion does
+func (p *powerPolicy) SetScheduled(scheduled bool) bool {
+	base := policyMotion
+	if scheduled {
+		base = policyScheduled
+	}
+
+	p.lock.Lock()
+	p.base = base
+	forced := forcedPolicy(p.state)
+	p.lock.Unlock()
+
+	if forced {
+		return false
+	}
+	return p.transition(base, time.Time{})
+}
+
+func (p *powerPolicy) Sleep(duration string) error {
+	dur, err := time.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	return p.Force(policyAsleep, time.Now().Add(dur))
+}
+
+func (p *powerPolicy) Wake(duration string) error {
+	dur, err := time.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	return p.Force(policyAwake, time.Now().Add(dur))
+}
+
+func (p *powerPolicy) Policy() *powerPolicy {
+	return p
+}
+
+func (p *powerPolicy) State() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state
+}
+
+// Forced returns true while a sleep or wake overrides motion and the schedule
+func (p *powerPolicy) Forced() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return forcedPolicy(p.state)
+}
+
+func (p *powerPolicy) MotionActivated() bool {
+	return p.State() == policyMotion
+}
+
+func (p *powerPolicy) Sleeping() bool {
+	return p.State() == policyAsleep
+}
+
+func (p *powerPolicy) Waking() bool {
+	return p.State() == policyAwake
+}
+
+func (p *powerPolicy) SleepingUntil() time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.state != policyAsleep {
+		return time.Time{}
+	}
+	return p.until
+}
+
+func (p *powerPolicy) WakingUntil() time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.state != policyAwake {
+		return time.Time{}
+	}
+	return p.until
+}
+
+func (p *powerPolicy) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		if len(path) > 0 {
+			return nil, &NotFoundError{Path: path}
+		}
+		if err := json.Unmarshal(*msg, p); err != nil {
+			return nil, err
+		}
+	}
+	return serveValuePath(p, path)
+}
+
+func (p *powerPolicy) MarshalJSON() ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	r := map[string]interface{}{
+		"state": p.state,
+	}
+	if forcedPolicy(p.state) {
+		r["until"] = p.until.Format(time.RFC3339)
+	}
+	return json.Marshal(r)
+}
+
+// UnmarshalJSON transitions to the posted state.  Awake and asleep need an
+// until time or a for duration, a persisted deadline that has passed while
+// the mirror was down releases the display instead.  Motion and scheduled
+// release a forced state, which of the two applies is up to the schedule.
+func (p *powerPolicy) UnmarshalJSON(b []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	state, ok := m["state"].(string)
+	if !ok {
+		return fmt.Errorf("display policy state must be a string")
+	}
+
+	switch state {
+	case policyMotion, policyScheduled:
+		p.Release()
+		return nil
+	case policyAwake, policyAsleep:
+	default:
+		return fmt.Errorf("display policy state must be one of motion, scheduled, awake or asleep")
+	}
+
+	var until time.Time
+	if u, ok := m["until"]; ok {
+		us, ok := u.(string)
+		if !ok {
+			return fmt.Errorf("display policy until must be a string")
+		}
+		var err error
+		if until, err = time.Parse(time.RFC3339, us); err != nil {
+			return err
+		}
+	} else if f, ok := m["for"]; ok {
+		fs, ok := f.(string)
+		if !ok {
+			return fmt.Errorf("display policy for must be a duration string")
+		}
+		dur, err := time.ParseDuration(fs)
+		if err != nil {
+			return err
+		}
+		until = time.Now().Add(dur)
+	} else {
+		return fmt.Errorf("display policy %s needs an until time or a for duration", state)
+	}
+
+	if !until.After(time.Now()) {
+		p.Release()
+		return nil
+	}
+	return p.Force(state, until)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPolicy records the policy's power calls and counts its changes
+type testPolicy struct {
+	*powerPolicy
+	power   []string
+	changes int
+	changed chan bool
+	lock    *sync.Mutex
+}
+
+func newTestPolicy() *testPolicy {
+	t := &testPolicy{
+		changed: make(chan bool, 1),
+		lock:    &sync.Mutex{},
+	}
+	t.powerPolicy = newPowerPolicy(func(on bool, reason string) {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.power = append(t.power, fmt.Sprintf("%v %s", on, reason))
+	}, func() {
+		t.lock.Lock()
+		t.changes++
+		t.lock.Unlock()
+		select {
+		case t.changed <- true:
+		default:
+		}
+	})
+	return t
+}
+
+func (t *testPolicy) recorded() ([]string, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.power...), t.changes
+}
+
+func TestPowerPolicyTransitions(t *testing.T) {
+	hour := time.Now().Add(time.Hour).Truncate(time.Second)
+	post := func(s string) func(p *powerPolicy) error {
+		return func(p *powerPolicy) error {
+			msg := json.RawMessage(s)
+			_, err := p.ServeJSON(nil, &msg)
+			return err
+		}
+	}
+	sleep := func(p *powerPolicy) error { return p.Sleep("1h") }
+	wake := func(p *powerPolicy) error { return p.Wake("1h") }
+	release := func(p *powerPolicy) error { p.Release(); return nil }
+	scheduled := func(p *powerPolicy) error { p.SetScheduled(true); return nil }
+	unscheduled := func(p *powerPolicy) error { p.SetScheduled(false); return nil }
+
+	tests := []struct {
+		name    string
+		steps   []func(p *powerPolicy) error
+		state   string
+		until   time.Time
+		power   []string
+		changes int
+		err     bool
+	}{
+		{name: "starts motion activated", state: policyMotion},
+		{name: "schedule window", steps: []func(*powerPolicy) error{scheduled}, state: policyScheduled, changes: 1},
+		{name: "schedule window ends", steps: []func(*powerPolicy) error{scheduled, unscheduled}, state: policyMotion, changes: 2},
+		{name: "schedule unchanged", steps: []func(*powerPolicy) error{scheduled, scheduled}, state: policyScheduled, changes: 1},
+		{name: "sleep", steps: []func(*powerPolicy) error{sleep}, state: policyAsleep, power: []string{"false api"}, changes: 1},
+		{name: "wake", steps: []func(*powerPolicy) error{wake}, state: policyAwake, power: []string{"true api"}, changes: 1},
+		{name: "wake overrides sleep", steps: []func(*powerPolicy) error{sleep, wake}, state: policyAwake, power: []string{"false api", "true api"}, changes: 2},
+		{name: "release returns to motion", steps: []func(*powerPolicy) error{sleep, release}, state: policyMotion, power: []string{"false api"}, changes: 2},
+		{name: "release returns to the schedule", steps: []func(*powerPolicy) error{scheduled, wake, release}, state: policyScheduled, power: []string{"true api"}, changes: 3},
+		{name: "schedule waits for a forced state", steps: []func(*powerPolicy) error{sleep, scheduled}, state: policyAsleep, power: []string{"false api"}, changes: 1},
+		{name: "release after the schedule changed", steps: []func(*powerPolicy) error{sleep, scheduled, release}, state: policyScheduled, power: []string{"false api"}, changes: 2},
+		{name: "release without a forced state", steps: []func(*powerPolicy) error{release}, state: policyMotion},
+		{name: "bad duration", steps: []func(*powerPolicy) error{func(p *powerPolicy) error { return p.Sleep("soon") }}, state: policyMotion, err: true},
+		{name: "past deadline", steps: []func(*powerPolicy) error{func(p *powerPolicy) error { return p.Force(policyAsleep, time.Now().Add(-time.Minute)) }}, state: policyMotion, err: true},
+		{name: "force motion", steps: []func(*powerPolicy) error{func(p *powerPolicy) error { return p.Force(policyMotion, hour) }}, state: policyMotion, err: true},
+		{name: "post until", steps: []func(*powerPolicy) error{post(`{"state":"asleep","until":"` + hour.Format(time.RFC3339) + `"}`)}, state: policyAsleep, until: hour, power: []string{"false api"}, changes: 1},
+		{name: "post for", steps: []func(*powerPolicy) error{post(`{"state":"awake","for":"1h"}`)}, state: policyAwake, power: []string{"true api"}, changes: 1},
+		{name: "post motion releases", steps: []func(*powerPolicy) error{wake, post(`{"state":"motion"}`)}, state: policyMotion, power: []string{"true api"}, changes: 2},
+		{name: "post scheduled releases", steps: []func(*powerPolicy) error{scheduled, sleep, post(`{"state":"motion"}`)}, state: policyScheduled, power: []string{"false api"}, changes: 3},
+		{name: "post passed deadline releases", steps: []func(*powerPolicy) error{wake, post(`{"state":"asleep","until":"2000-01-01T00:00:00Z"}`)}, state: policyMotion, power: []string{"true api"}, changes: 2},
+		{name: "post without a deadline", steps: []func(*powerPolicy) error{post(`{"state":"asleep"}`)}, state: policyMotion, err: true},
+		{name: "post unknown state", steps: []func(*powerPolicy) error{post(`{"state":"off"}`)}, state: policyMotion, err: true},
+		{name: "post bad for", steps: []func(*powerPolicy) error{post(`{"state":"awake","for":60}`)}, state: policyMotion, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPolicy()
+			defer p.Release()
+
+			var err error
+			for _, step := range test.steps {
+				if e := step(p.powerPolicy); e != nil {
+					err = e
+				}
+			}
+			if test.err && err == nil {
+				t.Errorf("expected an error")
+			} else if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if s := p.State(); s != test.state {
+				t.Errorf("state %s, expected %s", s, test.state)
+			}
+			if forced := p.Forced(); forced != forcedPolicy(test.state) {
+				t.Errorf("forced %v in %s", forced, test.state)
+			}
+			if !test.until.IsZero() {
+				if u := p.SleepingUntil(); !u.Equal(test.until) {
+					t.Errorf("sleeping until %v, expected %v", u, test.until)
+				}
+			}
+			power, changes := p.recorded()
+			if fmt.Sprint(power) != fmt.Sprint(test.power) {
+				t.Errorf("power calls %v, expected %v", power, test.power)
+			}
+			if changes != test.changes {
+				t.Errorf("%d changes, expected %d", changes, test.changes)
+			}
+		})
+	}
+}
+
+func TestPowerPolicyExpires(t *testing.T) {
+	for _, base := range []string{policyMotion, policyScheduled} {
+		p := newTestPolicy()
+		p.SetScheduled(base == policyScheduled)
+		select {
+		case <-p.changed:
+		default:
+		}
+
+		if err := p.Force(policyAwake, time.Now().Add(50*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		<-p.changed
+		if !p.Waking() || p.WakingUntil().IsZero() {
+			t.Fatalf("policy %s isn't awake", p.State())
+		}
+
+		select {
+		case <-p.changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("forced wake never expired")
+		}
+		if s := p.State(); s != base {
+			t.Errorf("expired to %s, expected %s", s, base)
+		}
+		if !p.WakingUntil().IsZero() {
+			t.Errorf("still waking until %v", p.WakingUntil())
+		}
+	}
+}
+
+func TestPowerPolicyMarshal(t *testing.T) {
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	p := newTestPolicy()
+	defer p.Release()
+	if err := p.Force(policyAsleep, until); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(p.powerPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart restores the deadline
+	restored := newTestPolicy()
+	defer restored.Release()
+	if err := json.Unmarshal(b, restored.powerPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Sleeping() || !restored.SleepingUntil().Equal(until) {
+		t.Errorf("restored %s until %v from %s", restored.State(), restored.SleepingUntil(), b)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"io"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -19,6 +20,15 @@ type MotionProcessor struct {
 	magnitude int
 	total     int
 	throttle  time.Duration
+	monitor   *motionMonitor   // regions and baseline, nil counts the whole frame at a fixed magnitude
+	clock     func() time.Time // a replay's frame clock, nil for the wall clock
+}
+
+func (proc MotionProcessor) now() time.Time {
+	if proc.clock != nil {
+		return proc.clock()
+	}
+	return time.Now()
 }
 
 func (proc MotionProcessor) Process(reader io.Reader) <-chan time.Time {
@@ -30,42 +40,67 @@ func (proc MotionProcessor) Process(reader io.Reader) <-chan time.Time {
 }
 
 func (proc MotionProcessor) thread(reader io.Reader, motionDetected chan<- time.Time) {
-	len := (proc.mbx + 1) * proc.mby
-	vect := make([]motionVector, len)
+	width := proc.mbx + 1
+	vect := make([]motionVector, width*proc.mby)
+	active := make([]bool, len(vect))
+	mask := newMotionMask(nil, width, proc.mby)
 
 	mag2 := proc.magnitude * proc.magnitude
 
-	last := time.Now()
+	last := proc.now()
+	frames, detections := 0, 0
 
-	defer close(motionDetected)
+	// pending sends finish before the channel closes
+	sending := &sync.WaitGroup{}
+	defer func() {
+		sending.Wait()
+		close(motionDetected)
+	}()
 
 	for {
 		if err := binary.Read(reader, binary.LittleEndian, vect); err != nil {
 			log.Println(err)
 			break
-		} else if time.Now().Sub(last) < proc.throttle {
+		}
+		frames++
+		if proc.now().Sub(last) < proc.throttle {
 			continue
 		}
 
-		last = time.Now()
+		last = proc.now()
 
-		c := 0
-		for _, v := range vect {
-			magU := int(v.X)*int(v.X) + int(v.Y)*int(v.Y)
-			if magU > mag2 {
-				c++
+		if proc.monitor != nil {
+			if proc.monitor.Baseline().Observe(last, vect, width, proc.mby, mag2, active) {
+				// calibrating, everything seen is background
+				continue
+			}
+			mask = proc.monitor.Mask(width, proc.mby)
+		} else {
+			for i, v := range vect {
+				magU := int(v.X)*int(v.X) + int(v.Y)*int(v.Y)
+				active[i] = magU > mag2
 			}
 		}
+		c, region := mask.detect(active, float64(proc.total))
+		wake := region != ""
+		if proc.monitor != nil {
+			wake = proc.monitor.Observed(last, vect, active, width, proc.mby, c, region)
+		}
 
-		// log.Printf("total motion vectors above magnitude: %d", c)
+		// log.Printf("total motion vectors above magnitude: %.1f", c)
 
-		if c > proc.total {
+		if region != "" && !wake {
+			log.Printf("motion ignored by the wake policy: %.1f (%s)", c, region)
+		} else if wake {
 			// don't get hung up here-- better to process all the vectors in this loop than wait for a full channel
-			log.Printf("motion detected: %d", c)
-			go func() {
-				motionDetected <- last
-			}()
+			log.Printf("motion detected: %.1f (%s)", c, region)
+			detections++
+			sending.Add(1)
+			go func(t time.Time) {
+				defer sending.Done()
+				motionDetected <- t
+			}(last)
 		}
 	}
-	log.Printf("finishing motion detect")
+	log.Printf("finishing motion detect after %d frames with %d detections", frames, detections)
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// responseCache keeps the last good response for each url on disk so
+// requests can be made conditionally and a forecast can be shown after a
+// restart without a network connection.
+type responseCache struct {
+	dir  string
+	lock *sync.Mutex
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+	ContentType  string    `json:"contentType,omitempty"`
+	Body         []byte    `json:"body"`
+}
+
+func newResponseCache(dir string) (*responseCache, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return nil, err
+	}
+	return &responseCache{
+		dir:  dir,
+		lock: &sync.Mutex{},
+	}, nil
+}
+
+func (c *responseCache) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *responseCache) load(url string) *cacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	b, err := ioutil.ReadFile(c.path(url))
+	if err != nil {
+		return nil
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(b, entry); err != nil || entry.URL != url {
+		return nil
+	}
+	return entry
+}
+
+func (c *responseCache) save(entry *cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// write to a temporary file first so a crash can't leave half an entry
+	tmp := c.path(entry.URL) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0660); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(entry.URL))
+}
+
+func (entry *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {entry.ContentType}},
+		Body:          ioutil.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}
+}
+
+// Transport wraps base so that GET requests are made conditionally and
+// successful responses are saved
+func (c *responseCache) Transport(base http.RoundTripper) http.RoundTripper {
+	return &cachingTransport{cache: c, base: base}
+}
+
+// OfflineTransport serves only from the cache, it records the oldest entry
+// it has served so callers know how old the result is.
+func (c *responseCache) OfflineTransport() *offlineTransport {
+	return &offlineTransport{cache: c}
+}
+
+type cachingTransport struct {
+	cache *responseCache
+	base  http.RoundTripper
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	url := req.URL.String()
+	entry := t.cache.load(url)
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && entry != nil:
+		res.Body.Close()
+		entry.Fetched = time.Now()
+		if err := t.cache.save(entry); err != nil {
+			log.Printf("error caching %s: %v", url, err)
+		}
+		return entry.response(req), nil
+	case res.StatusCode == http.StatusOK:
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+		entry = &cacheEntry{
+			URL:          url,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+			Fetched:      time.Now(),
+			ContentType:  res.Header.Get("Content-Type"),
+			Body:         b,
+		}
+		if err := t.cache.save(entry); err != nil {
+			log.Printf("error caching %s: %v", url, err)
+		}
+	}
+	return res, nil
+}
+
+type offlineTransport struct {
+	cache  *responseCache
+	oldest time.Time
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := t.cache.load(req.URL.String())
+	if entry == nil {
+		return nil, fmt.Errorf("%s is not cached", req.URL)
+	}
+	if t.oldest.IsZero() || entry.Fetched.Before(t.oldest) {
+		t.oldest = entry.Fetched
+	}
+	return entry.response(req), nil
+}
+
+// Oldest returns when the oldest response served was fetched
+func (t *offlineTransport) Oldest() time.Time {
+	return t.oldest
+}
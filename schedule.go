@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schedule modes, in motion mode the display turns on when motion is detected
+// and goes to standby after the motion timeout
+const (
+	scheduleOn     = "on"
+	scheduleSleep  = "sleep"
+	scheduleMotion = "motion"
+)
+
+// Schedule is a weekly list of windows, the first window containing a time
+// decides the mode and outside every window the default applies.  For
+// example "weekdays 06:00-08:30 on" followed by "daily 23:00-06:00 sleep".
+// Brightness is an optional dimming curve over the day.  With ReclaimInput
+// motion switches the display back to the mirror if it was the last active
+// source.
+type Schedule struct {
+	Windows       []ScheduleWindow  `json:"windows"`
+	Default       string            `json:"default"`
+	Brightness    []BrightnessPoint `json:"brightness"`
+	ReclaimInput  bool              `json:"reclaimInput"`
+	MotionTimeout time.Duration     `json:"-"`
+	CheckInterval time.Duration     `json:"-"`
+}
+
+// ScheduleWindow applies mode from start to end on its days, a window that
+// ends before it starts runs past midnight into the next day
+type ScheduleWindow struct {
+	Days  string    `json:"days"`
+	Start clockTime `json:"start"`
+	End   clockTime `json:"end"`
+	Mode  string    `json:"mode"`
+	days  [7]bool
+}
+
+// clockTime is a time of day in minutes after midnight, "HH:MM" in json
+type clockTime int
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func validScheduleMode(mode string) error {
+	switch mode {
+	case scheduleOn, scheduleSleep, scheduleMotion:
+		return nil
+	}
+	return fmt.Errorf("schedule mode must be one of on, sleep or motion")
+}
+
+func parseClockTime(s string) (clockTime, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("schedule time '%s' must be HH:MM", s)
+	}
+	return clockTime(t.Hour()*60 + t.Minute()), nil
+}
+
+func (c clockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", int(c)/60, int(c)%60)
+}
+
+func (c clockTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *clockTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	t, err := parseClockTime(s)
+	*c = t
+	return err
+}
+
+// parseDays accepts a comma separated list of daily, weekdays, weekends, day
+// names (mon, tue...) and ranges of day names like mon-thu
+func parseDays(s string) (days [7]bool, err error) {
+	for _, tok := range strings.Split(strings.ToLower(s), ",") {
+		tok = strings.TrimSpace(tok)
+		switch tok {
+		case "daily", "everyday", "all":
+			for i := range days {
+				days[i] = true
+			}
+			continue
+		case "weekdays":
+			for d := time.Monday; d <= time.Friday; d++ {
+				days[d] = true
+			}
+			continue
+		case "weekends":
+			days[time.Saturday] = true
+			days[time.Sunday] = true
+			continue
+		}
+
+		r := strings.SplitN(tok, "-", 2)
+		from, ok := weekdayNames[r[0]]
+		if !ok {
+			return days, fmt.Errorf("schedule days '%s' not understood", tok)
+		}
+		to := from
+		if len(r) > 1 {
+			if to, ok = weekdayNames[r[1]]; !ok {
+				return days, fmt.Errorf("schedule days '%s' not understood", tok)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return
+}
+
+func (w *ScheduleWindow) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Days  string    `json:"days"`
+		Start clockTime `json:"start"`
+		End   clockTime `json:"end"`
+		Mode  string    `json:"mode"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	} else if err := validScheduleMode(v.Mode); err != nil {
+		return err
+	}
+
+	days, err := parseDays(v.Days)
+	if err != nil {
+		return err
+	}
+	*w = ScheduleWindow{Days: v.Days, Start: v.Start, End: v.End, Mode: v.Mode, days: days}
+	return nil
+}
+
+// contains returns true if t falls in the window
+func (w *ScheduleWindow) contains(t time.Time) bool {
+	tod := clockTime(t.Hour()*60 + t.Minute())
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+
+	if w.Start <= w.End {
+		return w.days[day] && tod >= w.Start && tod < w.End
+	}
+	// overnight windows belong to the day they start on
+	return (w.days[day] && tod >= w.Start) || (w.days[yesterday] && tod < w.End)
+}
+
+// ModeAt returns the mode at t in t's location
+func (s *Schedule) ModeAt(t time.Time) string {
+	for i := range s.Windows {
+		if s.Windows[i].contains(t) {
+			return s.Windows[i].Mode
+		}
+	}
+	return s.Default
+}
+
+func (s Schedule) MarshalJSON() ([]byte, error) {
+	windows := s.Windows
+	if windows == nil {
+		windows = []ScheduleWindow{}
+	}
+	brightness := s.Brightness
+	if brightness == nil {
+		brightness = []BrightnessPoint{}
+	}
+	return json.Marshal(map[string]interface{}{
+		"windows":       windows,
+		"brightness":    brightness,
+		"default":       s.Default,
+		"reclaimInput":  s.ReclaimInput,
+		"motionTimeout": s.MotionTimeout.String(),
+		"checkInterval": s.CheckInterval.String(),
+	})
+}
+
+// UnmarshalJSON only changes the fields present in b so a partial schedule
+// can be posted
+func (s *Schedule) UnmarshalJSON(b []byte) error {
+	m := make(map[string]*json.RawMessage)
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	ret := *s
+	if w := m["windows"]; w != nil {
+		ret.Windows = nil
+		if err := json.Unmarshal(*w, &ret.Windows); err != nil {
+			return err
+		}
+	}
+	if br := m["brightness"]; br != nil {
+		ret.Brightness = nil
+		if err := json.Unmarshal(*br, &ret.Brightness); err != nil {
+			return err
+		}
+		for _, p := range ret.Brightness {
+			if err := validBrightness(p.Brightness); err != nil {
+				return err
+			}
+		}
+		sort.Slice(ret.Brightness, func(i, j int) bool {
+			return ret.Brightness[i].Time < ret.Brightness[j].Time
+		})
+	}
+	if d := m["default"]; d != nil {
+		if err := json.Unmarshal(*d, &ret.Default); err != nil {
+			return err
+		} else if err := validScheduleMode(ret.Default); err != nil {
+			return err
+		}
+	}
+	if r := m["reclaimInput"]; r != nil {
+		if err := json.Unmarshal(*r, &ret.ReclaimInput); err != nil {
+			return fmt.Errorf("schedule reclaimInput must be a boolean")
+		}
+	}
+	for key, dur := range map[string]*time.Duration{
+		"motionTimeout": &ret.MotionTimeout,
+		"checkInterval": &ret.CheckInterval,
+	} {
+		if v := m[key]; v != nil {
+			var ds string
+			if err := json.Unmarshal(*v, &ds); err != nil {
+				return fmt.Errorf("schedule %s must be a duration string", key)
+			}
+			d, err := time.ParseDuration(ds)
+			if err != nil {
+				return err
+			} else if d <= 0 {
+				return fmt.Errorf("schedule %s must be positive", key)
+			}
+			*dur = d
+		}
+	}
+	*s = ret
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// clock is the scheduler's source of time so it can be driven by a fake
+// clock in tests
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// displayScheduler turns the display on and off following the weekly
+// schedule and motion.  One-shot Sleep and Wake on the display take priority
+// until they expire.
+type displayScheduler struct {
+	schedule   Schedule
+	display    Display
+	clock      clock
+	mode       string
+	lastMotion time.Time
+	sleepAt    time.Time
+	motion     chan time.Time
+	update     chan bool
+	changed    chan bool
+	lock       *sync.Mutex
+}
+
+func newDisplayScheduler(display Display, schedule Schedule, clock clock, changed chan bool) (s *displayScheduler) {
+	s = &displayScheduler{
+		schedule: schedule,
+		display:  display,
+		clock:    clock,
+		motion:   make(chan time.Time, 1),
+		update:   make(chan bool, 1),
+		changed:  changed,
+		lock:     &sync.Mutex{},
+	}
+	go s.scheduleThread()
+	return s
+}
+
+func (s *displayScheduler) scheduleThread() {
+	for {
+		s.lock.Lock()
+		interval := s.schedule.CheckInterval
+		s.lock.Unlock()
+		if interval <= 0 {
+			interval = time.Minute
+		}
+
+		select {
+		case t := <-s.motion:
+			s.evaluate(s.clock.Now(), t)
+		case <-s.update:
+			s.evaluate(s.clock.Now(), time.Time{})
+		case <-s.clock.After(interval):
+			s.evaluate(s.clock.Now(), time.Time{})
+		}
+	}
+}
+
+// evaluate applies the schedule at now, motion is the time motion was
+// detected or zero
+func (s *displayScheduler) evaluate(now time.Time, motion time.Time) {
+	s.lock.Lock()
+	mode := s.schedule.ModeAt(now)
+	brightness, dimming := brightnessAt(s.schedule.Brightness, now)
+	modified := mode != s.mode
+	if modified {
+		log.Printf("schedule mode changed from '%s' to '%s'", s.mode, mode)
+		if s.mode == scheduleOn && mode == scheduleMotion {
+			// leaving an always on window starts the motion timeout
+			// rather than turning the display off immediately
+			s.sleepAt = now.Add(s.schedule.MotionTimeout)
+		}
+	}
+	s.mode = mode
+	if !motion.IsZero() {
+		s.lastMotion = motion
+		if mode == scheduleMotion {
+			s.sleepAt = motion.Add(s.schedule.MotionTimeout)
+		}
+	}
+	sleepAt := s.sleepAt
+	reclaim := s.schedule.ReclaimInput
+	s.lock.Unlock()
+
+	if modified {
+		s.changed <- true
+	}
+
+	if dimming && s.display.Brightness() != brightness {
+		if err := s.display.SetBrightness(brightness); err != nil {
+			log.Printf("error setting brightness to %d: %v", brightness, err)
+		}
+	}
+
+	policy := s.display.Policy()
+	policy.SetScheduled(mode != scheduleMotion)
+	if policy.Forced() {
+		// a one-shot sleep or wake is in effect
+		return
+	}
+
+	status := s.display.PowerStatus()
+	switch mode {
+	case scheduleOn:
+		if status != "on" {
+			log.Printf("schedule turning display on")
+			s.display.SetPower(true, reasonSchedule)
+		}
+	case scheduleSleep:
+		if status != "standby" {
+			log.Printf("schedule putting display on standby")
+			s.display.SetPower(false, reasonSchedule)
+		}
+	case scheduleMotion:
+		if !motion.IsZero() && status != "on" {
+			log.Printf("motion detected at %v, turning display on", motion)
+			s.display.SetPower(true, reasonMotion)
+		} else if motion.IsZero() && status != "standby" && sleepAt.Before(now) {
+			log.Printf("no motion since %v, putting display on standby", s.LastMotion())
+			s.display.SetPower(false, reasonMotion)
+		}
+	}
+
+	if !motion.IsZero() && reclaim && mode != scheduleSleep {
+		s.reclaimInput()
+	}
+}
+
+// reclaimInput switches the display back to the mirror, unless another
+// device has claimed the input since the mirror last had it
+func (s *displayScheduler) reclaimInput() {
+	if !s.display.LastActiveSource() || s.display.IsActiveSource() {
+		return
+	}
+	log.Printf("motion detected, reclaiming the display's input")
+	if err := s.display.SetActiveSource(); err != nil {
+		log.Printf("error reclaiming the display's input: %v", err)
+	}
+}
+
+// Motion reports motion detected at t
+func (s *displayScheduler) Motion(t time.Time) {
+	select {
+	case s.motion <- t:
+	default:
+		// the scheduler is busy with the previous motion
+	}
+}
+
+func (s *displayScheduler) Schedule() Schedule {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedule
+}
+
+// SetSchedule replaces the schedule and re-evaluates it immediately
+func (s *displayScheduler) SetSchedule(schedule Schedule) {
+	s.lock.Lock()
+	s.schedule = schedule
+	s.lock.Unlock()
+
+	select {
+	case s.update <- true:
+	default:
+	}
+	s.changed <- true
+}
+
+func (s *displayScheduler) Mode() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.mode
+}
+
+func (s *displayScheduler) LastMotion() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastMotion
+}
+
+func (s *displayScheduler) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if msg != nil {
+		// the schedule's fields can be set individually by wrapping the
+		// message in its key
+		if len(path) == 1 {
+			switch path[0] {
+			case "windows", "default", "brightness", "reclaimInput", "motionTimeout", "checkInterval":
+			default:
+				return nil, &NotFoundError{Path: path}
+			}
+			b, err := json.Marshal(map[string]*json.RawMessage{path[0]: msg})
+			if err != nil {
+				return nil, err
+			}
+			msg = (*json.RawMessage)(&b)
+		} else if len(path) > 1 {
+			return nil, &NotFoundError{Path: path}
+		}
+		if err := json.Unmarshal(*msg, s); err != nil {
+			return nil, err
+		}
+	}
+	return serveValuePath(s, path)
+}
+
+func (s *displayScheduler) UnmarshalJSON(b []byte) error {
+	schedule := s.Schedule()
+	if err := json.Unmarshal(b, &schedule); err != nil {
+		return err
+	}
+	s.SetSchedule(schedule)
+	return nil
+}
+
+func (s *displayScheduler) MarshalJSON() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, err := json.Marshal(s.schedule)
+	if err != nil {
+		return nil, err
+	}
+	r := make(map[string]interface{})
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	r["mode"] = s.mode
+	r["lastMotion"] = s.lastMotion
+	r["sleepAt"] = s.sleepAt
+	return json.Marshal(r)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced.  Every call to After is signalled on
+// waiting so a test knows the scheduler has finished evaluating and is idle.
+type fakeClock struct {
+	now     time.Time
+	timers  []fakeTimer
+	waiting chan bool
+	lock    *sync.Mutex
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{
+		now:     now,
+		waiting: make(chan bool, 1),
+		lock:    &sync.Mutex{},
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	t := fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.lock.Unlock()
+
+	c.waiting <- true
+	return t.c
+}
+
+// Advance moves the clock on and fires the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// wait blocks until the scheduler is idle
+func (c *fakeClock) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.waiting:
+	case <-time.After(time.Second):
+		t.Fatalf("scheduler didn't wait on the clock at %v", c.Now())
+	}
+}
+
+func testSchedule(t *testing.T, s string) Schedule {
+	t.Helper()
+	var schedule Schedule
+	if err := json.Unmarshal([]byte(s), &schedule); err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func TestScheduleOvernightWindow(t *testing.T) {
+	schedule := testSchedule(t, `{
+		"windows": [
+			{"days": "fri", "start": "23:00", "end": "06:00", "mode": "sleep"},
+			{"days": "weekdays", "start": "06:00", "end": "08:30", "mode": "on"}
+		],
+		"default": "motion"
+	}`)
+
+	// June 21 2018 is a Thursday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2018, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		t    time.Time
+		mode string
+	}{
+		{at(21, 23, 30), scheduleMotion},
+		{at(22, 5, 0), scheduleMotion}, // thursday night isn't in the window
+		{at(22, 7, 0), scheduleOn},
+		{at(22, 8, 30), scheduleMotion},
+		{at(22, 22, 59), scheduleMotion},
+		{at(22, 23, 0), scheduleSleep},
+		{at(23, 0, 0), scheduleSleep},
+		{at(23, 5, 59), scheduleSleep}, // friday's window runs into saturday
+		{at(23, 6, 0), scheduleMotion}, // saturday isn't a weekday
+		{at(23, 23, 30), scheduleMotion},
+	} {
+		if mode := schedule.ModeAt(c.t); mode != c.mode {
+			t.Errorf("mode at %s = %s, want %s", c.t.Format("Mon 15:04"), mode, c.mode)
+		}
+	}
+}
+
+func TestSchedulerMotionTimeout(t *testing.T) {
+	schedule := testSchedule(t, `{
+		"windows": [
+			{"days": "weekdays", "start": "07:00", "end": "08:00", "mode": "on"},
+			{"days": "daily", "start": "23:00", "end": "06:00", "mode": "sleep"}
+		],
+		"default": "motion",
+		"motionTimeout": "10m",
+		"checkInterval": "1m"
+	}`)
+
+	display := NewDummyDisplay()
+	clock := newFakeClock(time.Date(2018, time.June, 22, 6, 58, 0, 0, time.UTC))
+	s := newDisplayScheduler(display, schedule, clock, make(chan bool, 100))
+	clock.wait(t)
+
+	until := func(hour, minute int) {
+		t.Helper()
+		end := time.Date(2018, time.June, 22, hour, minute, 0, 0, time.UTC)
+		for clock.Now().Before(end) {
+			clock.Advance(time.Minute)
+			clock.wait(t)
+		}
+	}
+	motion := func() {
+		t.Helper()
+		s.Motion(clock.Now())
+		clock.wait(t)
+	}
+	expect := func(mode string, status string, reason string) {
+		t.Helper()
+		events := display.History().Events()
+		last := events[len(events)-1]
+		if s.Mode() != mode || display.PowerStatus() != status || last.Reason != reason {
+			t.Errorf("at %s mode %s, display %s by %s, want %s, %s by %s", clock.Now().Format("15:04"),
+				s.Mode(), display.PowerStatus(), last.Reason, mode, status, reason)
+		}
+	}
+
+	until(6, 59)
+	expect(scheduleMotion, "standby", "")
+	until(7, 0)
+	expect(scheduleOn, "on", reasonSchedule)
+
+	// leaving the on window starts the motion timeout rather than turning
+	// the display off at once
+	until(8, 0)
+	expect(scheduleMotion, "on", reasonSchedule)
+	until(8, 10)
+	expect(scheduleMotion, "on", reasonSchedule)
+	until(8, 11)
+	expect(scheduleMotion, "standby", reasonMotion)
+
+	motion()
+	expect(scheduleMotion, "on", reasonMotion)
+	until(8, 20)
+	expect(scheduleMotion, "on", reasonMotion)
+	until(8, 22)
+	expect(scheduleMotion, "standby", reasonMotion)
+
+	// motion during the overnight sleep window doesn't wake the display
+	until(22, 55)
+	motion()
+	expect(scheduleMotion, "on", reasonMotion)
+	until(23, 0)
+	expect(scheduleSleep, "standby", reasonSchedule)
+	until(23, 30)
+	motion()
+	expect(scheduleSleep, "standby", reasonSchedule)
+	if !s.LastMotion().Equal(clock.Now()) {
+		t.Errorf("last motion = %v, want %v", s.LastMotion(), clock.Now())
+	}
+}
+
+func TestSchedulerKeepsAlertWake(t *testing.T) {
+	schedule := testSchedule(t, `{
+		"windows": [{"days": "daily", "start": "23:00", "end": "06:00", "mode": "sleep"}],
+		"default": "motion",
+		"checkInterval": "1m"
+	}`)
+
+	display := NewDummyDisplay()
+	clock := newFakeClock(time.Date(2018, time.June, 22, 23, 30, 0, 0, time.UTC))
+	s := newDisplayScheduler(display, schedule, clock, make(chan bool, 100))
+	clock.wait(t)
+	clock.Advance(time.Minute)
+	clock.wait(t)
+	if s.Mode() != scheduleSleep || display.PowerStatus() != "standby" {
+		t.Fatalf("mode %s with the display %s, want sleep and standby", s.Mode(), display.PowerStatus())
+	}
+
+	provider := &fakeAlertProvider{alerts: []Alert{
+		{ID: "1", Event: "Tornado Warning", Severity: "Extreme", Expires: time.Now().Add(time.Hour)},
+	}}
+	alerts := newAlertsElement(func(loc Location) AlertProvider { return provider }, Location{}, display, "Severe", make(chan bool, 10), time.Hour)
+	if err := alerts.fetchAlerts(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sleep window doesn't undo the alert's wake
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Minute)
+		clock.wait(t)
+	}
+	events := display.History().Events()
+	if last := events[len(events)-1]; display.PowerStatus() != "on" || last.Reason != reasonAlert {
+		t.Errorf("display %s by %s after the alert, want on by %s", display.PowerStatus(), last.Reason, reasonAlert)
+	}
+	if until := display.Policy().WakingUntil(); until.IsZero() {
+		t.Errorf("the alert didn't force the display awake")
+	}
+
+	// once the alert's wake ends the schedule applies again
+	display.Policy().Release()
+	clock.Advance(time.Minute)
+	clock.wait(t)
+	if display.PowerStatus() != "standby" {
+		t.Errorf("display %s after the alert's wake ended, want standby", display.PowerStatus())
+	}
+}
+
+func TestBrightnessAt(t *testing.T) {
+	curve := func(points ...string) []BrightnessPoint {
+		t.Helper()
+		var c []BrightnessPoint
+		if err := json.Unmarshal([]byte("["+strings.Join(points, ",")+"]"), &c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	dayAndNight := curve(`{"time": "07:00", "brightness": 100}`, `{"time": "22:00", "brightness": 20}`)
+
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2018, time.June, 22, hour, minute, second, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		name       string
+		curve      []BrightnessPoint
+		t          time.Time
+		brightness int
+		ok         bool
+	}{
+		{"empty", nil, at(12, 0, 0), 0, false},
+		{"on the first point", dayAndNight, at(7, 0, 0), 100, true},
+		{"on the last point", dayAndNight, at(22, 0, 0), 20, true},
+		{"between the points", dayAndNight, at(12, 0, 0), 73, true},
+		{"seconds count", dayAndNight, at(21, 59, 59), 20, true},
+		{"after the last point", dayAndNight, at(23, 0, 0), 29, true},
+		{"past midnight", dayAndNight, at(2, 30, 0), 60, true},
+		{"before the first point", dayAndNight, at(6, 0, 0), 91, true},
+		{"a single point", curve(`{"time": "12:00", "brightness": 50}`), at(3, 0, 0), 50, true},
+		{"a single point after it", curve(`{"time": "12:00", "brightness": 50}`), at(13, 0, 0), 50, true},
+		{"a point at midnight", curve(`{"time": "00:00", "brightness": 10}`, `{"time": "12:00", "brightness": 90}`), at(0, 0, 0), 10, true},
+		{"back towards midnight", curve(`{"time": "00:00", "brightness": 10}`, `{"time": "12:00", "brightness": 90}`), at(18, 0, 0), 50, true},
+		// two points at the same time step from one to the other
+		{"on a step", curve(`{"time": "20:00", "brightness": 80}`, `{"time": "20:00", "brightness": 10}`), at(20, 0, 0), 10, true},
+		{"before a step", curve(`{"time": "20:00", "brightness": 80}`, `{"time": "20:00", "brightness": 10}`), at(19, 0, 0), 77, true},
+		{"after a step", curve(`{"time": "20:00", "brightness": 80}`, `{"time": "20:00", "brightness": 10}`), at(20, 30, 0), 11, true},
+	} {
+		brightness, ok := brightnessAt(c.curve, c.t)
+		if brightness != c.brightness || ok != c.ok {
+			t.Errorf("%s: brightness at %s = %d, %v, want %d, %v", c.name, c.t.Format("15:04:05"), brightness, ok, c.brightness, c.ok)
+		}
+	}
+}
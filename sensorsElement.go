@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donniet/mirror2/mqtt"
+)
+
+const sensorHistory = 48
+
+// sensorReading is one report from an indoor sensor.  Temperatures are kept
+// in celsius and missing values are NaN.
+type sensorReading struct {
+	Time        time.Time
+	Temperature float64
+	Humidity    float64
+}
+
+type sensor struct {
+	name    string
+	latest  sensorReading
+	history []sensorReading
+	stale   bool
+}
+
+// sensorsElement shows the indoor sensors.  Settings changes are sent on
+// changed, new readings and sensors going stale on readingsChanged which
+// isn't persisted so every reading doesn't rewrite the persistence file.
+type sensorsElement struct {
+	visible         bool
+	units           string
+	timeout         time.Duration
+	sensors         map[string]*sensor
+	changed         chan bool
+	readingsChanged chan bool
+	lock            *sync.Mutex
+}
+
+func newSensorsElement(timeout time.Duration, changed chan bool) (e *sensorsElement) {
+	e = &sensorsElement{
+		visible:         false,
+		units:           "imperial",
+		timeout:         timeout,
+		sensors:         make(map[string]*sensor),
+		changed:         changed,
+		readingsChanged: make(chan bool, 1),
+		lock:            &sync.Mutex{},
+	}
+	go e.staleThread()
+	return e
+}
+
+func (e *sensorsElement) ServeJSON(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			// a reading naming its sensor can be posted to the element itself
+			var named struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(*msg, &named); err == nil && named.Name != "" {
+				if err := e.Record(named.Name, *msg); err != nil {
+					return nil, err
+				}
+			} else if err := json.Unmarshal(*msg, e); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(e)
+		return (*json.RawMessage)(&b), err
+	}
+
+	switch path[0] {
+	case "visible":
+		if msg != nil {
+			var vis bool
+			if err := json.Unmarshal(*msg, &vis); err != nil {
+				return nil, err
+			}
+			e.setVisible(vis)
+		}
+		return serveValuePath(e.Visible(), path[1:])
+	case "timeout":
+		return serveValuePath(e.Timeout().String(), path[1:])
+	}
+
+	// anything else is a sensor name, posting to it records a reading
+	if msg != nil && len(path) == 1 {
+		if err := e.Record(path[0], *msg); err != nil {
+			return nil, err
+		}
+	}
+
+	e.lock.Lock()
+	s, ok := e.sensors[path[0]]
+	var v map[string]interface{}
+	if ok {
+		v = e.sensorJSON(s)
+	}
+	e.lock.Unlock()
+
+	if !ok {
+		return nil, &NotFoundError{Path: path}
+	}
+	return serveValuePath(v, path[1:])
+}
+
+func (e *sensorsElement) UnmarshalJSON(b []byte) error {
+	m := make(map[string]interface{})
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if v, ok := m["visible"]; ok {
+		vis := false
+		if vis, ok = v.(bool); !ok {
+			return fmt.Errorf("sensors visible must be a boolean")
+		}
+		e.setVisible(vis)
+	}
+	return nil
+}
+
+func (e *sensorsElement) MarshalJSON() ([]byte, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	names := make([]string, 0, len(e.sensors))
+	for name := range e.sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sensors := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		sensors = append(sensors, e.sensorJSON(e.sensors[name]))
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"visible": e.visible,
+		"units":   e.units,
+		"timeout": e.timeout.String(),
+		"sensors": sensors,
+	})
+}
+
+// sensorJSON converts s to the element's units, the lock must be held
+func (e *sensorsElement) sensorJSON(s *sensor) map[string]interface{} {
+	history := make([]map[string]interface{}, 0, len(s.history))
+	for _, r := range s.history {
+		history = append(history, e.readingJSON(r))
+	}
+
+	ret := e.readingJSON(s.latest)
+	ret["name"] = s.name
+	ret["stale"] = s.stale
+	ret["history"] = history
+	return ret
+}
+
+func (e *sensorsElement) readingJSON(r sensorReading) map[string]interface{} {
+	u := unitSystems[e.units]
+	return map[string]interface{}{
+		"time":        r.Time,
+		"temperature": u.temperature(r.Temperature),
+		"humidity":    orMissing(r.Humidity),
+	}
+}
+
+// parseSensorReading accepts an object with temperature, humidity and
+// optional unit ("C" or "F") and time fields, or a bare temperature in
+// celsius
+func parseSensorReading(b []byte, now time.Time) (r sensorReading, err error) {
+	r = sensorReading{Time: now, Temperature: math.NaN(), Humidity: math.NaN()}
+
+	var t float64
+	if err = json.Unmarshal(b, &t); err == nil {
+		r.Temperature = t
+		return
+	}
+
+	var msg struct {
+		Temperature *float64   `json:"temperature"`
+		Humidity    *float64   `json:"humidity"`
+		Unit        string     `json:"unit"`
+		Time        *time.Time `json:"time"`
+	}
+	if err = json.Unmarshal(b, &msg); err != nil {
+		return
+	}
+	if msg.Temperature == nil && msg.Humidity == nil {
+		err = fmt.Errorf("sensor reading must have a temperature or humidity")
+		return
+	}
+
+	if msg.Temperature != nil {
+		switch strings.ToUpper(msg.Unit) {
+		case "", "C":
+			r.Temperature = *msg.Temperature
+		case "F":
+			r.Temperature = fahrenheitToCelsius(*msg.Temperature)
+		default:
+			err = fmt.Errorf("sensor unit must be C or F")
+			return
+		}
+	}
+	if msg.Humidity != nil {
+		if *msg.Humidity < 0 || *msg.Humidity > 100 {
+			err = fmt.Errorf("sensor humidity must be between 0 and 100")
+			return
+		}
+		r.Humidity = *msg.Humidity
+	}
+	if msg.Time != nil {
+		r.Time = *msg.Time
+	}
+	return
+}
+
+// Record parses a reading for the named sensor, creating the sensor if it
+// hasn't reported before
+func (e *sensorsElement) Record(name string, b []byte) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("sensor name '%s' is not valid", name)
+	}
+
+	r, err := parseSensorReading(b, time.Now())
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	s, ok := e.sensors[name]
+	if !ok {
+		s = &sensor{name: name}
+		e.sensors[name] = s
+	}
+	s.latest = r
+	s.stale = time.Since(r.Time) > e.timeout
+	s.history = append(s.history, r)
+	if len(s.history) > sensorHistory {
+		s.history = s.history[len(s.history)-sensorHistory:]
+	}
+	e.lock.Unlock()
+
+	e.readingsUpdated()
+	return nil
+}
+
+// readingsUpdated notifies without blocking, a pending notification already
+// covers this one
+func (e *sensorsElement) readingsUpdated() {
+	select {
+	case e.readingsChanged <- true:
+	default:
+	}
+}
+
+// staleThread marks sensors stale when they haven't reported within the
+// timeout
+func (e *sensorsElement) staleThread() {
+	for {
+		e.lock.Lock()
+		wait := e.timeout / 4
+		e.lock.Unlock()
+		if wait < time.Second {
+			wait = time.Second
+		}
+		time.Sleep(wait)
+
+		now := time.Now()
+		modified := false
+
+		e.lock.Lock()
+		for _, s := range e.sensors {
+			stale := now.Sub(s.latest.Time) > e.timeout
+			if stale != s.stale {
+				if stale {
+					log.Printf("sensor %s has not reported since %v", s.name, s.latest.Time)
+				}
+				s.stale = stale
+				modified = true
+			}
+		}
+		e.lock.Unlock()
+
+		if modified {
+			e.readingsUpdated()
+		}
+	}
+}
+
+// Subscribe receives readings from an MQTT broker at addr, logging in as
+// username if it isn't empty.  The sensor name is the part of the topic
+// after the filter's wildcard, or its last level.  It reconnects with a
+// backoff until the process exits.
+func (e *sensorsElement) Subscribe(addr string, topic string, username string, password string) {
+	hostname, _ := os.Hostname()
+	opts := mqtt.Options{ClientID: "mirror-" + hostname, Username: username, Password: password}
+	prefix := topic
+	if i := strings.IndexAny(topic, "#+"); i >= 0 {
+		prefix = topic[:i]
+	}
+
+	backoff := 5 * time.Second
+	for {
+		c, err := mqtt.Subscribe(addr, []string{topic}, opts)
+		if err != nil {
+			log.Printf("error subscribing to %s on %s: %v, retrying in %v", topic, addr, err, backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > 5*time.Minute {
+				backoff = 5 * time.Minute
+			}
+			continue
+		}
+		log.Printf("subscribed to %s on %s", topic, addr)
+		backoff = 5 * time.Second
+
+		for m := range c.Messages() {
+			name := strings.TrimPrefix(m.Topic, prefix)
+			if name == "" || strings.Contains(name, "/") {
+				name = m.Topic[strings.LastIndex(m.Topic, "/")+1:]
+			}
+			if err := e.Record(name, m.Payload); err != nil {
+				log.Printf("error recording %s: %v", m.Topic, err)
+			}
+		}
+		log.Printf("mqtt connection to %s closed: %v", addr, c.Err())
+	}
+}
+
+func (e *sensorsElement) Timeout() time.Duration {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.timeout
+}
+
+func (e *sensorsElement) SetUnits(units string) error {
+	if err := validUnits(units); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	modified := e.units != units
+	e.units = units
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+	return nil
+}
+
+func (e *sensorsElement) Visible() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.visible
+}
+
+func (e *sensorsElement) Name() string {
+	return "Sensors"
+}
+
+func (e *sensorsElement) Show() {
+	e.setVisible(true)
+}
+
+func (e *sensorsElement) Hide() {
+	e.setVisible(false)
+}
+
+func (e *sensorsElement) setVisible(vis bool) {
+	e.lock.Lock()
+	modified := e.visible != vis
+	e.visible = vis
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSensorReadingsNotPersisted(t *testing.T) {
+	e := &sensorsElement{
+		units:           "metric",
+		timeout:         time.Hour,
+		sensors:         make(map[string]*sensor),
+		changed:         make(chan bool, 1),
+		readingsChanged: make(chan bool, 1),
+		lock:            &sync.Mutex{},
+	}
+
+	for _, b := range []string{`21.5`, `{"temperature":70,"unit":"F","humidity":40}`} {
+		if err := e.Record("kitchen", []byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-e.changed:
+		t.Errorf("a reading sent a persisted change")
+	default:
+	}
+	select {
+	case <-e.readingsChanged:
+	default:
+		t.Errorf("a reading wasn't sent to the clients")
+	}
+
+	s := e.sensors["kitchen"]
+	if len(s.history) != 2 || !approx(s.latest.Temperature, fahrenheitToCelsius(70)) || s.latest.Humidity != 40 {
+		t.Errorf("kitchen = %+v", s.latest)
+	}
+}
@@ -0,0 +1,47 @@
+package cec
+
+// #include <libcec/cecc.h>
+import "C"
+
+import (
+	"log"
+	"unsafe"
+)
+
+//export logMessageCallback
+func logMessageCallback(c unsafe.Pointer, msg *C.cec_log_message) C.int {
+	log.Println(C.GoString(msg.message))
+
+	return 0
+}
+
+//export commandReceived
+func commandReceived(c unsafe.Pointer, msg *C.cec_command) C.int {
+	// log.Printf("%v", msg)
+
+	conn := (*Connection)(c)
+
+	size := int(msg.parameters.size)
+	if size > len(msg.parameters.data) {
+		size = len(msg.parameters.data)
+	}
+	parameters := make([]uint8, size)
+	for i := range parameters {
+		parameters[i] = uint8(msg.parameters.data[i])
+	}
+
+	cmd := &Command{
+		Initiator:        uint32(msg.initiator),
+		Destination:      uint32(msg.destination),
+		ack:              int8(msg.ack),
+		eom:              int8(msg.eom),
+		Opcode:           int(msg.opcode),
+		Parameters:       parameters,
+		opcode_set:       int8(msg.opcode_set),
+		transmit_timeout: int32(msg.transmit_timeout),
+		Operation:        opcodes[int(msg.opcode)],
+	}
+	conn.commandReceived(cmd)
+
+	return 0
+}
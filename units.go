@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// unitSystem describes how measurements are displayed.  Providers always
+// report celsius, kph, mm of precipitation and cm of snow.
+type unitSystem struct {
+	Temperature   string `json:"temperature"`
+	Speed         string `json:"speed"`
+	Precipitation string `json:"precipitation"`
+	Snow          string `json:"snow"`
+	Icon          string `json:"icon"`
+}
+
+// mixed uses metric temperatures and precipitation with wind in mph
+var unitSystems = map[string]unitSystem{
+	"imperial": {Temperature: "F", Speed: "mph", Precipitation: "in", Snow: "in", Icon: "Degrees-Fahrenheit"},
+	"metric":   {Temperature: "C", Speed: "km/h", Precipitation: "mm", Snow: "cm", Icon: "Degrees-Celcius"},
+	"mixed":    {Temperature: "C", Speed: "mph", Precipitation: "mm", Snow: "cm", Icon: "Degrees-Celcius"},
+}
+
+func validUnits(units string) error {
+	if _, ok := unitSystems[units]; !ok {
+		return fmt.Errorf("units must be one of imperial, metric or mixed")
+	}
+	return nil
+}
+
+func (u unitSystem) temperature(c float64) float64 {
+	if u.Temperature == "F" {
+		return orMissing(celsiusToFahrenheit(c))
+	}
+	return orMissing(c)
+}
+
+func (u unitSystem) speed(kph float64) float64 {
+	if u.Speed == "mph" {
+		return orMissing(kphToMPH(kph))
+	}
+	return orMissing(kph)
+}
+
+func (u unitSystem) precipitation(mm float64) float64 {
+	if u.Precipitation == "in" {
+		return orMissing(mm / 25.4)
+	}
+	return orMissing(mm)
+}
+
+func (u unitSystem) snow(cm float64) float64 {
+	if u.Snow == "in" {
+		return orMissing(cm / 2.54)
+	}
+	return orMissing(cm)
+}
+
+// orMissing replaces NaN, which can't be marshalled, with -100
+func orMissing(v float64) float64 {
+	if math.IsNaN(v) {
+		return -100
+	}
+	return v
+}
@@ -3,54 +3,99 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
+	"reflect"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/donniet/mirror2/wunderground"
+	"github.com/donniet/mirror2/astro"
 )
 
 type weatherElement struct {
-	visible    bool
-	high       float64
-	low        float64
-	icon       string
-	date       time.Time
-	weatherURL string
-	client     *http.Client
-	changed    chan bool
-	ticker     *time.Ticker
-	err        error
-	lock       *sync.Mutex
-}
-
-func newWeatherElement(weatherURL string, changed chan bool, frequency time.Duration) (e *weatherElement) {
+	visible     bool
+	high        float64
+	low         float64
+	icon        string
+	date        time.Time
+	days        []weatherDay
+	hours       []weatherHour
+	units       string
+	forecast    *Forecast
+	alerts      *alertsElement
+	location    Location
+	saved       []*weatherElement
+	newProvider weatherProviderFactory
+	provider    WeatherProvider
+	client      *http.Client
+	cache       *responseCache
+	cacheDir    string
+	changed     chan bool
+	fetcher     *fetcher
+	lastUpdated time.Time
+	stale       bool
+	err         error
+	lock        *sync.Mutex
+}
+
+type weatherDay struct {
+	Date          time.Time `json:"date"`
+	High          float64   `json:"high"`
+	Low           float64   `json:"low"`
+	Icon          string    `json:"icon"`
+	Code          string    `json:"code"`
+	Pop           float64   `json:"pop"`
+	WindSpeed     float64   `json:"windSpeed"`
+	WindDirection float64   `json:"windDirection"`
+	Humidity      float64   `json:"humidity"`
+	Precipitation float64   `json:"precipitation"`
+	Snow          float64   `json:"snow"`
+	Conditions    string    `json:"conditions"`
+}
+
+type weatherHour struct {
+	Time          time.Time `json:"time"`
+	Temperature   float64   `json:"temperature"`
+	Icon          string    `json:"icon"`
+	Code          string    `json:"code"`
+	Pop           float64   `json:"pop"`
+	WindSpeed     float64   `json:"windSpeed"`
+	WindDirection float64   `json:"windDirection"`
+	Humidity      float64   `json:"humidity"`
+	Precipitation float64   `json:"precipitation"`
+	Conditions    string    `json:"conditions"`
+}
+
+func newWeatherElement(newProvider weatherProviderFactory, location Location, changed chan bool, frequency time.Duration, cacheDir string) (e *weatherElement) {
 	e = &weatherElement{
-		visible:    false,
-		high:       -100,
-		low:        -100,
-		icon:       "Sun",
-		date:       time.Now(),
-		weatherURL: weatherURL,
-		changed:    changed,
-		ticker:     time.NewTicker(frequency),
-		lock:       &sync.Mutex{},
-		client: &http.Client{
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					Timeout:   5 * time.Second,
-					KeepAlive: 5 * time.Second,
-				}).Dial,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ResponseHeaderTimeout: 10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
-			},
-		},
+		visible:     false,
+		high:        -100,
+		low:         -100,
+		icon:        "Sun",
+		date:        time.Now(),
+		units:       "imperial",
+		location:    location,
+		newProvider: newProvider,
+		provider:    newProvider(location),
+		cacheDir:    cacheDir,
+		changed:     changed,
+		stale:       true,
+		lock:        &sync.Mutex{},
 	}
+	e.fetcher = newFetcher("weather", frequency, e.fetchWeather)
+
+	var transport http.RoundTripper = newFetchTransport()
+	if cacheDir != "" {
+		var err error
+		if e.cache, err = newResponseCache(cacheDir); err != nil {
+			log.Printf("error creating weather cache, caching disabled: %v", err)
+		} else {
+			transport = e.cache.Transport(transport)
+		}
+	}
+	e.client = &http.Client{Transport: transport}
+
 	go e.fetchWeatherThread()
 	return e
 }
@@ -61,15 +106,42 @@ func (e *weatherElement) ServeJSON(path []string, msg *json.RawMessage) (*json.R
 		return (*json.RawMessage)(&b), err
 	}
 
+	switch path[0] {
+	case "alerts":
+		if e.alerts == nil {
+			return nil, &NotFoundError{Path: path}
+		}
+		return e.alerts.ServeJSON(path[1:], msg)
+	case "location":
+		if msg != nil {
+			loc, err := e.parseLocation(*msg)
+			if err != nil {
+				return nil, err
+			} else if err = e.SetLocation(loc); err != nil {
+				return nil, err
+			}
+		}
+		return serveValuePath(e.Location(), path[1:])
+	case "locations":
+		return e.serveJSONLocations(path[1:], msg)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch path[0] {
+	case "days":
+		return serveValuePath(e.days, path[1:])
+	case "hours":
+		return serveValuePath(e.hours, path[1:])
+	}
+
 	if len(path) > 1 {
 		return nil, &NotFoundError{Path: path}
 	}
 
 	var v interface{}
 
-	e.lock.Lock()
-	defer e.lock.Unlock()
-
 	switch path[0] {
 	case "visible":
 		if msg != nil {
@@ -86,6 +158,14 @@ func (e *weatherElement) ServeJSON(path []string, msg *json.RawMessage) (*json.R
 		v = e.icon
 	case "date":
 		v = e.date
+	case "lastUpdated":
+		v = e.lastUpdated
+	case "stale":
+		v = e.stale
+	case "units":
+		v = e.units
+	case "unitLabels":
+		v = unitSystems[e.units]
 	default:
 		return nil, &NotFoundError{Path: path}
 	}
@@ -113,6 +193,33 @@ func (e *weatherElement) UnmarshalJSON(b []byte) error {
 			e.Hide()
 		}
 	}
+	if l, ok := m["location"]; ok {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		loc, err := e.parseLocation(b)
+		if err != nil {
+			return err
+		} else if err = e.SetLocation(loc); err != nil {
+			return err
+		}
+	}
+	if l, ok := m["locations"]; ok {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		} else if err = e.setSaved(b); err != nil {
+			return err
+		}
+	}
+	if a, ok := m["alerts"]; ok && e.alerts != nil {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, e.alerts)
+	}
 	return nil
 }
 
@@ -120,11 +227,23 @@ func (e *weatherElement) MarshalJSON() ([]byte, error) {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 	r := map[string]interface{}{
-		"high":    e.high,
-		"low":     e.low,
-		"icon":    e.icon,
-		"visible": e.visible,
-		"date":    e.date,
+		"high":        e.high,
+		"low":         e.low,
+		"icon":        e.icon,
+		"visible":     e.visible,
+		"date":        e.date,
+		"days":        e.days,
+		"hours":       e.hours,
+		"units":       e.units,
+		"unitLabels":  unitSystems[e.units],
+		"provider":    e.provider.Name(),
+		"lastUpdated": e.lastUpdated,
+		"location":    e.location,
+		"locations":   e.saved,
+		"stale":       e.stale,
+	}
+	if e.alerts != nil {
+		r["alerts"] = e.alerts
 	}
 	if e.err != nil {
 		r["error"] = e.err.Error()
@@ -133,49 +252,323 @@ func (e *weatherElement) MarshalJSON() ([]byte, error) {
 }
 
 func (e *weatherElement) fetchWeatherThread() {
-	if e.err = e.fetchWeather(); e.err != nil {
-		log.Printf("error fetching weather: %v", e.err)
+	e.loadCached()
+	e.fetcher.run()
+}
+
+// Close stops fetching the weather
+func (e *weatherElement) Close() {
+	e.fetcher.Close()
+}
+
+// loadCached shows the last cached forecast, if any, so a restart without a
+// network connection still has something to display
+func (e *weatherElement) loadCached() {
+	if e.cache == nil {
+		return
 	}
 
-	for _ = range e.ticker.C {
-		if e.err = e.fetchWeather(); e.err != nil {
-			log.Printf("error fetching weather: %v", e.err)
-		}
+	e.lock.Lock()
+	provider := e.provider
+	e.lock.Unlock()
+
+	offline := e.cache.OfflineTransport()
+	f, err := provider.Forecast(&http.Client{Transport: offline})
+	if err != nil {
+		log.Printf("no cached weather: %v", err)
+		return
+	} else if len(f.Days) == 0 {
+		return
 	}
+
+	e.lock.Lock()
+	if provider != e.provider || e.forecast != nil {
+		e.lock.Unlock()
+		return
+	}
+	e.forecast = f
+	e.lastUpdated = offline.Oldest()
+	e.update()
+	e.stale = e.fetcher.Stale(e.lastUpdated, time.Now())
+	e.lock.Unlock()
+
+	e.changed <- true
 }
 
 func (e *weatherElement) fetchWeather() error {
-	var f wunderground.ForecastResponse
+	e.lock.Lock()
+	provider := e.provider
+	e.lock.Unlock()
 
-	if res, err := e.client.Get(e.weatherURL); err != nil {
-		return err
-	} else if b, err := ioutil.ReadAll(res.Body); err != nil {
+	f, err := provider.Forecast(e.client)
+	if err == nil && len(f.Days) == 0 {
+		err = fmt.Errorf("%s forecast does not contain any days", provider.Name())
+	}
+
+	now := time.Now()
+
+	e.lock.Lock()
+	if provider != e.provider {
+		// the location changed while fetching, this forecast is for the
+		// old location
+		e.lock.Unlock()
+		return nil
+	}
+	e.err = err
+	modified := false
+	if err == nil {
+		e.forecast = f
+		e.lastUpdated = now
+		modified = e.update()
+	}
+	if stale := e.fetcher.Stale(e.lastUpdated, now); stale != e.stale {
+		e.stale = stale
+		modified = true
+	}
+	e.lock.Unlock()
+
+	if modified {
+		e.changed <- true
+	}
+	return err
+}
+
+// update converts the last forecast into the current units and returns true
+// if anything visible has changed.  Daily icons are always the day variant,
+// hourly and current icons depend on whether the sun is up.  The lock must be
+// held.
+func (e *weatherElement) update() bool {
+	if e.forecast == nil || len(e.forecast.Days) == 0 {
+		return false
+	}
+
+	u := unitSystems[e.units]
+	lat, lon, zone := e.location.Latitude, e.location.Longitude, e.location.zone()
+
+	days := make([]weatherDay, 0, len(e.forecast.Days))
+	for _, d := range e.forecast.Days {
+		days = append(days, weatherDay{
+			Date:          d.Date,
+			High:          u.temperature(d.High),
+			Low:           u.temperature(d.Low),
+			Icon:          icons.Icon(d.Code, true),
+			Code:          d.Code,
+			Pop:           orMissing(d.Pop),
+			WindSpeed:     u.speed(d.WindSpeed),
+			WindDirection: orMissing(d.WindDirection),
+			Humidity:      orMissing(d.Humidity),
+			Precipitation: u.precipitation(d.Precipitation),
+			Snow:          u.snow(d.Snow),
+			Conditions:    d.Conditions,
+		})
+	}
+	hours := make([]weatherHour, 0, len(e.forecast.Hours))
+	for _, h := range e.forecast.Hours {
+		hours = append(hours, weatherHour{
+			Time:          h.Time,
+			Temperature:   u.temperature(h.Temperature),
+			Icon:          icons.Icon(h.Code, astro.Daylight(h.Time.In(zone), lat, lon)),
+			Code:          h.Code,
+			Pop:           orMissing(h.Pop),
+			WindSpeed:     u.speed(h.WindSpeed),
+			WindDirection: orMissing(h.WindDirection),
+			Humidity:      orMissing(h.Humidity),
+			Precipitation: u.precipitation(h.Precipitation),
+			Conditions:    h.Conditions,
+		})
+	}
+
+	icon := icons.Icon(days[0].Code, astro.Daylight(time.Now().In(zone), lat, lon))
+
+	same := reflect.DeepEqual(days, e.days) && reflect.DeepEqual(hours, e.hours) && icon == e.icon
+	e.days = days
+	e.hours = hours
+	e.high = days[0].High
+	e.low = days[0].Low
+	e.icon = icon
+	e.date = days[0].Date
+	return !same
+}
+
+// refreshIcons switches the current icon between day and night, it doesn't
+// notify so it can be called while handling another change
+func (e *weatherElement) refreshIcons() bool {
+	e.lock.Lock()
+	modified := e.update()
+	saved := e.saved
+	e.lock.Unlock()
+
+	for _, s := range saved {
+		if s.refreshIcons() {
+			modified = true
+		}
+	}
+	return modified
+}
+
+func (e *weatherElement) Units() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.units
+}
+
+func (e *weatherElement) SetUnits(units string) error {
+	if err := validUnits(units); err != nil {
 		return err
-	} else if err = json.Unmarshal(b, &f); err != nil {
+	}
+
+	e.lock.Lock()
+	modified := e.units != units
+	e.units = units
+	e.update()
+	saved := e.saved
+	e.lock.Unlock()
+
+	for _, s := range saved {
+		s.SetUnits(units)
+	}
+
+	if modified {
+		e.changed <- true
+	}
+	return nil
+}
+
+func (e *weatherElement) Location() Location {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.location
+}
+
+// SetLocation rebuilds the provider for the new location and fetches its
+// forecast immediately
+func (e *weatherElement) SetLocation(loc Location) error {
+	if err := loc.validate(); err != nil {
 		return err
-	} else if f.Forecast == nil ||
-		f.Forecast.SimpleForecast == nil ||
-		len(f.Forecast.SimpleForecast.ForecastDay) == 0 {
-		return fmt.Errorf("weather response does not contain a valid forecast: %#v", res)
+	}
+
+	e.lock.Lock()
+	if loc == e.location {
+		e.lock.Unlock()
+		return nil
+	}
+	e.location = loc
+	e.provider = e.newProvider(loc)
+	e.forecast = nil
+	e.lastUpdated = time.Time{}
+	e.stale = true
+	e.lock.Unlock()
+
+	log.Printf("weather location changed to %s (%f, %f)", loc.Name, loc.Latitude, loc.Longitude)
+
+	if e.alerts != nil {
+		e.alerts.SetLocation(loc)
+	}
+
+	e.fetcher.Refetch()
+	e.changed <- true
+	return nil
+}
+
+// parseLocation reads a location, looking up the coordinates by name when
+// they aren't given
+func (e *weatherElement) parseLocation(b []byte) (Location, error) {
+	var l struct {
+		Name      string   `json:"name"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		Timezone  string   `json:"timezone"`
+	}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return Location{}, err
+	}
+
+	if l.Latitude != nil && l.Longitude != nil {
+		return Location{
+			Name:      l.Name,
+			Latitude:  *l.Latitude,
+			Longitude: *l.Longitude,
+			Timezone:  l.Timezone,
+		}, nil
+	} else if l.Name == "" {
+		return Location{}, fmt.Errorf("location requires a name or a latitude and longitude")
+	}
+	return geocode(e.client, l.Name)
+}
+
+func (e *weatherElement) serveJSONLocations(path []string, msg *json.RawMessage) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if msg != nil {
+			if err := e.setSaved(*msg); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(e.Saved())
+		return (*json.RawMessage)(&b), err
+	}
+
+	saved := e.Saved()
+	if i, err := strconv.Atoi(path[0]); err != nil || i < 0 || i >= len(saved) {
+		return nil, &NotFoundError{Path: path}
 	} else {
-		forecastDay := f.Forecast.SimpleForecast.ForecastDay[0]
+		return saved[i].ServeJSON(path[1:], msg)
+	}
+}
 
-		e.lock.Lock()
+// setSaved replaces the saved locations, shown alongside the main location,
+// with the list of locations in b
+func (e *weatherElement) setSaved(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
 
-		if e.high, err = strconv.ParseFloat(forecastDay.High.Fahrenheit, 64); err != nil {
-			e.high = -100
+	// a saved location is either a location or a persisted weather element
+	locs := make([]Location, 0, len(raw))
+	for _, r := range raw {
+		var persisted struct {
+			Location *json.RawMessage `json:"location"`
 		}
-		if e.low, err = strconv.ParseFloat(forecastDay.Low.Fahrenheit, 64); err != nil {
-			e.low = -100
+		if err := json.Unmarshal(r, &persisted); err == nil && persisted.Location != nil {
+			r = *persisted.Location
 		}
-		e.icon = iconMap[forecastDay.Icon]
-		e.date = forecastDay.Date()
-		e.lock.Unlock()
+
+		loc, err := e.parseLocation(r)
+		if err != nil {
+			return err
+		} else if err = loc.validate(); err != nil {
+			return err
+		}
+		locs = append(locs, loc)
 	}
+
+	e.lock.Lock()
+	old := e.saved
+	e.saved = nil
+	for _, loc := range locs {
+		s := newWeatherElement(e.newProvider, loc, e.changed, e.fetcher.frequency, e.cacheDir)
+		s.lock.Lock()
+		s.units = e.units
+		s.lock.Unlock()
+		e.saved = append(e.saved, s)
+	}
+	e.lock.Unlock()
+
+	for _, s := range old {
+		s.Close()
+	}
+
 	e.changed <- true
 	return nil
 }
 
+func (e *weatherElement) Saved() []*weatherElement {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]*weatherElement(nil), e.saved...)
+}
+
 func (e *weatherElement) Visible() bool {
 	return e.visible
 }
@@ -212,41 +605,18 @@ func (e *weatherElement) Icon() string {
 	return e.icon
 }
 
-var iconMap = map[string]string{
-	"chanceflurries":    "Cloud-Snow-Sun-Alt",
-	"chancerain":        "Cloud-Rain-Sun-Alt",
-	"chancesleet":       "Cloud-Hail-Sun",
-	"chancesnow":        "Cloud-Snow-Sun-Alt",
-	"chancetstorms":     "Cloud-Lightning-Sun",
-	"clear":             "Sun",
-	"cloudy":            "Cloud",
-	"flurries":          "Cloud-Snow",
-	"fog":               "Cloud-Fog",
-	"hazy":              "Cloud-Fog-Sun",
-	"mostlycloudy":      "Cloud-Sun",
-	"nt_chanceflurries": "Cloud-Snow-Moon-Alt",
-	"nt_chancerain":     "Cloud-Rain-Moon-Alt",
-	"nt_chancesleet":    "Cloud-Hail-Moon",
-	"nt_chancesnow":     "Cloud-Snow-Moon-Alt",
-	"nt_chancetstorms":  "Cloud-Lightning-Moon",
-	"nt_clear":          "Moon",
-	"nt_cloudy":         "Cloud-Moon",
-	"nt_flurries":       "Cloud-Snow",
-	"nt_fog":            "Cloud-Fog",
-	"nt_hazy":           "CLoud-Fog-Moon",
-	"nt_mostlysunny":    "Cloud-Moon",
-	"nt_partlycloudy":   "Cloud-Moon",
-	"nt_partlysunny":    "Cloud-Moon",
-	"nt_rain":           "Cloud-Rain",
-	"nt_sleet":          "Cloud-Hail",
-	"nt_snow":           "Cloud-Snow",
-	"nt_sunny":          "Cloud-Moon",
-	"nt_tstorms":        "Cloud-Lightning",
-	"partlycloudy":      "Cloud-Sun",
-	"partlysunny":       "Cloud-Sun",
-	"rain":              "Cloud-Rain",
-	"sleet":             "Cloud-Hail",
-	"snow":              "Cloud-Snow",
-	"sunny":             "Sun",
-	"tstorms":           "Cloud-Lightning",
+func (e *weatherElement) Alerts() *alertsElement {
+	return e.alerts
+}
+
+func (e *weatherElement) Days() []weatherDay {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]weatherDay(nil), e.days...)
+}
+
+func (e *weatherElement) Hours() []weatherHour {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]weatherHour(nil), e.hours...)
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeatherIconsUseLocationZone(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	day := time.Date(2019, time.June, 21, 0, 0, 0, 0, chicago)
+
+	e := &weatherElement{
+		units:    "metric",
+		location: minneapolis,
+		lock:     &sync.Mutex{},
+		forecast: &Forecast{
+			Days: []ForecastDay{{Date: day, Code: "clear"}},
+			Hours: []ForecastHour{
+				// providers may parse times in utc, both are the 21st in
+				// Minneapolis but the evening is the 22nd in utc
+				{Time: day.Add(20 * time.Hour).UTC(), Code: "clear"},
+				{Time: day.Add(23 * time.Hour).UTC(), Code: "clear"},
+			},
+		},
+	}
+	e.update()
+
+	for i, want := range []string{"Sun", "Moon"} {
+		if e.hours[i].Icon != want {
+			t.Errorf("icon at %v = %s, want %s", e.hours[i].Time.In(chicago), e.hours[i].Icon, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+)
+
+const weatherUserAgent = "mirror2 (github.com/donniet/mirror2)"
+
+// WeatherProvider fetches a forecast from a weather service.  Temperatures in
+// the returned forecast are in celsius, wind speeds in kph, precipitation in
+// mm, snow in cm, directions in degrees, probabilities and humidity in
+// percent and missing values are NaN.
+type WeatherProvider interface {
+	Name() string
+	Forecast(client *http.Client) (*Forecast, error)
+}
+
+type Forecast struct {
+	Days  []ForecastDay
+	Hours []ForecastHour
+}
+
+// Code is the provider independent condition, one of the keys of
+// conditionIcons, that picks the icon.  Conditions is the provider's
+// description.
+type ForecastDay struct {
+	Date          time.Time
+	High          float64
+	Low           float64
+	Code          string
+	Pop           float64
+	WindSpeed     float64
+	WindDirection float64
+	Humidity      float64
+	Precipitation float64
+	Snow          float64
+	Conditions    string
+}
+
+type ForecastHour struct {
+	Time          time.Time
+	Temperature   float64
+	Code          string
+	Pop           float64
+	WindSpeed     float64
+	WindDirection float64
+	Humidity      float64
+	Precipitation float64
+	Conditions    string
+}
+
+// Location is where a forecast is for.  Timezone is the IANA name, e.g.
+// America/Chicago, and may be empty.
+type Location struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  string  `json:"timezone,omitempty"`
+}
+
+func (loc Location) validate() error {
+	if loc.Latitude < -90 || loc.Latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	} else if loc.Longitude < -180 || loc.Longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	} else if loc.Timezone != "" {
+		if _, err := time.LoadLocation(loc.Timezone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zone is the location's time zone.  Without a valid Timezone it falls back
+// to the zone nearest its longitude, the host's zone could be a day off.
+func (loc Location) zone() *time.Location {
+	if loc.Timezone != "" {
+		if z, err := time.LoadLocation(loc.Timezone); err == nil {
+			return z
+		}
+	}
+	return time.FixedZone("", int(math.Round(loc.Longitude/15))*3600)
+}
+
+type weatherConfig struct {
+	WundergroundKey string
+}
+
+// weatherProviderFactory builds the provider requests for a location
+type weatherProviderFactory func(loc Location) WeatherProvider
+
+type alertProviderFactory func(loc Location) AlertProvider
+
+func newWeatherProviderFactory(name string, conf weatherConfig) (weatherProviderFactory, error) {
+	switch name {
+	case "wunderground":
+		if conf.WundergroundKey == "" {
+			return nil, fmt.Errorf("wunderground provider requires an api key")
+		}
+		return func(loc Location) WeatherProvider {
+			return newWundergroundProvider(conf.WundergroundKey, fmt.Sprintf("%.4f,%.4f", loc.Latitude, loc.Longitude))
+		}, nil
+	case "openmeteo":
+		return func(loc Location) WeatherProvider {
+			return newOpenMeteoProvider(loc.Latitude, loc.Longitude)
+		}, nil
+	case "nws":
+		return func(loc Location) WeatherProvider {
+			return newNWSProvider(loc.Latitude, loc.Longitude)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown weather provider '%s'", name)
+	}
+}
+
+func newAlertProviderFactory(name string, conf weatherConfig) (alertProviderFactory, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "nws":
+		return func(loc Location) AlertProvider {
+			return newNWSAlertProvider(loc.Latitude, loc.Longitude)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert provider '%s'", name)
+	}
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	b, err := getBody(client, url, "application/json")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func getXML(client *http.Client, url string, v interface{}) error {
+	b, err := getBody(client, url, "application/atom+xml")
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
+func getBody(client *http.Client, url string, accept string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", weatherUserAgent)
+	req.Header.Set("Accept", accept)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, res.Status)
+	}
+	return b, nil
+}
+
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+func kphToMPH(kph float64) float64 {
+	return kph / 1.609344
+}
+
+func mphToKPH(mph float64) float64 {
+	return mph * 1.609344
+}
+
+var compassDegrees = map[string]float64{
+	"N": 0, "NNE": 22.5, "NE": 45, "ENE": 67.5,
+	"E": 90, "ESE": 112.5, "SE": 135, "SSE": 157.5,
+	"S": 180, "SSW": 202.5, "SW": 225, "WSW": 247.5,
+	"W": 270, "WNW": 292.5, "NW": 315, "NNW": 337.5,
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureServer serves files from testdata by request path, replacing
+// {{server}} with the server's url so fixtures can link to each other.  The
+// requests it receives are recorded.
+func fixtureServer(t *testing.T, files map[string]string) (*httptest.Server, *[]*http.Request) {
+	t.Helper()
+
+	var requests []*http.Request
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+
+		name, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Errorf("reading fixture: %v", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write([]byte(strings.Replace(string(b), "{{server}}", server.URL, -1)))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// approx compares floats to a millionth, NaN equals NaN
+func approx(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestGetBodyHeaders(t *testing.T) {
+	server, requests := fixtureServer(t, map[string]string{
+		"/forecast": "openmeteo/forecast.json",
+	})
+
+	if _, err := getBody(server.Client(), server.URL+"/forecast", "application/json"); err != nil {
+		t.Fatal(err)
+	}
+	r := (*requests)[0]
+	if ua := r.Header.Get("User-Agent"); ua != weatherUserAgent {
+		t.Errorf("User-Agent = %q, want %q", ua, weatherUserAgent)
+	}
+	if accept := r.Header.Get("Accept"); accept != "application/json" {
+		t.Errorf("Accept = %q, want application/json", accept)
+	}
+}
+
+func TestGetBodyStatus(t *testing.T) {
+	server, _ := fixtureServer(t, nil)
+
+	if _, err := getBody(server.Client(), server.URL+"/missing", "application/json"); err == nil {
+		t.Error("expected an error for a 404")
+	}
+}
+
+func TestNewWeatherProviderFactory(t *testing.T) {
+	loc := Location{Latitude: 44.9778, Longitude: -93.265}
+
+	for _, name := range []string{"openmeteo", "nws"} {
+		f, err := newWeatherProviderFactory(name, weatherConfig{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if p := f(loc); p.Name() != name {
+			t.Errorf("provider name = %s, want %s", p.Name(), name)
+		}
+	}
+
+	if _, err := newWeatherProviderFactory("wunderground", weatherConfig{}); err == nil {
+		t.Error("expected wunderground without a key to fail")
+	}
+	if _, err := newWeatherProviderFactory("yahoo", weatherConfig{}); err == nil {
+		t.Error("expected an unknown provider to fail")
+	}
+}
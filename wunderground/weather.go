@@ -6,8 +6,9 @@ import (
 )
 
 type ForecastResponse struct {
-	Forecast *Forecast `json:"forecast,omitempty"`
-	Response Response  `json:"response,omitempty"`
+	Forecast       *Forecast        `json:"forecast,omitempty"`
+	HourlyForecast []HourlyForecast `json:"hourly_forecast,omitempty"`
+	Response       Response         `json:"response,omitempty"`
 }
 
 type Response struct {
@@ -18,6 +19,7 @@ type Response struct {
 
 type Features struct {
 	Forecast int `json:"forecast,omitempty"`
+	Hourly   int `json:"hourly,omitempty"`
 }
 
 type Forecast struct {
@@ -109,3 +111,31 @@ type DateTime struct {
 	Year           int    `json:"year"`
 }
 
+
+type HourlyForecast struct {
+	FCTTime   DateTime `json:"FCTTIME"`
+	Temp      Measure  `json:"temp"`
+	Condition string   `json:"condition"`
+	Icon      string   `json:"icon"`
+	IconURL   string   `json:"icon_url"`
+	WindSpeed Measure  `json:"wspd"`
+	WindDir   WindDir  `json:"wdir"`
+	Humidity  string   `json:"humidity"`
+	Pop       string   `json:"pop"`
+	QPF       Measure  `json:"qpf"`
+}
+
+func (hourly HourlyForecast) Time() time.Time {
+	sec, _ := strconv.ParseInt(hourly.FCTTime.Epoch, 10, 64)
+	return time.Unix(sec, 0)
+}
+
+type Measure struct {
+	English string `json:"english"`
+	Metric  string `json:"metric"`
+}
+
+type WindDir struct {
+	Direction string `json:"dir"`
+	Degrees   string `json:"degrees"`
+}
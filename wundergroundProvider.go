@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/donniet/mirror2/wunderground"
+)
+
+type wundergroundProvider struct {
+	baseURL string
+	apiKey  string
+	query   string
+}
+
+func newWundergroundProvider(apiKey string, query string) *wundergroundProvider {
+	return &wundergroundProvider{
+		baseURL: "http://api.wunderground.com/api",
+		apiKey:  apiKey,
+		query:   query,
+	}
+}
+
+func (p *wundergroundProvider) Name() string {
+	return "wunderground"
+}
+
+func (p *wundergroundProvider) URL() string {
+	return fmt.Sprintf("%s/%s/forecast/hourly/q/%s.json", p.baseURL, p.apiKey, p.query)
+}
+
+func (p *wundergroundProvider) Forecast(client *http.Client) (*Forecast, error) {
+	var f wunderground.ForecastResponse
+
+	if err := getJSON(client, p.URL(), &f); err != nil {
+		return nil, err
+	} else if f.Forecast == nil ||
+		f.Forecast.SimpleForecast == nil ||
+		len(f.Forecast.SimpleForecast.ForecastDay) == 0 {
+		return nil, fmt.Errorf("weather response does not contain a valid forecast: %#v", f)
+	}
+
+	// temperatures are read in fahrenheit, the celsius strings are rounded
+	// to whole degrees and a 75F high would come back as 75.2F
+	ret := &Forecast{}
+	for _, forecastDay := range f.Forecast.SimpleForecast.ForecastDay {
+		ret.Days = append(ret.Days, ForecastDay{
+			Date:          forecastDay.Date(),
+			High:          fahrenheitToCelsius(parseFloat(forecastDay.High.Fahrenheit)),
+			Low:           fahrenheitToCelsius(parseFloat(forecastDay.Low.Fahrenheit)),
+			Code:          wundergroundCondition(forecastDay.Icon),
+			Pop:           float64(forecastDay.Pop),
+			WindSpeed:     float64(forecastDay.AverageWind.KPH),
+			WindDirection: float64(forecastDay.AverageWind.Degrees),
+			Humidity:      float64(forecastDay.AvererageHumidity),
+			Precipitation: float64(forecastDay.QPFAllDay.MM),
+			Snow:          float64(forecastDay.SnowAllDay.CM),
+			Conditions:    forecastDay.Conditions,
+		})
+	}
+	for _, hour := range f.HourlyForecast {
+		ret.Hours = append(ret.Hours, ForecastHour{
+			Time:          hour.Time(),
+			Temperature:   fahrenheitToCelsius(parseFloat(hour.Temp.English)),
+			Code:          wundergroundCondition(hour.Icon),
+			Pop:           parseFloat(hour.Pop),
+			WindSpeed:     parseFloat(hour.WindSpeed.Metric),
+			WindDirection: parseFloat(hour.WindDir.Degrees),
+			Humidity:      parseFloat(hour.Humidity),
+			Precipitation: parseFloat(hour.QPF.Metric),
+			Conditions:    hour.Condition,
+		})
+	}
+	return ret, nil
+}
+
+func parseFloat(s string) float64 {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		return t
+	}
+	return math.NaN()
+}
+
+// wundergroundCondition ignores the nt_ prefix, day and night are decided by
+// the sun at the mirror's location
+func wundergroundCondition(icon string) string {
+	return wundergroundConditions[strings.TrimPrefix(icon, "nt_")]
+}
+
+var wundergroundConditions = map[string]string{
+	"chanceflurries": "chancesnow",
+	"chancerain":     "chancerain",
+	"chancesleet":    "chancesleet",
+	"chancesnow":     "chancesnow",
+	"chancetstorms":  "chancetstorms",
+	"clear":          "clear",
+	"cloudy":         "cloudy",
+	"flurries":       "flurries",
+	"fog":            "fog",
+	"hazy":           "haze",
+	"mostlycloudy":   "mostlycloudy",
+	"mostlysunny":    "mostlyclear",
+	"partlycloudy":   "partlycloudy",
+	"partlysunny":    "mostlycloudy",
+	"rain":           "rain",
+	"sleet":          "sleet",
+	"snow":           "snow",
+	"sunny":          "clear",
+	"tstorms":        "tstorms",
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWundergroundForecast(t *testing.T) {
+	server, requests := fixtureServer(t, map[string]string{
+		"/KEY/forecast/hourly/q/44.9778,-93.2650.json": "wunderground/forecast_hourly.json",
+	})
+
+	p := newWundergroundProvider("KEY", "44.9778,-93.2650")
+	p.baseURL = server.URL
+
+	f, err := p.Forecast(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Errorf("made %d requests, want 1", len(*requests))
+	}
+
+	if len(f.Days) != 2 {
+		t.Fatalf("got %d days, want 2", len(f.Days))
+	}
+	d := f.Days[0]
+	// whole fahrenheit degrees survive the round trip through celsius
+	if high := celsiusToFahrenheit(d.High); !approx(high, 75) {
+		t.Errorf("high = %vF, want 75F", high)
+	}
+	if low := celsiusToFahrenheit(d.Low); !approx(low, 58) {
+		t.Errorf("low = %vF, want 58F", low)
+	}
+	if d.Code != "partlycloudy" || d.Conditions != "Partly Cloudy" {
+		t.Errorf("condition = %s (%s), want partlycloudy", d.Code, d.Conditions)
+	}
+	if d.Pop != 20 || d.WindSpeed != 14 || d.WindDirection != 310 || d.Humidity != 55 || d.Precipitation != 1 {
+		t.Errorf("unexpected day %+v", d)
+	}
+	if d.Date.Unix() != 1529625600 {
+		t.Errorf("date = %v", d.Date)
+	}
+	if f.Days[1].Code != "chancetstorms" {
+		t.Errorf("second day condition = %s, want chancetstorms", f.Days[1].Code)
+	}
+
+	if len(f.Hours) != 1 {
+		t.Fatalf("got %d hours, want 1", len(f.Hours))
+	}
+	h := f.Hours[0]
+	if temp := celsiusToFahrenheit(h.Temperature); !approx(temp, 72) {
+		t.Errorf("hourly temperature = %vF, want 72F", temp)
+	}
+	// the night prefix is dropped, the sun decides day and night
+	if h.Code != "clear" {
+		t.Errorf("hourly condition = %s, want clear", h.Code)
+	}
+	if h.WindSpeed != 10 || h.WindDirection != 315 || h.Humidity != 48 || h.Pop != 0 {
+		t.Errorf("unexpected hour %+v", h)
+	}
+}
+
+func TestWundergroundForecastInvalid(t *testing.T) {
+	server, _ := fixtureServer(t, map[string]string{
+		"/KEY/forecast/hourly/q/here.json": "openmeteo/error.json",
+	})
+
+	p := newWundergroundProvider("KEY", "here")
+	p.baseURL = server.URL
+
+	if _, err := p.Forecast(server.Client()); err == nil {
+		t.Error("expected a response without a forecast to fail")
+	}
+}